@@ -6,3 +6,7 @@ import (
 
 //go:embed skins/*
 var SkinsFS embed.FS
+
+// each directory is a set of sound effects, with one wav file per effect
+//go:embed sounds/*
+var SoundsFS embed.FS
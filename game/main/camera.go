@@ -0,0 +1,134 @@
+package main
+
+import (
+    "math"
+    "slices"
+
+    "github.com/hajimehoshi/ebiten/v2"
+    "github.com/solarlune/tetra3d"
+)
+
+// in debug mode, saves the free camera as the custom camera while playing
+const SaveCameraKey = ebiten.KeyF8
+
+// the name shown for the camera the player set up themselves
+const CustomCameraName = "Custom"
+
+// the field of view can be set between these, in degrees
+const MinCameraFieldOfView = 10
+const MaxCameraFieldOfView = 120
+
+// where the camera sits while playing and the point on the highway it looks at
+type CameraSettings struct {
+    X float32 `json:"x"`
+    Y float32 `json:"y"`
+    Z float32 `json:"z"`
+    LookX float32 `json:"look_x"`
+    LookY float32 `json:"look_y"`
+    LookZ float32 `json:"look_z"`
+    FieldOfView float32 `json:"field_of_view"`
+}
+
+type CameraPreset struct {
+    Name string
+    Camera CameraSettings
+}
+
+var CameraPresets = []CameraPreset{
+    CameraPreset{
+        Name: "Classic",
+        Camera: CameraSettings{X: 0, Y: 55, Z: 145, LookX: 0, LookY: 5, LookZ: -60, FieldOfView: 30},
+    },
+    // looking down on the highway from above
+    CameraPreset{
+        Name: "Steep",
+        Camera: CameraSettings{X: 0, Y: 120, Z: 90, LookX: 0, LookY: 0, LookZ: -70, FieldOfView: 35},
+    },
+    // low behind the buttons, so the highway stretches towards the horizon
+    CameraPreset{
+        Name: "Flat",
+        Camera: CameraSettings{X: 0, Y: 25, Z: 160, LookX: 0, LookY: 10, LookZ: -100, FieldOfView: 30},
+    },
+    CameraPreset{
+        Name: "Close",
+        Camera: CameraSettings{X: 0, Y: 40, Z: 85, LookX: 0, LookY: 0, LookZ: -50, FieldOfView: 40},
+    },
+}
+
+// the camera part of the settings
+type CameraConfig struct {
+    // the name of a preset, or CustomCameraName
+    Preset string `json:"preset"`
+    Custom CameraSettings `json:"custom"`
+}
+
+func DefaultCameraConfig() CameraConfig {
+    return CameraConfig{
+        Preset: CameraPresets[0].Name,
+        Custom: CameraPresets[0].Camera,
+    }
+}
+
+// the names that can be picked, with the custom camera last
+func CameraNames() []string {
+    var names []string
+    for _, preset := range CameraPresets {
+        names = append(names, preset.Name)
+    }
+    return append(names, CustomCameraName)
+}
+
+// the camera to play with. an unknown preset gets the first one
+func (config *CameraConfig) Current() CameraSettings {
+    if config.Preset == CustomCameraName {
+        return config.Custom
+    }
+
+    index := max(0, slices.IndexFunc(CameraPresets, func(preset CameraPreset) bool {
+        return preset.Name == config.Preset
+    }))
+
+    return CameraPresets[index].Camera
+}
+
+// change the custom camera, starting from whichever camera is being used
+func (config *CameraConfig) Customize(change func(camera *CameraSettings)) {
+    config.Custom = config.Current()
+    config.Preset = CustomCameraName
+    change(&config.Custom)
+    config.Custom.FieldOfView = min(MaxCameraFieldOfView, max(MinCameraFieldOfView, config.Custom.FieldOfView))
+}
+
+func (settings CameraSettings) Position() tetra3d.Vector3 {
+    return tetra3d.NewVector3(settings.X, settings.Y, settings.Z)
+}
+
+func (settings CameraSettings) LookAt() tetra3d.Vector3 {
+    return tetra3d.NewVector3(settings.LookX, settings.LookY, settings.LookZ)
+}
+
+// place the camera. the far plane is pushed out to see the end of a neck that reaches 'reach'
+// past the buttons
+func (settings CameraSettings) Apply(camera *tetra3d.Camera, reach float32) {
+    camera.SetLocalPosition(settings.X, settings.Y, settings.Z)
+    camera.SetFieldOfView(settings.FieldOfView)
+    camera.SetLocalRotation(tetra3d.NewMatrix4LookAt(settings.LookAt(), settings.Position(), tetra3d.NewVector3(0, 1, 0)))
+
+    depth := float64(settings.Z + reach)
+    camera.SetFar(float32(math.Sqrt(depth * depth + float64(settings.Y * settings.Y))))
+}
+
+// the settings of a camera that was moved around, with the field of view kept within the limits
+// the settings allow
+func CameraSettingsFrom(camera *tetra3d.Camera, lookAt tetra3d.Vector3) CameraSettings {
+    position := camera.WorldPosition()
+    return CameraSettings{
+        X: position.X,
+        Y: position.Y,
+        Z: position.Z,
+        LookX: lookAt.X,
+        LookY: lookAt.Y,
+        LookZ: lookAt.Z,
+        FieldOfView: min(MaxCameraFieldOfView, max(MinCameraFieldOfView, camera.FieldOfView())),
+    }
+}
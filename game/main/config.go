@@ -0,0 +1,412 @@
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "log"
+    "time"
+    "bufio"
+    "errors"
+    "io/fs"
+    "path/filepath"
+    "encoding/json"
+)
+
+// the settings file is versioned so older files can be brought up to date. version 0 is the
+// original config.json, which only held the keyboard and gamepad bindings
+const SettingsVersion = 1
+
+const SettingsFile = "config.json"
+const PlaylistsFile = "playlists.json"
+
+// the directory inside the user's config directory that everything is kept in
+const ConfigDirectoryName = "rhythm"
+
+type VideoSettings struct {
+    Fullscreen bool `json:"fullscreen"`
+    VSync bool `json:"vsync"`
+}
+
+// what the song setup screen starts with
+type GameplaySettings struct {
+    Difficulty string `json:"difficulty"`
+    AlternateStrum bool `json:"alternate_strum"`
+}
+
+type LibrarySettings struct {
+    // directories that are searched for songs
+    SongPaths []string `json:"song_paths"`
+}
+
+type Settings struct {
+    Version int `json:"version"`
+    Video VideoSettings `json:"video"`
+    Audio MixerSettings `json:"audio"`
+    Gameplay GameplaySettings `json:"gameplay"`
+    // each player has their own controls
+    Players SerializedPlayerLibrary `json:"players"`
+    Library LibrarySettings `json:"library"`
+    Camera CameraConfig `json:"camera"`
+    // turns on the free camera keys while playing
+    Debug bool `json:"debug"`
+}
+
+func DefaultSettings() *Settings {
+    return &Settings{
+        Version: SettingsVersion,
+        Video: VideoSettings{
+            VSync: true,
+        },
+        Audio: DefaultMixerSettings(),
+        Gameplay: GameplaySettings{
+            Difficulty: DefaultSongSettings().Difficulty,
+        },
+        Players: NewPlayerLibrary().Serialized(),
+        Library: LibrarySettings{
+            SongPaths: []string{"."},
+        },
+        Camera: DefaultCameraConfig(),
+    }
+}
+
+func (settings *Settings) Serialize(out io.Writer) error {
+    encoder := json.NewEncoder(out)
+    encoder.SetIndent("", "  ")
+    return encoder.Encode(settings)
+}
+
+// the song settings a new song starts with
+func (settings *GameplaySettings) SongSettings() SongSettings {
+    song := DefaultSongSettings()
+    if settings.Difficulty != "" {
+        song.Difficulty = settings.Difficulty
+        song.Timing = TimingWindowsForDifficulty(settings.Difficulty)
+    }
+    song.AlternateStrum = settings.AlternateStrum
+    return song
+}
+
+// brings the raw document of settingsMigrations[i] from version i to version i+1
+type settingsMigration func(document map[string]json.RawMessage) (map[string]json.RawMessage, error)
+
+var settingsMigrations = []settingsMigration{
+    // 0 to 1: the bindings that made up the whole file become the controls of the default player
+    func(document map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+        var input SerializedInputProfile
+        if len(document) > 0 {
+            data, err := json.Marshal(document)
+            if err != nil {
+                return nil, err
+            }
+
+            err = json.Unmarshal(data, &input)
+            if err != nil {
+                return nil, err
+            }
+        } else {
+            input = NewInputProfile().Serialized()
+        }
+
+        player := NewPlayerProfile(DefaultPlayerName).Serialized()
+        player.Input = input
+
+        players, err := json.Marshal(SerializedPlayerLibrary{
+            Current: player.Name,
+            Players: []SerializedPlayerProfile{player},
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        return map[string]json.RawMessage{"players": players}, nil
+    },
+}
+
+// read a settings file of any version, migrating it to the current one. sections that are
+// missing get their defaults
+func ParseSettings(data []byte) (*Settings, error) {
+    var document map[string]json.RawMessage
+    err := json.Unmarshal(data, &document)
+    if err != nil {
+        return nil, err
+    }
+
+    version := 0
+    raw, ok := document["version"]
+    if ok {
+        err = json.Unmarshal(raw, &version)
+        if err != nil {
+            return nil, fmt.Errorf("Unable to read settings version: %v", err)
+        }
+    }
+
+    if version < 0 || version > SettingsVersion {
+        return nil, fmt.Errorf("Unknown settings version %v, expected at most %v", version, SettingsVersion)
+    }
+
+    delete(document, "version")
+    for ; version < SettingsVersion; version++ {
+        document, err = settingsMigrations[version](document)
+        if err != nil {
+            return nil, fmt.Errorf("Unable to migrate settings from version %v: %v", version, err)
+        }
+    }
+
+    data, err = json.Marshal(document)
+    if err != nil {
+        return nil, err
+    }
+
+    settings := DefaultSettings()
+    err = json.Unmarshal(data, settings)
+    if err != nil {
+        return nil, err
+    }
+
+    settings.Version = SettingsVersion
+    settings.Audio.clamp()
+    if len(settings.Players.Players) == 0 {
+        settings.Players = NewPlayerLibrary().Serialized()
+    }
+
+    return settings, nil
+}
+
+// write a file by writing a temporary file next to it and renaming it over the original, so a
+// crash part way through never leaves a half written file behind
+func writeFileAtomic(path string, doSave func (io.Writer) error) error {
+    temporary, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path) + ".*.tmp")
+    if err != nil {
+        return err
+    }
+
+    // does nothing once the file has been renamed
+    defer os.Remove(temporary.Name())
+
+    buffer := bufio.NewWriter(temporary)
+    err = doSave(buffer)
+    if err == nil {
+        err = buffer.Flush()
+    }
+    if err == nil {
+        err = temporary.Sync()
+    }
+
+    closeErr := temporary.Close()
+    if err == nil {
+        err = closeErr
+    }
+
+    if err != nil {
+        return err
+    }
+
+    return os.Rename(temporary.Name(), path)
+}
+
+// move a file that couldn't be read out of the way, keeping it for the player to look at
+func backupFile(path string) (string, error) {
+    backup := fmt.Sprintf("%v.corrupt-%v", path, time.Now().Format("20060102-150405"))
+    return backup, os.Rename(path, backup)
+}
+
+// keeps the settings and the other files the game saves in the user's config directory
+type ConfigurationManager struct {
+    Directory string
+    Settings *Settings
+    // problems found while loading, to show to the player
+    Warnings []string
+}
+
+func NewConfigurationManager() *ConfigurationManager {
+    directory := "."
+    base, err := os.UserConfigDir()
+    if err == nil {
+        directory = filepath.Join(base, ConfigDirectoryName)
+    } else {
+        log.Printf("Unable to find the user config directory, using the current directory: %v", err)
+    }
+
+    return LoadConfiguration(directory, ".")
+}
+
+// load the settings from the directory. if there aren't any yet then the files that older versions
+// of the game kept in legacyDirectory are brought over
+func LoadConfiguration(directory string, legacyDirectory string) *ConfigurationManager {
+    config := &ConfigurationManager{
+        Directory: directory,
+    }
+
+    err := os.MkdirAll(directory, 0755)
+    if err != nil {
+        log.Printf("Unable to create config directory %v: %v", directory, err)
+    }
+
+    path := config.path(SettingsFile)
+    data, err := os.ReadFile(path)
+    switch {
+        case err == nil:
+            settings, err := ParseSettings(data)
+            if err != nil {
+                config.warn("Unable to read %v, using the default settings: %v", path, err)
+                config.backup(path)
+                config.Settings = DefaultSettings()
+            } else {
+                config.Settings = settings
+                if !bytesHaveVersion(data, SettingsVersion) {
+                    config.save()
+                }
+            }
+        case errors.Is(err, fs.ErrNotExist):
+            config.Settings = DefaultSettings()
+            if config.importLegacy(legacyDirectory) {
+                config.save()
+            }
+        default:
+            config.warn("Unable to read %v, using the default settings: %v", path, err)
+            config.Settings = DefaultSettings()
+    }
+
+    return config
+}
+
+// true if the document is already at the given version, so it doesn't need to be saved again
+func bytesHaveVersion(data []byte, version int) bool {
+    var header struct {
+        Version int `json:"version"`
+    }
+
+    return json.Unmarshal(data, &header) == nil && header.Version == version
+}
+
+func (config *ConfigurationManager) path(name string) string {
+    return filepath.Join(config.Directory, name)
+}
+
+func (config *ConfigurationManager) warn(format string, args ...any) {
+    message := fmt.Sprintf(format, args...)
+    log.Print(message)
+    config.Warnings = append(config.Warnings, message)
+}
+
+func (config *ConfigurationManager) backup(path string) {
+    backup, err := backupFile(path)
+    if err != nil {
+        log.Printf("Unable to back up %v: %v", path, err)
+    } else {
+        config.warn("The unreadable file was saved as %v", backup)
+    }
+}
+
+// bring over the files that were kept in the working directory before there was a config
+// directory. returns true if anything was found
+func (config *ConfigurationManager) importLegacy(directory string) bool {
+    if directory == config.Directory {
+        return false
+    }
+
+    found := false
+
+    data, err := os.ReadFile(filepath.Join(directory, SettingsFile))
+    if err == nil {
+        settings, err := ParseSettings(data)
+        if err == nil {
+            log.Printf("Importing settings from %v", filepath.Join(directory, SettingsFile))
+            config.Settings = settings
+            found = true
+        } else {
+            log.Printf("Unable to import %v: %v", filepath.Join(directory, SettingsFile), err)
+        }
+    }
+
+    file, err := os.Open(filepath.Join(directory, "audio.json"))
+    if err == nil {
+        settings, err := LoadMixerSettings(bufio.NewReader(file))
+        file.Close()
+        if err == nil {
+            log.Printf("Importing audio settings from %v", filepath.Join(directory, "audio.json"))
+            config.Settings.Audio = settings
+            found = true
+        }
+    }
+
+    _, err = os.Stat(config.path(PlaylistsFile))
+    if errors.Is(err, fs.ErrNotExist) {
+        file, err = os.Open(filepath.Join(directory, PlaylistsFile))
+        if err == nil {
+            library, err := LoadPlaylistLibrary(bufio.NewReader(file))
+            file.Close()
+            if err == nil {
+                log.Printf("Importing playlists from %v", filepath.Join(directory, PlaylistsFile))
+                config.SavePlaylists(library)
+            }
+        }
+    }
+
+    return found
+}
+
+// write the settings out
+func (config *ConfigurationManager) Save() error {
+    config.Settings.Version = SettingsVersion
+    return writeFileAtomic(config.path(SettingsFile), config.Settings.Serialize)
+}
+
+func (config *ConfigurationManager) save() {
+    err := config.Save()
+    if err != nil {
+        log.Printf("Unable to save settings: %v", err)
+    }
+}
+
+// change the settings and save them
+func (config *ConfigurationManager) Update(change func(settings *Settings)) {
+    change(config.Settings)
+    config.save()
+}
+
+func (config *ConfigurationManager) LoadPlayers() *PlayerLibrary {
+    library, err := config.Settings.Players.Load()
+    if err != nil {
+        log.Printf("Unable to load players: %v", err)
+        return NewPlayerLibrary()
+    }
+
+    return library
+}
+
+func (config *ConfigurationManager) SavePlayers(library *PlayerLibrary) error {
+    config.Settings.Players = library.Serialized()
+    return config.Save()
+}
+
+func (config *ConfigurationManager) LoadMixerSettings() MixerSettings {
+    return config.Settings.Audio
+}
+
+func (config *ConfigurationManager) SaveMixerSettings(settings MixerSettings) error {
+    config.Settings.Audio = settings
+    return config.Save()
+}
+
+func (config *ConfigurationManager) LoadPlaylists() *PlaylistLibrary {
+    path := config.path(PlaylistsFile)
+    file, err := os.Open(path)
+    if err == nil {
+        library, err := LoadPlaylistLibrary(bufio.NewReader(file))
+        file.Close()
+        if err == nil {
+            return library
+        }
+
+        config.warn("Unable to read playlists from %v: %v", path, err)
+        config.backup(path)
+    }
+
+    return NewPlaylistLibrary()
+}
+
+func (config *ConfigurationManager) SavePlaylists(library *PlaylistLibrary) error {
+    return writeFileAtomic(config.path(PlaylistsFile), library.Serialize)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+    "os"
+    "bytes"
+    "strings"
+    "testing"
+    "path/filepath"
+
+    "github.com/hajimehoshi/ebiten/v2"
+)
+
+// the config.json written by versions before the settings were versioned
+const legacyConfig = `{"keyboard_profile":{"green_button":"Q","red_button":"W","yellow_button":"E","blue_button":"R","orange_button":"T","strum_up_button":"ArrowUp","strum_down_button":"ArrowDown"},"gamepad_profiles":[]}`
+
+func TestMigrateLegacySettings(testing *testing.T) {
+    settings, err := ParseSettings([]byte(legacyConfig))
+    if err != nil {
+        testing.Fatalf("Unable to parse legacy settings: %v", err)
+    }
+
+    if settings.Version != SettingsVersion {
+        testing.Errorf("settings should be at version %v but were %v", SettingsVersion, settings.Version)
+    }
+
+    library, err := settings.Players.Load()
+    if err != nil {
+        testing.Fatalf("Unable to load players: %v", err)
+    }
+
+    player := library.CurrentPlayer()
+    if player.Name != DefaultPlayerName || player.Input.KeyboardProfile.GetInput(InputActionGreen) != ebiten.KeyQ {
+        testing.Errorf("legacy bindings should belong to the default player, got %v with green %v", player.Name, player.Input.KeyboardProfile.GetInput(InputActionGreen))
+    }
+
+    // tilt wasn't in the legacy file so it keeps its default
+    if player.Input.KeyboardProfile.GetInput(InputActionTilt) != NewInputProfileKeyboard().TiltButton {
+        testing.Errorf("missing bindings should keep their defaults")
+    }
+
+    if settings.Audio != DefaultMixerSettings() || len(settings.Library.SongPaths) == 0 {
+        testing.Errorf("sections missing from the legacy file should get their defaults")
+    }
+}
+
+func TestNewerSettings(testing *testing.T) {
+    _, err := ParseSettings([]byte(`{"version": 1000}`))
+    if err == nil {
+        testing.Errorf("settings from a newer version should not be loaded")
+    }
+}
+
+func TestCorruptSettings(testing *testing.T) {
+    directory := testing.TempDir()
+    path := filepath.Join(directory, SettingsFile)
+    os.WriteFile(path, []byte(`{"version": 1, "audio": {`), 0644)
+
+    config := LoadConfiguration(directory, directory)
+    if len(config.Warnings) == 0 {
+        testing.Errorf("a corrupt file should produce a warning")
+    }
+
+    if config.Settings.Audio != DefaultMixerSettings() {
+        testing.Errorf("a corrupt file should give the default settings")
+    }
+
+    entries, _ := os.ReadDir(directory)
+    backedUp := false
+    for _, entry := range entries {
+        if strings.HasPrefix(entry.Name(), SettingsFile + ".corrupt-") {
+            backedUp = true
+        }
+    }
+
+    if !backedUp {
+        testing.Errorf("the corrupt file should be backed up")
+    }
+}
+
+func TestSaveSettings(testing *testing.T) {
+    directory := testing.TempDir()
+    legacy := testing.TempDir()
+    os.WriteFile(filepath.Join(legacy, SettingsFile), []byte(legacyConfig), 0644)
+
+    config := LoadConfiguration(directory, legacy)
+    config.Update(func(settings *Settings) {
+        settings.Audio.Music = 0.25
+    })
+
+    // no temporary files are left behind
+    entries, _ := os.ReadDir(directory)
+    if len(entries) != 1 || entries[0].Name() != SettingsFile {
+        var names []string
+        for _, entry := range entries {
+            names = append(names, entry.Name())
+        }
+        testing.Errorf("only the settings file should be written but found %v", names)
+    }
+
+    reloaded := LoadConfiguration(directory, legacy)
+    if reloaded.Settings.Audio.Music != 0.25 {
+        testing.Errorf("saved settings should be loaded again, music volume was %v", reloaded.Settings.Audio.Music)
+    }
+
+    player := reloaded.LoadPlayers().CurrentPlayer()
+    if player.Input.KeyboardProfile.GetInput(InputActionGreen) != ebiten.KeyQ {
+        testing.Errorf("imported bindings should be saved in the new file")
+    }
+}
+
+func TestCameraSettings(testing *testing.T) {
+    config := DefaultSettings().Camera
+    if config.Current() != CameraPresets[0].Camera {
+        testing.Errorf("the first preset should be used by default")
+    }
+
+    config.Preset = CameraPresets[1].Name
+    config.Customize(func(camera *CameraSettings) {
+        camera.Y += 10
+        camera.FieldOfView = 1000
+    })
+
+    if config.Preset != CustomCameraName || config.Current().Y != CameraPresets[1].Camera.Y + 10 || config.Current().FieldOfView != MaxCameraFieldOfView {
+        testing.Errorf("customizing should start from the preset in use, got %+v", config.Current())
+    }
+
+    settings := DefaultSettings()
+    settings.Camera = config
+
+    var buffer bytes.Buffer
+    settings.Serialize(&buffer)
+    loaded, err := ParseSettings(buffer.Bytes())
+    if err != nil {
+        testing.Fatalf("Unable to parse settings: %v", err)
+    }
+
+    if loaded.Camera != config {
+        testing.Errorf("the custom camera should be saved, got %+v", loaded.Camera)
+    }
+
+    loaded.Camera.Preset = "missing"
+    if loaded.Camera.Current() != CameraPresets[0].Camera {
+        testing.Errorf("an unknown preset should use the first preset")
+    }
+}
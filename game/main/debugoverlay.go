@@ -0,0 +1,250 @@
+package main
+
+import (
+    "fmt"
+    "math"
+    "time"
+    "image/color"
+
+    "github.com/hajimehoshi/ebiten/v2"
+    "github.com/hajimehoshi/ebiten/v2/text/v2"
+    "github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// the overlay helps tell whether a missed note was the timing, the wrong fret, or an input that
+// never arrived, and how far off the player's hits are on average
+
+// the key that shows and hides the overlay while playing
+const InputDebugKey = ebiten.KeyF10
+
+// how many presses and results are listed
+const InputDebugHistory = 8
+
+// how many of the most recent hits the average offset is taken over
+const InputDebugOffsetWindow = 50
+
+type DebugResultKind int
+const (
+    DebugResultHit DebugResultKind = iota
+    // the note was played while holding a fret that had nothing to play
+    DebugResultWrongFret
+    // the note went by without being played
+    DebugResultNotPlayed
+    // a strum with nothing to play
+    DebugResultOverstrum
+)
+
+func (kind DebugResultKind) String() string {
+    switch kind {
+        case DebugResultHit: return "Hit"
+        case DebugResultWrongFret: return "Wrong fret"
+        case DebugResultNotPlayed: return "Not played"
+        case DebugResultOverstrum: return "Overstrum"
+    }
+
+    return "Unknown"
+}
+
+type DebugResult struct {
+    Kind DebugResultKind
+    // the fret of the note, or -1 if there was no note nearby
+    Fret int
+    // how late the input was compared to the note, negative if early
+    Offset time.Duration
+    // false if there was no input to measure, such as a note that wasn't played
+    HasOffset bool
+}
+
+// what the overlay shows. a nil *InputDebug records nothing
+type InputDebug struct {
+    // the most recent presses, newest last
+    Presses []InputEvent
+    Results []DebugResult
+    // the offsets of the most recent hits
+    Offsets []time.Duration
+}
+
+// keep the last 'count' items of a list
+func keepLast[T any](items []T, count int) []T {
+    if len(items) > count {
+        return items[len(items) - count:]
+    }
+    return items
+}
+
+func (debug *InputDebug) AddEvents(events []InputEvent) {
+    if debug == nil {
+        return
+    }
+
+    for _, event := range events {
+        if event.Pressed {
+            debug.Presses = append(debug.Presses, event)
+        }
+    }
+
+    debug.Presses = keepLast(debug.Presses, InputDebugHistory)
+}
+
+func (debug *InputDebug) AddResult(result DebugResult) {
+    if debug == nil {
+        return
+    }
+
+    debug.Results = keepLast(append(debug.Results, result), InputDebugHistory)
+
+    if result.Kind == DebugResultHit {
+        debug.Offsets = keepLast(append(debug.Offsets, result.Offset), InputDebugOffsetWindow)
+    }
+}
+
+// the mean and standard deviation of the recent hit offsets
+func (debug *InputDebug) OffsetStats() (time.Duration, time.Duration) {
+    if debug == nil || len(debug.Offsets) == 0 {
+        return 0, 0
+    }
+
+    var total float64
+    for _, offset := range debug.Offsets {
+        total += float64(offset)
+    }
+    mean := total / float64(len(debug.Offsets))
+
+    var variance float64
+    for _, offset := range debug.Offsets {
+        variance += (float64(offset) - mean) * (float64(offset) - mean)
+    }
+    variance /= float64(len(debug.Offsets))
+
+    return time.Duration(mean), time.Duration(math.Sqrt(variance))
+}
+
+// the pending note closest to the time into the song, for explaining a strum that hit nothing
+func (song *Song) nearestNote(delta time.Duration) (int, time.Duration, bool) {
+    found := false
+    nearestFret := -1
+    var nearest time.Duration
+
+    for fretIndex := range song.Frets {
+        fret := &song.Frets[fretIndex]
+        for i := fret.StartNote; i < len(fret.Notes); i++ {
+            note := &fret.Notes[i]
+            if note.State != NoteStatePending {
+                continue
+            }
+
+            offset := delta - note.Start
+            if !found || absDuration(offset) < absDuration(nearest) {
+                found = true
+                nearestFret = fretIndex
+                nearest = offset
+            }
+
+            // later notes are only further away
+            if offset < 0 {
+                break
+            }
+        }
+    }
+
+    return nearestFret, nearest, found
+}
+
+func formatOffset(offset time.Duration) string {
+    return fmt.Sprintf("%+dms", offset.Milliseconds())
+}
+
+func (engine *Engine) drawInputDebug(screen *ebiten.Image, song *Song, input InputSource) {
+    if song.Debug == nil {
+        return
+    }
+
+    face := &text.GoTextFace{
+        Source: engine.Font,
+        Size: 18,
+    }
+
+    const lineHeight = 22
+    const sectionGap = 8
+
+    // the title, a light per action, the whammy, and a heading for each list
+    lines := 1 + len(GameActions) + 1 + 2 + len(song.Debug.Presses) + len(song.Debug.Results)
+    if len(song.Debug.Offsets) > 0 && engine.Player != nil {
+        // the suggested offset goes under the stats
+        lines += 2
+    } else {
+        lines += 1
+    }
+
+    x := float32(10)
+    y := float32(60)
+    width := float32(330)
+    height := float32(5 + lines * lineHeight + 3 * sectionGap + 5)
+
+    vector.FillRect(screen, x, y, width, height, color.NRGBA{R: 0, G: 0, B: 0, A: 180}, true)
+    vector.StrokeRect(screen, x, y, width, height, 1, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, true)
+
+    var textOptions text.DrawOptions
+    textOptions.GeoM.Translate(float64(x + 10), float64(y + 5))
+
+    line := func(message string, col color.Color) {
+        textOptions.ColorScale.Reset()
+        textOptions.ColorScale.ScaleWithColor(col)
+        text.Draw(screen, message, face, &textOptions)
+        textOptions.GeoM.Translate(0, lineHeight)
+    }
+
+    line(fmt.Sprintf("Input (%v to hide)", InputDebugKey), color.White)
+
+    // a light for each action that is on while it is held
+    for _, action := range GameActions {
+        lightX, lightY := textOptions.GeoM.Apply(0, 0)
+        lightColor := color.NRGBA{R: 60, G: 60, B: 60, A: 255}
+        if input.IsHeld(action) {
+            lightColor = color.NRGBA{R: 0, G: 255, B: 0, A: 255}
+        }
+        vector.FillCircle(screen, float32(lightX + 7), float32(lightY + 11), 7, lightColor, true)
+
+        textOptions.GeoM.Translate(22, 0)
+        line(action.String(), color.White)
+        textOptions.GeoM.Translate(-22, 0)
+    }
+
+    line(fmt.Sprintf("Whammy: %d%%", int(input.Whammy() * 100)), color.White)
+
+    textOptions.GeoM.Translate(0, sectionGap)
+    line("Recent presses", color.NRGBA{R: 200, G: 200, B: 200, A: 255})
+    for _, event := range song.Debug.Presses {
+        line(fmt.Sprintf("%v at %v", event.Action, formatSongTime(event.Time.Sub(song.StartTime))), color.White)
+    }
+
+    textOptions.GeoM.Translate(0, sectionGap)
+    line("Recent notes", color.NRGBA{R: 200, G: 200, B: 200, A: 255})
+    for _, result := range song.Debug.Results {
+        message := result.Kind.String()
+        if result.Fret != -1 {
+            message = fmt.Sprintf("%v %v", song.Frets[result.Fret].InputAction, message)
+        }
+        if result.HasOffset {
+            message = fmt.Sprintf("%v %v", message, formatOffset(result.Offset))
+        }
+
+        resultColor := color.Color(color.NRGBA{R: 255, G: 100, B: 100, A: 255})
+        if result.Kind == DebugResultHit {
+            resultColor = song.Timing.Judge(result.Offset).Color()
+        }
+        line(message, resultColor)
+    }
+
+    textOptions.GeoM.Translate(0, sectionGap)
+    if len(song.Debug.Offsets) > 0 {
+        mean, deviation := song.Debug.OffsetStats()
+        line(fmt.Sprintf("Hits: mean %v, stddev %vms (%v)", formatOffset(mean), deviation.Milliseconds(), len(song.Debug.Offsets)), color.White)
+        // hits that are late on average mean the input offset should be larger
+        if engine.Player != nil {
+            line(fmt.Sprintf("Suggested input offset: %vms", (engine.Player.InputOffset + mean).Milliseconds()), color.White)
+        }
+    } else {
+        line("Hits: none yet", color.White)
+    }
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+    "log"
+    "time"
+
+    "github.com/kazzmir/rhythm/lib/drift"
+)
+
+// how often the stems are compared against the master stem
+const DriftCheckInterval = 250 * time.Millisecond
+
+func NewDriftMonitor() *drift.Monitor {
+    return drift.NewMonitor(30 * time.Millisecond, 150 * time.Millisecond, 4)
+}
+
+// lets the drift monitor treat the chart like another stem, so the notes follow the master audio
+type chartClock struct {
+    song *Song
+}
+
+func (clock chartClock) Position() time.Duration {
+    return time.Since(clock.song.StartTime)
+}
+
+func (clock chartClock) SetPosition(position time.Duration) error {
+    clock.song.StartTime = time.Now().Add(-position)
+    return nil
+}
+
+func (clock chartClock) IsPlaying() bool {
+    return !clock.song.StartTime.IsZero()
+}
+
+// the stem every other stem follows, the full song mix if there is one
+func (song *Song) masterPart() *Part {
+    for i := range song.Parts {
+        if song.Parts[i].Stem == StemSong {
+            return &song.Parts[i]
+        }
+    }
+
+    if len(song.Parts) > 0 {
+        return &song.Parts[0]
+    }
+
+    return nil
+}
+
+// move any stems, and the chart, that have drifted away from the master stem
+func (song *Song) syncStems() {
+    master := song.masterPart()
+    if master == nil || song.DriftMonitor == nil {
+        return
+    }
+
+    var streams []drift.Stream
+    for _, part := range song.Parts {
+        streams = append(streams, part.Player)
+    }
+    streams = append(streams, chartClock{song: song})
+
+    for _, correction := range song.DriftMonitor.Sync(master.Player, streams) {
+        name := "chart"
+        if correction.Index < len(song.Parts) {
+            name = song.Parts[correction.Index].Name
+        }
+
+        action := "Nudged"
+        if correction.Reseek {
+            action = "Reseeked"
+        }
+
+        if correction.Err != nil {
+            log.Printf("Unable to resync '%v' that drifted %v from '%v': %v", name, correction.Drift, master.Name, correction.Err)
+        } else {
+            log.Printf("%v '%v' that drifted %v from '%v'", action, name, correction.Drift, master.Name)
+        }
+    }
+}
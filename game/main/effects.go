@@ -0,0 +1,198 @@
+package main
+
+import (
+    "io"
+    "io/fs"
+    "bytes"
+    "log"
+    "sync"
+    "time"
+    "slices"
+    "path"
+
+    "github.com/kazzmir/rhythm/data"
+    "github.com/kazzmir/rhythm/lib/wav"
+
+    "github.com/hajimehoshi/ebiten/v2/audio"
+)
+
+type SoundEffect int
+const (
+    // a note went by without being played, or the wrong frets were strummed
+    SoundEffectMiss SoundEffect = iota
+    // strummed when there was no note to play
+    SoundEffectOverstrum
+    SoundEffectStarPower
+    SoundEffectStreak
+    // a setlist ended before its last song, because a song was quit or couldn't be played
+    SoundEffectFail
+)
+
+var SoundEffects = []SoundEffect{SoundEffectMiss, SoundEffectOverstrum, SoundEffectStarPower, SoundEffectStreak, SoundEffectFail}
+
+// the name of the file in the sound set, without the extension
+func (effect SoundEffect) String() string {
+    switch effect {
+        case SoundEffectMiss: return "miss"
+        case SoundEffectOverstrum: return "overstrum"
+        case SoundEffectStarPower: return "starpower"
+        case SoundEffectStreak: return "streak"
+        case SoundEffectFail: return "fail"
+    }
+
+    return "unknown"
+}
+
+// the set used when the configured one doesn't exist
+const DefaultSoundSet = "classic"
+
+// selecting this set turns off sound effects
+const NoSoundSet = "none"
+
+// play the streak sound every time the streak reaches a multiple of this
+const StreakMilestone = 50
+
+// don't play the same effect again within this time, so a missed chord only makes one sound
+const SoundEffectRepeatDelay = 80 * time.Millisecond
+
+// the names of the embedded sound sets, along with NoSoundSet
+func SoundSets() []string {
+    var sets []string
+
+    entries, err := data.SoundsFS.ReadDir("sounds")
+    if err == nil {
+        for _, entry := range entries {
+            if entry.IsDir() {
+                sets = append(sets, entry.Name())
+            }
+        }
+    }
+
+    slices.Sort(sets)
+
+    return append(sets, NoSoundSet)
+}
+
+type playingEffect struct {
+    player *audio.Player
+    track *MixerTrack
+}
+
+// short sounds that are decoded up front and played through the effects channel of the mixer
+type SoundBank struct {
+    audioContext *audio.Context
+    mixer *Mixer
+    sounds map[SoundEffect][]byte
+
+    lock sync.Mutex
+    lastPlayed map[SoundEffect]time.Time
+    playing []playingEffect
+}
+
+// decode a wav file into 16-bit stereo pcm at the sample rate of the audio context
+func decodeSoundEffect(audioContext *audio.Context, file fs.File) ([]byte, error) {
+    seeker, ok := file.(io.ReadSeeker)
+    if !ok {
+        data, err := io.ReadAll(file)
+        if err != nil {
+            return nil, err
+        }
+        seeker = bytes.NewReader(data)
+    }
+
+    stream, err := wav.Decode(seeker)
+    if err != nil {
+        return nil, err
+    }
+
+    return io.ReadAll(audio.ResampleReader(stream, stream.Length(), stream.SampleRate(), audioContext.SampleRate()))
+}
+
+// load the sounds in the given set. missing or broken sounds are skipped, so the bank is never nil
+func LoadSoundBank(audioContext *audio.Context, mixer *Mixer, set string) *SoundBank {
+    bank := &SoundBank{
+        audioContext: audioContext,
+        mixer: mixer,
+        sounds: make(map[SoundEffect][]byte),
+        lastPlayed: make(map[SoundEffect]time.Time),
+    }
+
+    if set == NoSoundSet {
+        return bank
+    }
+
+    if !slices.Contains(SoundSets(), set) {
+        log.Printf("Unknown sound set '%v', using '%v'", set, DefaultSoundSet)
+        set = DefaultSoundSet
+    }
+
+    for _, effect := range SoundEffects {
+        name := path.Join("sounds", set, effect.String() + ".wav")
+        file, err := data.SoundsFS.Open(name)
+        if err != nil {
+            continue
+        }
+
+        pcm, err := decodeSoundEffect(audioContext, file)
+        file.Close()
+        if err != nil {
+            log.Printf("Unable to load sound effect '%v': %v", name, err)
+            continue
+        }
+
+        bank.sounds[effect] = pcm
+    }
+
+    return bank
+}
+
+// close players for effects that have finished. must hold the lock
+func (bank *SoundBank) cleanup(all bool) {
+    bank.playing = slices.DeleteFunc(bank.playing, func(effect playingEffect) bool {
+        if all || !effect.player.IsPlaying() {
+            effect.player.Close()
+            bank.mixer.Remove(effect.track)
+            return true
+        }
+        return false
+    })
+}
+
+// safe to call on a nil bank
+func (bank *SoundBank) Play(effect SoundEffect) {
+    if bank == nil {
+        return
+    }
+
+    pcm, ok := bank.sounds[effect]
+    if !ok {
+        return
+    }
+
+    bank.lock.Lock()
+    defer bank.lock.Unlock()
+
+    bank.cleanup(false)
+
+    now := time.Now()
+    if now.Sub(bank.lastPlayed[effect]) < SoundEffectRepeatDelay {
+        return
+    }
+    bank.lastPlayed[effect] = now
+
+    player := bank.audioContext.NewPlayerFromBytes(pcm)
+    track := bank.mixer.Add(player, MixerChannelEffects)
+    player.Play()
+
+    bank.playing = append(bank.playing, playingEffect{player: player, track: track})
+}
+
+func (bank *SoundBank) Close() {
+    if bank == nil {
+        return
+    }
+
+    bank.lock.Lock()
+    defer bank.lock.Unlock()
+    bank.cleanup(true)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+    "log"
+    "fmt"
+    "time"
+    "image/color"
+
+    "github.com/kazzmir/rhythm/lib/coroutine"
+
+    "github.com/hajimehoshi/ebiten/v2"
+    "github.com/hajimehoshi/ebiten/v2/inpututil"
+    "github.com/hajimehoshi/ebiten/v2/text/v2"
+    "github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// how long a toast stays on screen
+const ToastDisplayTime = 3 * time.Second
+
+// a short message shown on top of whatever screen is active
+type Toast struct {
+    Message string
+    Time time.Time
+}
+
+func (engine *Engine) ShowToast(message string) {
+    engine.Toasts = append(engine.Toasts, Toast{Message: message, Time: time.Now()})
+}
+
+// notice controllers being plugged in and unplugged. a controller with a saved profile becomes the
+// active input as soon as it appears
+func (engine *Engine) updateGamepads() {
+    for _, id := range inpututil.AppendJustConnectedGamepadIDs(nil) {
+        name := ebiten.GamepadName(id)
+        log.Printf("Gamepad connected: %v '%s' %v", id, name, ebiten.GamepadSDLID(id))
+        engine.GamepadIds[id] = name
+
+        if engine.Input == nil {
+            continue
+        }
+
+        profile, known := engine.Input.AttachGamepad(id)
+        if known && profile.HasBindings() {
+            engine.Input.SetGamepadProfile(profile)
+            engine.ShowToast(fmt.Sprintf("%v connected, using its saved controls", name))
+        } else {
+            engine.ShowToast(fmt.Sprintf("%v connected, set it up in Settings", name))
+        }
+    }
+
+    for id, name := range engine.GamepadIds {
+        if inpututil.IsGamepadJustDisconnected(id) {
+            log.Printf("Gamepad disconnected: %v '%s'", id, name)
+            delete(engine.GamepadIds, id)
+            engine.ShowToast(fmt.Sprintf("%v disconnected", name))
+
+            if engine.Input != nil && engine.Input.DetachGamepad(id) {
+                engine.InputLost = true
+            }
+        }
+    }
+}
+
+func (engine *Engine) drawToasts(screen *ebiten.Image) {
+    now := time.Now()
+    for len(engine.Toasts) > 0 && now.Sub(engine.Toasts[0].Time) > ToastDisplayTime {
+        engine.Toasts = engine.Toasts[1:]
+    }
+
+    if len(engine.Toasts) == 0 {
+        return
+    }
+
+    face := &text.GoTextFace{
+        Source: engine.Font,
+        Size: 20,
+    }
+
+    y := float64(screen.Bounds().Dy()) - 50
+    for i := len(engine.Toasts) - 1; i >= 0; i-- {
+        toast := engine.Toasts[i]
+
+        width, height := text.Measure(toast.Message, face, 0)
+        x := float64(screen.Bounds().Dx()) - width - 30
+
+        // fade out over the last half second
+        alpha := min(1, float32(ToastDisplayTime - now.Sub(toast.Time)) / float32(time.Millisecond * 500))
+
+        vector.FillRect(screen, float32(x - 10), float32(y - 5), float32(width + 20), float32(height + 10), color.NRGBA{R: 0, G: 0, B: 0, A: uint8(200 * alpha)}, true)
+
+        var textOptions text.DrawOptions
+        textOptions.GeoM.Translate(x, y)
+        textOptions.ColorScale.ScaleAlpha(alpha)
+        text.Draw(screen, toast.Message, face, &textOptions)
+
+        y -= height + 20
+    }
+}
+
+// pause the song after the controller being played was unplugged, until it is plugged back in or
+// the player chooses to carry on with the keyboard. returns false if the player quit instead
+func waitForController(yield coroutine.YieldFunc, engine *Engine, song *Song) bool {
+    song.Pause(time.Now())
+
+    face := &text.GoTextFace{
+        Source: engine.Font,
+        Size: 30,
+    }
+
+    previousDrawer := engine.LastDrawer()
+    engine.PushDrawer(func(screen *ebiten.Image) {
+        previousDrawer(screen)
+
+        bounds := screen.Bounds()
+        vector.FillRect(screen, 0, 0, float32(bounds.Dx()), float32(bounds.Dy()), color.NRGBA{R: 0, G: 0, B: 0, A: 160}, true)
+
+        var textOptions text.DrawOptions
+        textOptions.GeoM.Translate(float64(bounds.Dx()) / 2 - 300, float64(bounds.Dy()) / 2 - 60)
+        text.Draw(screen, "Controller disconnected", face, &textOptions)
+        textOptions.GeoM.Translate(0, 50)
+        text.Draw(screen, "Plug it back in to keep playing", face, &textOptions)
+        textOptions.GeoM.Translate(0, 40)
+        text.Draw(screen, "Enter to continue with the keyboard, Escape to quit", face, &textOptions)
+    })
+    defer engine.PopDrawer()
+
+    for {
+        if yield() != nil {
+            return false
+        }
+
+        if inpututil.IsKeyJustPressed(ebiten.KeyEscape) || inpututil.IsKeyJustPressed(ebiten.KeyCapsLock) {
+            yield()
+            return false
+        }
+
+        // the controller came back and its profile was picked up again
+        if engine.Input.CurrentProfile == UseProfileGamepad || inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+            song.Resume(time.Now())
+            return true
+        }
+    }
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+    "time"
+
+    "github.com/solarlune/tetra3d"
+)
+
+// the distance between lanes at the normal width
+const DefaultLaneWidth = 10
+
+// how far the highway reaches into the distance at the normal length
+const DefaultHighwayLength = 800
+
+// how far the highway moves to the side at either end of the position setting
+const MaxHighwayShift = 60
+
+// the width and length settings are multiples of the normal size. notes don't shrink with the
+// highway, so it can't get much narrower than the notes are wide
+const MinHighwayWidth = 0.8
+const MaxHighwayWidth = 1.5
+const MinHighwayLength = 0.5
+const MaxHighwayLength = 1.5
+
+// how much each click changes a highway setting
+const HighwayStep = 0.1
+
+// how far a note moves down the highway each second at normal speed
+const HighwayUnitsPerSecond = 50
+
+// note speed is a multiple of the normal speed
+const MinHighwaySpeed = 0.5
+const MaxHighwaySpeed = 3
+const HighwaySpeedStep = 0.25
+
+// how far the neck reaches past the buttons towards the camera
+const NeckOverhang = 50
+
+// notes fade in over this much of the far end of the highway
+const HighwayFadeFraction = 0.25
+
+// a player's choices for how the highway looks
+type HighwaySettings struct {
+    Width float64 `json:"width"`
+    Length float64 `json:"length"`
+    // from -1 for the far left of the screen to 1 for the far right
+    Position float64 `json:"position"`
+}
+
+func DefaultHighwaySettings() HighwaySettings {
+    return HighwaySettings{
+        Width: 1,
+        Length: 1,
+    }
+}
+
+func (settings HighwaySettings) clamp() HighwaySettings {
+    settings.Width = min(MaxHighwayWidth, max(MinHighwayWidth, settings.Width))
+    settings.Length = min(MaxHighwayLength, max(MinHighwayLength, settings.Length))
+    settings.Position = min(1, max(-1, settings.Position))
+    return settings
+}
+
+// where things go on the highway in the scene
+type HighwayLayout struct {
+    Frets int
+    // the lanes are mirrored, so the green lane is on the right
+    Lefty bool
+    LaneWidth float32
+    Length int
+    // the x position of the middle lane
+    Center float32
+    // how fast notes move, 1 is normal
+    Speed float64
+}
+
+func (player *PlayerProfile) HighwayLayout(frets int) HighwayLayout {
+    settings := player.Highway.clamp()
+    return HighwayLayout{
+        Frets: frets,
+        Lefty: player.Lefty,
+        LaneWidth: float32(DefaultLaneWidth * settings.Width),
+        Length: int(DefaultHighwayLength * settings.Length),
+        Center: float32(MaxHighwayShift * settings.Position),
+        Speed: min(MaxHighwaySpeed, max(MinHighwaySpeed, player.HighwaySpeed)),
+    }
+}
+
+// the x position of a fret's lane
+func (layout HighwayLayout) LaneX(fret int) float32 {
+    lane := fret
+    if layout.Lefty {
+        lane = layout.Frets - 1 - fret
+    }
+
+    middle := float32(layout.Frets - 1) / 2
+    return layout.Center + (float32(lane) - middle) * layout.LaneWidth
+}
+
+// how far down the highway a note is when it is 't' away from being played. notes are placed and
+// moved with this so they agree
+func (layout HighwayLayout) TimeToZ(t time.Duration) float32 {
+    return float32(t.Seconds() * HighwayUnitsPerSecond * layout.Speed)
+}
+
+// how far past the buttons the highway reaches
+func (layout HighwayLayout) Reach() float32 {
+    return float32(layout.Length - NeckOverhang)
+}
+
+// how long before it is played a note appears at the far end of the highway
+func (layout HighwayLayout) Lookahead() time.Duration {
+    return time.Duration(float64(layout.Reach()) / (HighwayUnitsPerSecond * layout.Speed) * float64(time.Second))
+}
+
+// how visible a note is at some distance down the highway. notes fade in at the far end and
+// can't be seen past it
+func (layout HighwayLayout) NoteAlpha(distance float32) float32 {
+    fade := layout.Reach() * HighwayFadeFraction
+    return min(1, max(0, (layout.Reach() - distance) / fade))
+}
+
+// the neck has an extra lane's worth of room on each side
+func (layout HighwayLayout) NeckWidth() int {
+    return int(layout.LaneWidth * float32(layout.Frets + 2))
+}
+
+// the color of the notes and button of a fret, which goes with its action so that lefty
+// players see the same colors in the mirrored order
+func fretColor(action InputAction) tetra3d.Color {
+    switch action {
+        case InputActionGreen: return tetra3d.NewColor(0, 1, 0, 1)
+        case InputActionRed: return tetra3d.NewColor(1, 0, 0, 1)
+        case InputActionYellow: return tetra3d.NewColor(1, 1, 0, 1)
+        case InputActionBlue: return tetra3d.NewColor(0, 0, 1, 1)
+        case InputActionOrange: return tetra3d.NewColor(1, 0.5, 0, 1)
+        default: return tetra3d.NewColor(1, 1, 1, 1)
+    }
+}
@@ -2,6 +2,9 @@ package main
 
 import (
     "io"
+    "slices"
+    "fmt"
+    "time"
     "encoding/json"
 
     "github.com/hajimehoshi/ebiten/v2"
@@ -18,10 +21,96 @@ type SerializedGamepadProfile struct {
     OrangeButton ebiten.GamepadButton `json:"orange_button"`
     StrumUpButton ebiten.GamepadButton `json:"strum_up_button"`
     StrumDownButton ebiten.GamepadButton `json:"strum_down_button"`
+    TiltButton ebiten.GamepadButton `json:"tilt_button"`
+    WhammyAxis AxisBinding `json:"whammy_axis"`
+    TiltAxis AxisBinding `json:"tilt_axis"`
+}
+
+// profiles saved before tilt and whammy were supported leave them unbound
+func (serialized *SerializedGamepadProfile) UnmarshalJSON(data []byte) error {
+    type plain SerializedGamepadProfile
+    out := plain{
+        TiltButton: ebiten.GamepadButton(-1),
+        WhammyAxis: UnboundAxis(),
+        TiltAxis: UnboundAxis(),
+    }
+
+    err := json.Unmarshal(data, &out)
+    if err != nil {
+        return err
+    }
+
+    *serialized = SerializedGamepadProfile(out)
+    return nil
+}
+
+// make a profile for the given controller with the saved bindings
+func (serialized *SerializedGamepadProfile) Load(id ebiten.GamepadID) *InputProfileGamepad {
+    profile := NewInputProfileGamepad(id)
+    profile.GreenButton = serialized.GreenButton
+    profile.RedButton = serialized.RedButton
+    profile.YellowButton = serialized.YellowButton
+    profile.BlueButton = serialized.BlueButton
+    profile.OrangeButton = serialized.OrangeButton
+    profile.StrumUpButton = serialized.StrumUpButton
+    profile.StrumDownButton = serialized.StrumDownButton
+    profile.TiltButton = serialized.TiltButton
+    profile.Whammy = serialized.WhammyAxis
+    profile.Tilt = serialized.TiltAxis
+    return profile
+}
+
+// tilting past this much of the calibrated range counts as pressing the tilt action
+const TiltThreshold = 0.6
+// how far back the guitar has to come before tilting counts again, so a guitar held right at the
+// threshold doesn't flicker between tilted and not
+const TiltHysteresis = 0.15
+
+// an analog gamepad axis, calibrated to the values it reports at rest and when pushed all the way
+type AxisBinding struct {
+    Axis int `json:"axis"`
+    Rest float64 `json:"rest"`
+    Max float64 `json:"max"`
+}
+
+func UnboundAxis() AxisBinding {
+    return AxisBinding{Axis: -1}
+}
+
+func (binding AxisBinding) Bound() bool {
+    return binding.Axis >= 0 && binding.Max != binding.Rest
+}
+
+// scale a raw axis value to 0 at rest and 1 at the calibrated maximum
+func (binding AxisBinding) Normalize(raw float64) float64 {
+    if !binding.Bound() {
+        return 0
+    }
+
+    return min(1, max(0, (raw - binding.Rest) / (binding.Max - binding.Rest)))
+}
+
+func (binding AxisBinding) Value(id ebiten.GamepadID) float64 {
+    if !binding.Bound() {
+        return 0
+    }
+
+    return binding.Normalize(ebiten.GamepadAxisValue(id, binding.Axis))
+}
+
+func (binding AxisBinding) String() string {
+    if !binding.Bound() {
+        return "Not set"
+    }
+
+    return fmt.Sprintf("Axis %v", binding.Axis)
 }
 
 type InputProfileGamepad struct {
     GamepadID ebiten.GamepadID
+    // remembered so the profile can still be saved after the controller is unplugged
+    SDLID string
+    Name string
 
     GreenButton ebiten.GamepadButton
     RedButton ebiten.GamepadButton
@@ -30,11 +119,21 @@ type InputProfileGamepad struct {
     OrangeButton ebiten.GamepadButton
     StrumUpButton ebiten.GamepadButton
     StrumDownButton ebiten.GamepadButton
+    TiltButton ebiten.GamepadButton
+
+    Whammy AxisBinding
+    Tilt AxisBinding
+
+    // whether the tilt axis is past the threshold, now and as of the previous tick
+    tilted bool
+    wasTilted bool
 }
 
 func NewInputProfileGamepad(id ebiten.GamepadID) *InputProfileGamepad {
     return &InputProfileGamepad{
         GamepadID: id,
+        SDLID: ebiten.GamepadSDLID(id),
+        Name: ebiten.GamepadName(id),
         GreenButton: ebiten.GamepadButton(-1),
         RedButton: ebiten.GamepadButton(-1),
         YellowButton: ebiten.GamepadButton(-1),
@@ -42,13 +141,16 @@ func NewInputProfileGamepad(id ebiten.GamepadID) *InputProfileGamepad {
         OrangeButton: ebiten.GamepadButton(-1),
         StrumUpButton: ebiten.GamepadButton(-1),
         StrumDownButton: ebiten.GamepadButton(-1),
+        TiltButton: ebiten.GamepadButton(-1),
+        Whammy: UnboundAxis(),
+        Tilt: UnboundAxis(),
     }
 }
 
 func (profile *InputProfileGamepad) Serialize() SerializedGamepadProfile {
     return SerializedGamepadProfile{
-        ID: ebiten.GamepadSDLID(profile.GamepadID),
-        Name: ebiten.GamepadName(profile.GamepadID),
+        ID: profile.SDLID,
+        Name: profile.Name,
         GreenButton: profile.GreenButton,
         RedButton: profile.RedButton,
         YellowButton: profile.YellowButton,
@@ -56,6 +158,32 @@ func (profile *InputProfileGamepad) Serialize() SerializedGamepadProfile {
         OrangeButton: profile.OrangeButton,
         StrumUpButton: profile.StrumUpButton,
         StrumDownButton: profile.StrumDownButton,
+        TiltButton: profile.TiltButton,
+        WhammyAxis: profile.Whammy,
+        TiltAxis: profile.Tilt,
+    }
+}
+
+// true if any fret or strum has been bound, otherwise the controller can't be played
+func (profile *InputProfileGamepad) HasBindings() bool {
+    for _, action := range GameActions {
+        if profile.GetInput(action) != ebiten.GamepadButton(-1) {
+            return true
+        }
+    }
+
+    return false
+}
+
+// called once per tick to turn the tilt axis into presses and releases
+func (profile *InputProfileGamepad) updateTilt() {
+    profile.wasTilted = profile.tilted
+
+    value := profile.Tilt.Value(profile.GamepadID)
+    if profile.tilted {
+        profile.tilted = value > TiltThreshold - TiltHysteresis
+    } else {
+        profile.tilted = value >= TiltThreshold
     }
 }
 
@@ -68,6 +196,7 @@ func (profile *InputProfileGamepad) SetInput(kind InputAction, button ebiten.Gam
         case InputActionOrange: profile.OrangeButton = button
         case InputActionStrumUp: profile.StrumUpButton = button
         case InputActionStrumDown: profile.StrumDownButton = button
+        case InputActionTilt: profile.TiltButton = button
     }
 }
 
@@ -80,6 +209,7 @@ func (profile *InputProfileGamepad) GetInput(kind InputAction) ebiten.GamepadBut
         case InputActionOrange: return profile.OrangeButton
         case InputActionStrumUp: return profile.StrumUpButton
         case InputActionStrumDown: return profile.StrumDownButton
+        case InputActionTilt: return profile.TiltButton
     }
 
     return ebiten.GamepadButton(-1)
@@ -93,6 +223,9 @@ type InputProfileKeyboard struct {
     OrangeButton ebiten.Key `json:"orange_button"`
     StrumUpButton ebiten.Key `json:"strum_up_button"`
     StrumDownButton ebiten.Key `json:"strum_down_button"`
+    TiltButton ebiten.Key `json:"tilt_button"`
+    // pressing a fret plays its note without strumming
+    FretStrum bool `json:"fret_strum"`
 }
 
 func (profile *InputProfileKeyboard) SetInput(kind InputAction, key ebiten.Key) {
@@ -104,6 +237,7 @@ func (profile *InputProfileKeyboard) SetInput(kind InputAction, key ebiten.Key)
         case InputActionOrange: profile.OrangeButton = key
         case InputActionStrumUp: profile.StrumUpButton = key
         case InputActionStrumDown: profile.StrumDownButton = key
+        case InputActionTilt: profile.TiltButton = key
     }
 }
 
@@ -116,6 +250,7 @@ func (profile *InputProfileKeyboard) GetInput(kind InputAction) ebiten.Key {
         case InputActionOrange: return profile.OrangeButton
         case InputActionStrumUp: return profile.StrumUpButton
         case InputActionStrumDown: return profile.StrumDownButton
+        case InputActionTilt: return profile.TiltButton
     }
 
     return ebiten.Key(-1)
@@ -130,9 +265,76 @@ func NewInputProfileKeyboard() *InputProfileKeyboard {
         OrangeButton: ebiten.Key5,
         StrumUpButton: ebiten.KeyUp,
         StrumDownButton: ebiten.KeySpace,
+        TiltButton: ebiten.KeyT,
     }
 }
 
+// keys that do something wherever they are pressed, which keyboard layouts must not use
+var GlobalKeys = []ebiten.Key{ScreenshotKey, InputDebugKey, SaveCameraKey}
+
+// a ready made set of keys for the keyboard
+type KeyboardLayout struct {
+    Name string
+    Profile InputProfileKeyboard
+}
+
+var KeyboardLayouts = []KeyboardLayout{
+    KeyboardLayout{
+        Name: "Number Keys",
+        Profile: *NewInputProfileKeyboard(),
+    },
+    // the keyboard held like a guitar
+    KeyboardLayout{
+        Name: "Classic",
+        Profile: InputProfileKeyboard{
+            GreenButton: ebiten.KeyF1,
+            RedButton: ebiten.KeyF2,
+            YellowButton: ebiten.KeyF3,
+            BlueButton: ebiten.KeyF4,
+            OrangeButton: ebiten.KeyF5,
+            StrumUpButton: ebiten.KeyShiftRight,
+            StrumDownButton: ebiten.KeyEnter,
+            TiltButton: ebiten.KeyBackspace,
+        },
+    },
+    // frets under the left hand, strum with the right
+    KeyboardLayout{
+        Name: "Two Hands",
+        Profile: InputProfileKeyboard{
+            GreenButton: ebiten.KeyA,
+            RedButton: ebiten.KeyS,
+            YellowButton: ebiten.KeyD,
+            BlueButton: ebiten.KeyF,
+            OrangeButton: ebiten.KeyG,
+            StrumUpButton: ebiten.KeyArrowUp,
+            StrumDownButton: ebiten.KeyArrowDown,
+            TiltButton: ebiten.KeySpace,
+        },
+    },
+    // every fret press plays a note, no strumming needed
+    KeyboardLayout{
+        Name: "Tap Only",
+        Profile: InputProfileKeyboard{
+            GreenButton: ebiten.KeyA,
+            RedButton: ebiten.KeyS,
+            YellowButton: ebiten.KeyD,
+            BlueButton: ebiten.KeyF,
+            OrangeButton: ebiten.KeyG,
+            StrumUpButton: ebiten.KeyArrowUp,
+            StrumDownButton: ebiten.KeyArrowDown,
+            TiltButton: ebiten.KeySpace,
+            FretStrum: true,
+        },
+    },
+}
+
+// the index of the layout the keyboard is set to, or -1 if the keys have been changed from all of them
+func (profile *InputProfileKeyboard) Layout() int {
+    return slices.IndexFunc(KeyboardLayouts, func(layout KeyboardLayout) bool {
+        return layout.Profile == *profile
+    })
+}
+
 /*
 type InputProfileInterface interface {
     SetInput(kind InputKind, key ebiten.Key)
@@ -151,6 +353,12 @@ type InputProfile struct {
 
     CurrentProfile UseProfileKind
     CurrentGamepadProfile *InputProfileGamepad
+
+    // saved profiles for controllers that aren't plugged in
+    Disconnected []SerializedGamepadProfile
+
+    // a midi device such as a drum kit, played alongside the other inputs
+    Midi MidiProfile
 }
 
 func NewInputProfile() *InputProfile {
@@ -158,6 +366,7 @@ func NewInputProfile() *InputProfile {
         KeyboardProfile: NewInputProfileKeyboard(),
         GamepadProfiles: make(map[ebiten.GamepadID]*InputProfileGamepad),
         CurrentProfile: UseProfileKeyboard,
+        Midi: DefaultMidiProfile(),
     }
 }
 
@@ -180,14 +389,69 @@ func (profile *InputProfile) GetGamepadProfile(id ebiten.GamepadID) *InputProfil
     return profile.GamepadProfiles[id]
 }
 
+// set up a newly connected controller, using its saved profile if there is one. saved profiles
+// are matched by SDL ID first and then by name, since the SDL ID of some controllers depends on
+// how they are connected. returns true along with the profile if a saved one was attached, or nil
+// if the controller has never been set up
+func (profile *InputProfile) AttachGamepad(id ebiten.GamepadID) (*InputProfileGamepad, bool) {
+    existing, ok := profile.GamepadProfiles[id]
+    if ok {
+        return existing, false
+    }
+
+    index := slices.IndexFunc(profile.Disconnected, func(saved SerializedGamepadProfile) bool {
+        return saved.ID != "" && saved.ID == ebiten.GamepadSDLID(id)
+    })
+
+    if index == -1 {
+        index = slices.IndexFunc(profile.Disconnected, func(saved SerializedGamepadProfile) bool {
+            return saved.Name != "" && saved.Name == ebiten.GamepadName(id)
+        })
+    }
+
+    if index == -1 {
+        return nil, false
+    }
+
+    gamepadProfile := profile.Disconnected[index].Load(id)
+    profile.Disconnected = slices.Delete(profile.Disconnected, index, index + 1)
+    profile.GamepadProfiles[id] = gamepadProfile
+
+    return gamepadProfile, true
+}
+
+// forget a controller that was unplugged, keeping its bindings for when it comes back. returns
+// true if it was the controller being used, in which case input falls back to the keyboard
+func (profile *InputProfile) DetachGamepad(id ebiten.GamepadID) bool {
+    gamepadProfile, ok := profile.GamepadProfiles[id]
+    if !ok {
+        return false
+    }
+
+    delete(profile.GamepadProfiles, id)
+    profile.Disconnected = append(profile.Disconnected, gamepadProfile.Serialize())
+
+    if profile.CurrentProfile == UseProfileGamepad && profile.CurrentGamepadProfile == gamepadProfile {
+        profile.CurrentProfile = UseProfileKeyboard
+        profile.CurrentGamepadProfile = nil
+        return true
+    }
+
+    return false
+}
+
 func (profile *InputProfile) IsJustPressed(action InputAction) bool {
     switch profile.CurrentProfile {
         case UseProfileKeyboard:
             key := profile.KeyboardProfile.GetInput(action)
             return inpututil.IsKeyJustPressed(key)
         case UseProfileGamepad:
-            button := profile.CurrentGamepadProfile.GetInput(action)
-            return inpututil.IsGamepadButtonJustPressed(profile.CurrentGamepadProfile.GamepadID, button)
+            gamepad := profile.CurrentGamepadProfile
+            if action == InputActionTilt && gamepad.tilted && !gamepad.wasTilted {
+                return true
+            }
+            button := gamepad.GetInput(action)
+            return inpututil.IsGamepadButtonJustPressed(gamepad.GamepadID, button)
     }
 
     return false
@@ -199,32 +463,123 @@ func (profile *InputProfile) IsJustReleased(action InputAction) bool {
             key := profile.KeyboardProfile.GetInput(action)
             return inpututil.IsKeyJustReleased(key)
         case UseProfileGamepad:
-            button := profile.CurrentGamepadProfile.GetInput(action)
-            return inpututil.IsGamepadButtonJustReleased(profile.CurrentGamepadProfile.GamepadID, button)
+            gamepad := profile.CurrentGamepadProfile
+            if action == InputActionTilt && !gamepad.tilted && gamepad.wasTilted {
+                return true
+            }
+            button := gamepad.GetInput(action)
+            return inpututil.IsGamepadButtonJustReleased(gamepad.GamepadID, button)
     }
 
     return false
 }
 
+func (profile *InputProfile) IsHeld(action InputAction) bool {
+    switch profile.CurrentProfile {
+        case UseProfileKeyboard:
+            key := profile.KeyboardProfile.GetInput(action)
+            return ebiten.IsKeyPressed(key)
+        case UseProfileGamepad:
+            gamepad := profile.CurrentGamepadProfile
+            if action == InputActionTilt && gamepad.tilted {
+                return true
+            }
+            button := gamepad.GetInput(action)
+            return ebiten.IsGamepadButtonPressed(gamepad.GamepadID, button)
+    }
+
+    return false
+}
+
+// how far the whammy bar is pushed, from 0 to 1
+func (profile *InputProfile) Whammy() float64 {
+    if profile.CurrentProfile == UseProfileGamepad {
+        return profile.CurrentGamepadProfile.Whammy.Value(profile.CurrentGamepadProfile.GamepadID)
+    }
+
+    return 0
+}
+
+// true if fret presses play notes by themselves, which is only done on the keyboard
+func (profile *InputProfile) FretStrum() bool {
+    return profile.CurrentProfile == UseProfileKeyboard && profile.KeyboardProfile.FretStrum
+}
+
+// read the analog inputs, once per tick
+func (profile *InputProfile) Update() {
+    if profile == nil {
+        return
+    }
+
+    if profile.CurrentProfile == UseProfileGamepad && profile.CurrentGamepadProfile != nil {
+        profile.CurrentGamepadProfile.updateTilt()
+    }
+}
+
+// ebiten only reports which tick an input changed in, not when
+func (profile *InputProfile) ChangeTime(action InputAction) time.Time {
+    return time.Time{}
+}
+
 type SerializedInputProfile struct {
     KeyboardProfile InputProfileKeyboard `json:"keyboard_profile"`
     GamepadProfiles []SerializedGamepadProfile `json:"gamepad_profiles"`
+    Midi MidiProfile `json:"midi"`
 }
 
-func (profile *InputProfile) Serialize(out io.Writer) error {
+// keys missing from older files keep their defaults
+func (serialized *SerializedInputProfile) UnmarshalJSON(data []byte) error {
+    type plain SerializedInputProfile
+    out := plain{
+        KeyboardProfile: *NewInputProfileKeyboard(),
+        Midi: DefaultMidiProfile(),
+    }
+
+    err := json.Unmarshal(data, &out)
+    if err != nil {
+        return err
+    }
+
+    *serialized = SerializedInputProfile(out)
+    return nil
+}
+
+func (profile *InputProfile) Serialized() SerializedInputProfile {
     serialized := SerializedInputProfile{
         KeyboardProfile: *profile.KeyboardProfile,
         GamepadProfiles: make([]SerializedGamepadProfile, 0),
+        Midi: profile.Midi.Clone(),
     }
 
     for _, gamepadProfile := range profile.GamepadProfiles {
         serialized.GamepadProfiles = append(serialized.GamepadProfiles, gamepadProfile.Serialize())
     }
 
+    serialized.GamepadProfiles = append(serialized.GamepadProfiles, profile.Disconnected...)
+
+    return serialized
+}
+
+func (profile *InputProfile) Serialize(out io.Writer) error {
+    serialized := profile.Serialized()
     encoder := json.NewEncoder(out)
     return encoder.Encode(&serialized)
 }
 
+func (serialized *SerializedInputProfile) Load() *InputProfile {
+    profile := NewInputProfile()
+    keyboard := serialized.KeyboardProfile
+    profile.KeyboardProfile = &keyboard
+    profile.Midi = serialized.Midi.Clone()
+
+    // profiles are attached as their controllers are found
+    profile.Disconnected = slices.Clone(serialized.GamepadProfiles)
+    for _, id := range ebiten.AppendGamepadIDs(nil) {
+        profile.AttachGamepad(id)
+    }
+
+    return profile
+}
 
 func LoadInputProfile(in io.Reader) (*InputProfile, error) {
     var serialized SerializedInputProfile
@@ -234,30 +589,5 @@ func LoadInputProfile(in io.Reader) (*InputProfile, error) {
         return nil, err
     }
 
-    profile := NewInputProfile()
-    profile.KeyboardProfile = &serialized.KeyboardProfile
-
-    gamepads := ebiten.AppendGamepadIDs(nil)
-    gamepadIDMap := make(map[string]ebiten.GamepadID)
-    for _, id := range gamepads {
-        sdlID := ebiten.GamepadSDLID(id)
-        gamepadIDMap[sdlID] = id
-    }
-
-    for _, serializedGamepadProfile := range serialized.GamepadProfiles {
-        gamepadID, ok := gamepadIDMap[serializedGamepadProfile.ID]
-        if ok {
-            gamepadProfile := NewInputProfileGamepad(gamepadID)
-            gamepadProfile.GreenButton = serializedGamepadProfile.GreenButton
-            gamepadProfile.RedButton = serializedGamepadProfile.RedButton
-            gamepadProfile.YellowButton = serializedGamepadProfile.YellowButton
-            gamepadProfile.BlueButton = serializedGamepadProfile.BlueButton
-            gamepadProfile.OrangeButton = serializedGamepadProfile.OrangeButton
-            gamepadProfile.StrumUpButton = serializedGamepadProfile.StrumUpButton
-            gamepadProfile.StrumDownButton = serializedGamepadProfile.StrumDownButton
-            profile.GamepadProfiles[gamepadID] = gamepadProfile
-        }
-    }
-
-    return profile, nil
+    return serialized.Load(), nil
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+    "sync"
+    "time"
+    "slices"
+)
+
+// the actions that are turned into events while a song is playing. frets come before the strum
+// so that pressing a fret and strumming in the same poll counts the fret as held
+var GameActions = []InputAction{
+    InputActionGreen,
+    InputActionRed,
+    InputActionYellow,
+    InputActionBlue,
+    InputActionOrange,
+    InputActionStrumUp,
+    InputActionStrumDown,
+    InputActionTilt,
+}
+
+type InputEvent struct {
+    Action InputAction
+    // true when the input was pressed, false when released
+    Pressed bool
+    // when the input happened, as precisely as the source can tell
+    Time time.Time
+    // a fret press that plays its note by itself, such as hitting a drum pad
+    Tap bool
+}
+
+// collects input events along with when they happened, so notes can be judged against the time
+// of the press rather than the time of the frame that noticed it. events can be pushed from other
+// goroutines by sources that know their own timestamps.
+type InputQueue struct {
+    lock sync.Mutex
+    events []InputEvent
+    lastPoll time.Time
+}
+
+// add an event, keeping the queue in time order
+func (queue *InputQueue) Push(event InputEvent) {
+    queue.lock.Lock()
+    defer queue.lock.Unlock()
+
+    index := len(queue.events)
+    for index > 0 && queue.events[index - 1].Time.After(event.Time) {
+        index -= 1
+    }
+
+    queue.events = slices.Insert(queue.events, index, event)
+}
+
+// look for presses and releases since the last poll. if the source doesn't know when an input
+// changed then all that is known is that it was between the previous poll and now, so the event is
+// placed halfway between them, which halves the average error compared to using the time of this
+// poll. ebiten only updates its input state once per tick, so polling more often (or from another
+// goroutine) can't narrow it down further.
+func (queue *InputQueue) Poll(input InputSource, now time.Time) {
+    estimate := now
+    if !queue.lastPoll.IsZero() && now.After(queue.lastPoll) {
+        estimate = queue.lastPoll.Add(now.Sub(queue.lastPoll) / 2)
+    }
+    queue.lastPoll = now
+
+    for _, action := range GameActions {
+        at := estimate
+        changed := input.ChangeTime(action)
+        if !changed.IsZero() {
+            at = changed
+        }
+
+        if input.IsJustReleased(action) {
+            queue.Push(InputEvent{Action: action, Pressed: false, Time: at})
+        }
+        if input.IsJustPressed(action) {
+            queue.Push(InputEvent{Action: action, Pressed: true, Time: at})
+        }
+    }
+}
+
+// remove and return all queued events in time order
+func (queue *InputQueue) Drain() []InputEvent {
+    queue.lock.Lock()
+    defer queue.lock.Unlock()
+
+    events := queue.events
+    queue.events = nil
+    return events
+}
+
+// throw away queued events and the time of the last poll, such as after the song was paused
+func (queue *InputQueue) Reset() {
+    queue.lock.Lock()
+    defer queue.lock.Unlock()
+
+    queue.events = nil
+    queue.lastPoll = time.Time{}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+    "time"
+    "slices"
+)
+
+// something that reports the state of the game actions, such as a keyboard or gamepad profile, or
+// a script of inputs for tests. the Just functions report changes since the previous tick.
+type InputSource interface {
+    IsJustPressed(action InputAction) bool
+    IsJustReleased(action InputAction) bool
+    IsHeld(action InputAction) bool
+    // when the action was last pressed or released, or the zero time if the source doesn't know
+    // more precisely than the current tick
+    ChangeTime(action InputAction) time.Time
+    // how far the whammy bar is pushed, from 0 to 1
+    Whammy() float64
+    // true if pressing a fret plays a note without strumming
+    FretStrum() bool
+}
+
+// plays back a fixed list of input events, for driving gameplay without a window
+type ScriptedInput struct {
+    events []InputEvent
+    next int
+
+    held map[InputAction]bool
+    justPressed map[InputAction]bool
+    justReleased map[InputAction]bool
+    changed map[InputAction]time.Time
+
+    // the whammy position, set by the test
+    WhammyValue float64
+    // fret presses play notes, set by the test
+    FretStrumMode bool
+}
+
+func NewScriptedInput(events []InputEvent) *ScriptedInput {
+    sorted := slices.Clone(events)
+    slices.SortStableFunc(sorted, func(a InputEvent, b InputEvent) int {
+        return a.Time.Compare(b.Time)
+    })
+
+    return &ScriptedInput{
+        events: sorted,
+        held: make(map[InputAction]bool),
+        justPressed: make(map[InputAction]bool),
+        justReleased: make(map[InputAction]bool),
+        changed: make(map[InputAction]time.Time),
+    }
+}
+
+// apply all the events up to and including 'now', like a tick of the game loop. if an action is
+// both pressed and released within one step only its final state is seen, as with a real device.
+func (input *ScriptedInput) Advance(now time.Time) {
+    clear(input.justPressed)
+    clear(input.justReleased)
+
+    for input.next < len(input.events) && !input.events[input.next].Time.After(now) {
+        event := input.events[input.next]
+        input.next += 1
+
+        if event.Pressed == input.held[event.Action] {
+            continue
+        }
+
+        input.held[event.Action] = event.Pressed
+        input.changed[event.Action] = event.Time
+
+        if event.Pressed {
+            if input.justReleased[event.Action] {
+                delete(input.justReleased, event.Action)
+            } else {
+                input.justPressed[event.Action] = true
+            }
+        } else {
+            if input.justPressed[event.Action] {
+                delete(input.justPressed, event.Action)
+            } else {
+                input.justReleased[event.Action] = true
+            }
+        }
+    }
+}
+
+// true once every event has been played
+func (input *ScriptedInput) Done() bool {
+    return input.next >= len(input.events)
+}
+
+func (input *ScriptedInput) IsJustPressed(action InputAction) bool {
+    return input.justPressed[action]
+}
+
+func (input *ScriptedInput) IsJustReleased(action InputAction) bool {
+    return input.justReleased[action]
+}
+
+func (input *ScriptedInput) IsHeld(action InputAction) bool {
+    return input.held[action]
+}
+
+func (input *ScriptedInput) ChangeTime(action InputAction) time.Time {
+    return input.changed[action]
+}
+
+func (input *ScriptedInput) Whammy() float64 {
+    return input.WhammyValue
+}
+
+func (input *ScriptedInput) FretStrum() bool {
+    return input.FretStrumMode
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+    "slices"
     "log"
     "time"
     "image"
@@ -24,6 +25,10 @@ import (
 
     "github.com/kazzmir/rhythm/lib/coroutine"
     "github.com/kazzmir/rhythm/lib/colorconv"
+    "github.com/kazzmir/rhythm/lib/seekable"
+    "github.com/kazzmir/rhythm/lib/flac"
+    "github.com/kazzmir/rhythm/lib/wav"
+    "github.com/kazzmir/rhythm/lib/drift"
     "github.com/kazzmir/rhythm/data"
 
     smflib "gitlab.com/gomidi/midi/v2/smf"
@@ -44,43 +49,6 @@ import (
 const ScreenWidth = 1400
 const ScreenHeight = 1000
 
-type ConfigurationManager struct {
-}
-
-func (config *ConfigurationManager) LoadInputProfile() *InputProfile {
-    file, err := os.Open("config.json")
-    if err == nil {
-        defer file.Close()
-        buffer := bufio.NewReader(file)
-
-        profile, err := LoadInputProfile(buffer)
-        if err == nil {
-            return profile
-        } else {
-            log.Printf("Failed to load input profile from config.json: %v", err)
-        }
-    }
-
-    return NewInputProfile()
-}
-
-func (config *ConfigurationManager) SaveConfiguration(doSave func (io.Writer) error) error {
-    file, err := os.Create("config.json")
-    if err != nil {
-        return err
-    }
-
-    defer file.Close()
-
-    buffer := bufio.NewWriter(file)
-    defer buffer.Flush()
-
-    return doSave(buffer)
-}
-
-const NoteThresholdHigh = time.Millisecond * 250
-const NoteThresholdLow = -time.Millisecond * 150
-
 type NoteState int
 const (
     NoteStatePending NoteState = iota
@@ -127,6 +95,7 @@ const (
     InputActionOrange
     InputActionStrumUp
     InputActionStrumDown
+    InputActionTilt
 )
 
 func (action InputAction) String() string {
@@ -138,6 +107,7 @@ func (action InputAction) String() string {
         case InputActionOrange: return "Orange"
         case InputActionStrumUp: return "Strum Up"
         case InputActionStrumDown: return "Strum Down"
+        case InputActionTilt: return "Tilt"
         default: return "Unknown"
     }
 }
@@ -170,6 +140,9 @@ type SongInfo struct {
     Genre string
     Year string
     SongLength time.Duration
+    // zero if the song.ini doesn't say where the preview starts
+    PreviewStart time.Duration
+    PreviewEnd time.Duration
 }
 
 type FlameMaker interface {
@@ -187,7 +160,28 @@ func (batch LyricBatch) EndTime() time.Duration {
 
 type Part struct {
     Name string
+    Stem Stem
     Player *audio.Player
+    // nil if the part isn't routed through the mixer
+    Track *MixerTrack
+}
+
+// volume relative to the mixer settings
+func (part *Part) SetVolume(volume float64) {
+    if part.Track != nil {
+        part.Track.SetGain(volume)
+    } else {
+        part.Player.SetVolume(volume)
+    }
+}
+
+func (part *Part) Close() {
+    part.Player.Pause()
+    part.Player.Close()
+    if part.Track != nil {
+        part.Track.mixer.Remove(part.Track)
+        part.Track = nil
+    }
 }
 
 type Song struct {
@@ -200,6 +194,11 @@ type Song struct {
     LyricBatch int
 
     Parts []Part
+    // the instrument being played, which decides which parts duck on a miss
+    Instrument Instrument
+
+    // the song's own neck texture, nil to use the player's skin
+    Highway *ebiten.Image
 
     DoSong sync.Once
     NotesHit int
@@ -209,13 +208,48 @@ type Song struct {
     Counter uint64
 
     Score int
+    // notes hit in a row
+    Streak int
+
+    // how far the whammy bar is pushed, from 0 to 1
+    Whammy float64
+    // the star power meter, from 0 to 1
+    StarPower float64
+    StarPowerActive bool
+    LastUpdate time.Time
+    // when the song was paused, or zero if it is playing
+    PausedAt time.Time
+
+    Timing TimingWindows
+    // strums must alternate between up and down
+    AlternateStrum bool
+    lastStrum InputAction
+    lastStrumTime time.Time
+    // fret presses play notes by themselves, so strums are ignored. events marked as taps play
+    // their notes either way
+    FretStrum bool
+    // what the input debug overlay shows, nil if nothing is recorded
+    Debug *InputDebug
+    JudgementCounts map[Judgement]int
+    // recent judgements that are still being drawn
+    Judgements []JudgementPopup
 
     SongInfo SongInfo
+
+    // nil for no sound effects
+    Effects *SoundBank
+
+    // keeps the stems and the chart aligned with the master stem
+    DriftMonitor *drift.Monitor
+    LastDriftCheck time.Time
 }
 
+// how long to keep showing the highway after the song ends
+const SongEndDelay = time.Second * 2
+
 func (song *Song) Finished() bool {
     delta := time.Since(song.StartTime)
-    return delta >= song.SongLength + time.Second * 2
+    return delta >= song.SongLength + SongEndDelay
 }
 
 // total notes seen so far
@@ -223,10 +257,29 @@ func (song *Song) TotalNotes() int {
     return song.NotesHit + song.NotesMissed
 }
 
+// parts for the instrument being played use the instrument volume, everything else is music
+func (song *Song) partChannel(part *Part) MixerChannel {
+    if song.Instrument.Ducks(part.Stem) {
+        return MixerChannelInstrument
+    }
+
+    return MixerChannelMusic
+}
+
+func (song *Song) MakeResult(path string, completed bool) SongResult {
+    return SongResult{
+        Path: path,
+        SongInfo: song.SongInfo,
+        NotesHit: song.NotesHit,
+        NotesMissed: song.NotesMissed,
+        Score: song.Score,
+        Completed: completed,
+    }
+}
+
 func (song *Song) Close() {
-    for _, part := range song.Parts {
-        part.Player.Pause()
-        part.Player.Close()
+    for i := range song.Parts {
+        song.Parts[i].Close()
     }
 
     for _, cleanup := range song.CleanupFuncs {
@@ -234,7 +287,64 @@ func (song *Song) Close() {
     }
 }
 
-func (song *Song) Update(input *InputProfile, flameMaker FlameMaker) {
+// stop the music and the chart, for example while waiting for a controller to come back
+func (song *Song) Pause(now time.Time) {
+    song.PausedAt = now
+    for _, part := range song.Parts {
+        part.Player.Pause()
+    }
+}
+
+// carry on from where the song was paused. everything that is timed from the start of the song is
+// moved forward by how long it was paused for
+func (song *Song) Resume(now time.Time) {
+    if song.PausedAt.IsZero() {
+        return
+    }
+
+    paused := now.Sub(song.PausedAt)
+    song.PausedAt = time.Time{}
+
+    song.StartTime = song.StartTime.Add(paused)
+    song.LastUpdate = now
+    song.LastDriftCheck = now
+    if !song.lastStrumTime.IsZero() {
+        song.lastStrumTime = song.lastStrumTime.Add(paused)
+    }
+    for i := range song.Judgements {
+        song.Judgements[i].Time = song.Judgements[i].Time.Add(paused)
+    }
+
+    for _, part := range song.Parts {
+        part.Player.Play()
+    }
+}
+
+// what happened to the played instrument during an update, which decides whether its stems are ducked
+type instrumentState struct {
+    playGuitar bool
+    stopGuitar bool
+    changeGuitar bool
+}
+
+// the index of the fret controlled by the action, or -1 if the action isn't a fret
+func (song *Song) fretForAction(action InputAction) int {
+    for i := range song.Frets {
+        if song.Frets[i].InputAction == action {
+            return i
+        }
+    }
+
+    return -1
+}
+
+func (song *Song) Update(events []InputEvent, flameMaker FlameMaker) {
+    song.UpdateAt(time.Now(), events, flameMaker)
+}
+
+// process the input events that happened since the last update, in order, and then advance the
+// song to 'now'. notes are judged against the time of the event that played them rather than now
+func (song *Song) UpdateAt(now time.Time, events []InputEvent, flameMaker FlameMaker) {
     song.Counter += 1
 
     song.DoSong.Do(func(){
@@ -244,7 +354,18 @@ func (song *Song) Update(input *InputProfile, flameMaker FlameMaker) {
     })
 
     if song.StartTime.IsZero() {
-        song.StartTime = time.Now()
+        song.StartTime = now
+    }
+
+    var elapsed time.Duration
+    if !song.LastUpdate.IsZero() {
+        elapsed = now.Sub(song.LastUpdate)
+    }
+    song.LastUpdate = now
+
+    if now.Sub(song.LastDriftCheck) >= DriftCheckInterval {
+        song.LastDriftCheck = now
+        song.syncStems()
     }
 
     /*
@@ -261,132 +382,74 @@ func (song *Song) Update(input *InputProfile, flameMaker FlameMaker) {
     }
     */
 
-    for i := range song.Frets {
-        fret := &song.Frets[i]
-
-        if input.IsJustPressed(fret.InputAction) {
-            fret.Press = time.Now()
-        } else if input.IsJustReleased(fret.InputAction) {
-            fret.Press = time.Time{}
-        }
+    var state instrumentState
 
-        /*
-        key := input.GetKeyboardButton(fret.InputAction)
-        if inpututil.IsKeyJustPressed(key) {
-            fret.Press = time.Now()
-        } else if inpututil.IsKeyJustReleased(key) {
-            fret.Press = time.Time{}
-        }
+    song.Debug.AddEvents(events)
 
-        if input.HasGamepad() {
-            button := input.GetGamepadButton(fret.InputAction)
-            if inpututil.IsGamepadButtonJustPressed(input.CurrentGamepadProfile.GamepadID, button) {
-                fret.Press = time.Now()
-            } else if inpututil.IsGamepadButtonJustReleased(input.CurrentGamepadProfile.GamepadID, button) {
+    for _, event := range events {
+        fretIndex := song.fretForAction(event.Action)
+        if fretIndex != -1 {
+            fret := &song.Frets[fretIndex]
+            if event.Pressed {
+                fret.Press = event.Time
+                if song.FretStrum || event.Tap {
+                    song.judge(event.Time, fretIndex, flameMaker, &state)
+                }
+            } else {
                 fret.Press = time.Time{}
             }
+        } else if isStrum(event.Action) && event.Pressed && !song.FretStrum {
+            if song.AlternateStrum && song.repeatedStrum(event) {
+                song.overstrum(event.Time)
+            } else {
+                song.judge(event.Time, -1, flameMaker, &state)
+            }
+            song.lastStrum = event.Action
+            song.lastStrumTime = event.Time
+        } else if event.Action == InputActionTilt && event.Pressed {
+            song.activateStarPower()
         }
-        */
     }
 
-    playGuitar := false
-    stopGuitar := false
-    changeGuitar := false
-
-    // when true, we don't need to strum
-    allTapsMode := false
+    // a note went by without being played
+    passedNote := false
+    // a sustain was bent with the whammy bar
+    whammied := false
 
-    forceMiss := false
-
-    var notesHit []*Note
-
-    strummed := input.IsJustPressed(InputActionStrumDown)
-
-    /*
-    strummed := inpututil.IsKeyJustPressed(input.GetKeyboardButton(InputActionStrumDown))
-    if input.HasGamepad() {
-        button := input.GetGamepadButtons(InputActionStrumDown)
-        strummed = strummed || inpututil.IsGamepadButtonJustPressed(input.CurrentGamepadProfile.GamepadID, button)
-    }
-    */
-
-    delta := time.Since(song.StartTime)
+    delta := now.Sub(song.StartTime)
     for fretIndex := range song.Frets {
-        /*
-        if i == 1 {
-            break
-        }
-        */
-
         fret := &song.Frets[fretIndex]
 
         if fret.StartNote < len(fret.Notes) {
-            for fret.StartNote < len(fret.Notes) && fret.Notes[fret.StartNote].End < delta + NoteThresholdLow {
+            for fret.StartNote < len(fret.Notes) && fret.Notes[fret.StartNote].End < delta - song.Timing.Late {
 
                 if fret.Notes[fret.StartNote].State == NoteStatePending {
                     fret.Notes[fret.StartNote].State = NoteStateMissed
                     song.NotesMissed += 1
+                    song.Debug.AddResult(DebugResult{Kind: DebugResultNotPlayed, Fret: fretIndex})
+                    passedNote = true
                 }
 
                 fret.StartNote += 1
             }
         }
 
-        pressed := false
-
-        if allTapsMode {
-            pressed = input.IsJustPressed(fret.InputAction)
-            /*
-            key := input.GetKeyboardButton(fret.InputAction)
-            pressed = inpututil.IsKeyJustPressed(key)
-            if input.HasGamepad() {
-                button := input.GetGamepadButton(fret.InputAction)
-                if inpututil.IsGamepadButtonJustPressed(input.CurrentGamepadProfile.GamepadID, button) {
-                    pressed = true
-                }
-            }
-            */
-        } else {
-            pressed = !fret.Press.IsZero() && strummed
-        }
-
-        needKey := false
-
-        // check if we are pressing the key for the current note
         for i := fret.StartNote; i < len(fret.Notes); i++ {
             note := &fret.Notes[i]
-            noteDiff := note.Start - delta
+            offset := delta - note.Start
 
-            if noteDiff > NoteThresholdHigh {
+            if offset < -song.Timing.Early {
                 break
             }
 
             if note.State == NoteStatePending {
-                if noteDiff < NoteThresholdLow {
+                if offset > song.Timing.Late {
                     note.State = NoteStateMissed
                     song.NotesMissed += 1
-                    stopGuitar = true
-                    changeGuitar = true
-                } else if noteDiff >= NoteThresholdLow && noteDiff <= NoteThresholdHigh {
-                    // user should have pressed the key here
-                    needKey = true
-
-                    if pressed {
-                        notesHit = append(notesHit, note)
-                        flameMaker.MakeFlame(fretIndex)
-                    }
-
-                    /*
-                    keyDiff := delta - fret.Press.Sub(engine.StartTime)
-                    log.Printf("Key diff: %v", keyDiff)
-                    if keyDiff >= NoteThresholdLow && keyDiff <= NoteThresholdHigh {
-                        note.State = NoteStateHit
-                        engine.NotesHit += 1
-                    } else {
-                        note.State = NoteStateMissed
-                        engine.NotesMissed += 1
-                    }
-                    */
+                    song.Debug.AddResult(DebugResult{Kind: DebugResultNotPlayed, Fret: fretIndex})
+                    passedNote = true
+                    state.stopGuitar = true
+                    state.changeGuitar = true
                 }
             } else if note.State == NoteStateHit && note.Sustain {
                 // determine if the note has a sustained part and the keys are still held
@@ -394,7 +457,12 @@ func (song *Song) Update(input *InputProfile, flameMaker FlameMaker) {
                     if fret.Press.IsZero() {
                         note.Sustain = false
                     } else {
-                        song.Score += 1
+                        song.Score += song.scoreMultiplier()
+
+                        if song.Whammy >= WhammyThreshold {
+                            song.Score += song.scoreMultiplier()
+                            whammied = true
+                        }
 
                         if song.Counter % 5 == 0 {
                             flameMaker.MakeFlame(fretIndex)
@@ -403,42 +471,27 @@ func (song *Song) Update(input *InputProfile, flameMaker FlameMaker) {
                 }
             }
         }
-
-        if !needKey && pressed {
-            forceMiss = true
-        }
     }
 
-    if forceMiss {
-        for _, note := range notesHit {
-            note.State = NoteStateMissed
-            note.Sustain = false
-            song.NotesMissed += 1
-            playGuitar = false
-            changeGuitar = true
-        }
-    } else {
-        for _, note := range notesHit {
-            note.State = NoteStateHit
-            note.Sustain = true
-            song.NotesHit += 1
-            playGuitar = true
-            changeGuitar = true
-            song.Score += 5
-        }
+    song.updateStarPower(elapsed, whammied)
+
+    if passedNote {
+        song.Streak = 0
+        song.Effects.Play(SoundEffectMiss)
     }
 
-    if changeGuitar {
-        var guitarPart *audio.Player
-        for _, part := range song.Parts {
-            if part.Name == "guitar" {
-                guitarPart = part.Player
-            }
-        }
+    song.Judgements = slices.DeleteFunc(song.Judgements, func(popup JudgementPopup) bool {
+        return now.Sub(popup.Time) > JudgementDisplayTime
+    })
 
-        if guitarPart != nil {
+    if state.changeGuitar {
+        for i := range song.Parts {
+            guitarPart := &song.Parts[i]
+            if !song.Instrument.Ducks(guitarPart.Stem) {
+                continue
+            }
 
-            if playGuitar && !stopGuitar {
+            if state.playGuitar && !state.stopGuitar {
 
                 guitarPart.SetVolume(1.0)
 
@@ -463,6 +516,132 @@ func (song *Song) Update(input *InputProfile, flameMaker FlameMaker) {
     }
 }
 
+func isStrum(action InputAction) bool {
+    return action == InputActionStrumUp || action == InputActionStrumDown
+}
+
+// true if this strum goes the same way as the previous one while strums must alternate. after a
+// pause the player can start again in either direction
+func (song *Song) repeatedStrum(event InputEvent) bool {
+    if song.lastStrumTime.IsZero() || event.Time.Sub(song.lastStrumTime) > AlternateStrumReset {
+        return false
+    }
+
+    return event.Action == song.lastStrum
+}
+
+func (song *Song) overstrum(at time.Time) {
+    fret, offset, ok := song.nearestNote(at.Sub(song.StartTime))
+    song.Debug.AddResult(DebugResult{Kind: DebugResultOverstrum, Fret: fret, Offset: offset, HasOffset: ok})
+
+    song.Streak = 0
+    song.Effects.Play(SoundEffectOverstrum)
+}
+
+// judge a strum, or a tap on a single fret if tapped isn't -1, that happened at the given time.
+// frets that are held when strumming must match the notes that can be hit at that time
+func (song *Song) judge(at time.Time, tapped int, flameMaker FlameMaker, state *instrumentState) {
+    delta := at.Sub(song.StartTime)
+
+    forceMiss := false
+
+    var notesHit []*Note
+    // how late each hit note was played, negative if early
+    var hitOffsets []time.Duration
+    var hitFrets []int
+
+    for fretIndex := range song.Frets {
+        fret := &song.Frets[fretIndex]
+
+        pressed := !fret.Press.IsZero()
+        if tapped != -1 {
+            pressed = fretIndex == tapped
+        }
+
+        needKey := false
+
+        // check if we are pressing the key for the current note
+        for i := fret.StartNote; i < len(fret.Notes); i++ {
+            note := &fret.Notes[i]
+            offset := delta - note.Start
+
+            if offset < -song.Timing.Early {
+                break
+            }
+
+            if note.State == NoteStatePending && song.Timing.Contains(offset) {
+                // user should have pressed the key here
+                needKey = true
+
+                if pressed {
+                    notesHit = append(notesHit, note)
+                    hitOffsets = append(hitOffsets, offset)
+                    hitFrets = append(hitFrets, fretIndex)
+                    flameMaker.MakeFlame(fretIndex)
+                }
+            }
+        }
+
+        if !needKey && pressed {
+            forceMiss = true
+        }
+    }
+
+    if forceMiss {
+        for i, note := range notesHit {
+            song.Debug.AddResult(DebugResult{Kind: DebugResultWrongFret, Fret: hitFrets[i], Offset: hitOffsets[i], HasOffset: true})
+            note.State = NoteStateMissed
+            note.Sustain = false
+            song.NotesMissed += 1
+            state.playGuitar = false
+            state.changeGuitar = true
+        }
+    } else {
+        // a chord is shown with the judgement of its least accurate note
+        var popup JudgementPopup
+        for i, note := range notesHit {
+            note.State = NoteStateHit
+            note.Sustain = true
+            song.NotesHit += 1
+            state.playGuitar = true
+            state.changeGuitar = true
+
+            song.Debug.AddResult(DebugResult{Kind: DebugResultHit, Fret: hitFrets[i], Offset: hitOffsets[i], HasOffset: true})
+
+            judgement := song.Timing.Judge(hitOffsets[i])
+            song.Score += judgement.Score() * song.scoreMultiplier()
+            song.JudgementCounts[judgement] += 1
+
+            if i == 0 || judgement > popup.Judgement {
+                popup = JudgementPopup{
+                    Judgement: judgement,
+                    Offset: hitOffsets[i],
+                    Time: at,
+                }
+            }
+        }
+
+        if len(notesHit) > 0 {
+            song.Judgements = append(song.Judgements, popup)
+        }
+    }
+
+    switch {
+        case forceMiss && len(notesHit) > 0:
+            song.Streak = 0
+            song.Effects.Play(SoundEffectMiss)
+        case len(notesHit) == 0:
+            // strummed or tapped with nothing to play
+            song.overstrum(at)
+        case len(notesHit) > 0:
+            oldStreak := song.Streak
+            song.Streak += len(notesHit)
+            if song.Streak / StreakMilestone > oldStreak / StreakMilestone {
+                song.Effects.Play(SoundEffectStreak)
+            }
+    }
+}
+
 // returns the index of the guitar track, or -1 if not found
 func findTrackByName(smf *smflib.SMF, name string) int {
     for i, track := range smf.Tracks {
@@ -555,27 +734,68 @@ func findFile(basefs fs.FS, name string) (fs.File, error) {
 func isAudioFile(name string) bool {
     ext := strings.ToLower(filepath.Ext(name))
     switch ext {
-        case ".mp3", ".ogg", ".opus":
+        case ".mp3", ".ogg", ".opus", ".wav", ".flac":
             return true
         default:
             return false
     }
 }
 
-func loadAudio2(audioContext *audio.Context, basefs fs.FS, name string, ext string) (*audio.Player, time.Duration, func(), error) {
+// open a file in a way that the decoders can seek in it. files on disk are used directly, while
+// files that can't seek (such as zip entries) are buffered as they are read
+func openSeekable(basefs fs.FS, name string) (io.ReadSeeker, func(), error) {
     file, err := basefs.Open(name)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    closer := func(){
+        file.Close()
+    }
+
+    seeker, ok := file.(io.ReadSeeker)
+    if ok {
+        return seeker, closer, nil
+    }
+
+    size := int64(-1)
+    info, err := file.Stat()
+    if err == nil {
+        size = info.Size()
+    }
+
+    return seekable.NewBufferedReadSeeker(file, size), closer, nil
+}
+
+// the returned cleanup function must be called after the player is closed
+func loadAudio2(audioContext *audio.Context, basefs fs.FS, name string, ext string) (*audio.Player, time.Duration, func(), error) {
+    source, closeSource, err := openSeekable(basefs, name)
     if err != nil {
         return nil, 0, nil, fmt.Errorf("Unable to open audio file '%v': %v", name, err)
     }
-    defer file.Close()
+
+    var player *audio.Player
+    var duration time.Duration
+    var cleanup func()
 
     switch ext {
-        case ".mp3": return loadMp3(audioContext, file, name)
-        case ".ogg": return loadOgg(audioContext, file, name)
-        case ".opus": return loadOpus(audioContext, file, name)
+        case ".mp3": player, duration, cleanup, err = loadMp3(audioContext, source, name)
+        case ".ogg": player, duration, cleanup, err = loadOgg(audioContext, source, name)
+        case ".opus": player, duration, cleanup, err = loadOpus(audioContext, source, name)
+        case ".wav": player, duration, cleanup, err = loadWav(audioContext, source, name)
+        case ".flac": player, duration, cleanup, err = loadFlac(audioContext, source, name)
+        default: err = fmt.Errorf("Unsupported audio file extension '%v' for file '%v'", ext, name)
+    }
+
+    if err != nil {
+        closeSource()
+        return nil, 0, nil, err
     }
 
-    return nil, 0, nil, fmt.Errorf("Unsupported audio file extension '%v' for file '%v'", ext, name)
+    return player, duration, func(){
+        cleanup()
+        closeSource()
+    }, nil
 }
 
 // find all audio files in the basefs
@@ -590,10 +810,22 @@ func loadSongParts(audioContext *audio.Context, basefs fs.FS) ([]Part, time.Dura
         }
 
         if isAudioFile(path) {
+            stem := ParseStem(path)
+
+            // the preview is a cut of the song for the song list, so it shouldn't play along with the song
+            if stem == StemPreview {
+                return nil
+            }
+
+            if stem == StemUnknown {
+                log.Printf("Unknown stem '%v', playing it as part of the backing music", path)
+            }
+
             player, duration, cleanup, err := loadAudio2(audioContext, basefs, path, strings.ToLower(filepath.Ext(path)))
             if err == nil {
                 parts = append(parts, Part{
                     Name: strings.TrimSuffix(path, filepath.Ext(path)),
+                    Stem: stem,
                     Player: player,
                 })
                 cleanupFuncs = append(cleanupFuncs, cleanup)
@@ -609,9 +841,17 @@ func loadSongParts(audioContext *audio.Context, basefs fs.FS) ([]Part, time.Dura
     return parts, longest, cleanupFuncs, err
 }
 
-func MakeSong(audioContext *audio.Context, songDirectory string, difficulty string) (*Song, error) {
+// the number of frets on the guitar
+const GuitarFrets = 5
+
+func MakeSong(audioContext *audio.Context, mixer *Mixer, songDirectory string, difficulty string, timing TimingWindows) (*Song, error) {
     song := Song{
-        Frets: make([]Fret, 5),
+        Frets: make([]Fret, GuitarFrets),
+        Timing: timing,
+        JudgementCounts: make(map[Judgement]int),
+        // only the guitar chart is read for now
+        Instrument: InstrumentGuitar,
+        DriftMonitor: NewDriftMonitor(),
     }
 
     song.Frets[0].InputAction = InputActionGreen
@@ -623,16 +863,19 @@ func MakeSong(audioContext *audio.Context, songDirectory string, difficulty stri
 
     var basefs fs.FS
 
+    // the audio streams out of the zip while the song plays, so it is only closed when the song is
+    var zipFile *os.File
+
     if isZip(songDirectory) {
-        zipFile, err := os.Open(songDirectory)
+        var err error
+        zipFile, err = os.Open(songDirectory)
         if err != nil {
             return nil, fmt.Errorf("Unable to open song zip file '%v': %v", songDirectory, err)
         }
 
-        defer zipFile.Close()
-
         zipper, err := zip.NewReader(zipFile, getFileSize(zipFile))
         if err != nil {
+            zipFile.Close()
             return nil, fmt.Errorf("Unable to read song zip file '%v': %v", songDirectory, err)
         }
 
@@ -643,8 +886,19 @@ func MakeSong(audioContext *audio.Context, songDirectory string, difficulty stri
 
     var err error
 
-    song.Parts, song.SongLength, song.CleanupFuncs, err = loadSongParts(audioContext, basefs)
+    song.Highway = loadSongHighway(basefs)
+
+    var audioLength time.Duration
+    song.Parts, audioLength, song.CleanupFuncs, err = loadSongParts(audioContext, basefs)
+    mixer.AddParts(song.Parts, song.partChannel)
+    if zipFile != nil {
+        song.CleanupFuncs = append(song.CleanupFuncs, func(){
+            zipFile.Close()
+        })
+    }
+
     if err != nil {
+        song.Close()
         return nil, fmt.Errorf("Unable to load song parts: %v", err)
     }
 
@@ -652,30 +906,67 @@ func MakeSong(audioContext *audio.Context, songDirectory string, difficulty stri
 
     notesFile, err := findFile(basefs, "notes.mid")
     if err != nil {
+        song.Close()
         return nil, fmt.Errorf("Unable to open MIDI file '%v': %v", "notes.mid", err)
     }
     defer notesFile.Close()
 
     notesData, err := io.ReadAll(bufio.NewReader(notesFile))
     if err != nil {
+        song.Close()
         return nil, fmt.Errorf("Unable to read MIDI file '%v': %v", "notes.mid", err)
     }
 
+    iniFile, err := findFile(basefs, "song.ini")
+    if err == nil {
+        defer iniFile.Close()
+        song.SongInfo = loadSongInfo(iniFile)
+        log.Printf("Loaded song info: %+v", song.SongInfo)
+    }
+
+    song.SongLength = reconcileSongLength(audioLength, song.SongInfo.SongLength, lastChartEvent(notesData))
+
     err = song.ReadNotes(notesData, difficulty, song.SongLength)
     if err != nil {
+        song.Close()
         return nil, err
     }
 
     err = song.ReadLyrics(notesData)
 
-    iniFile, err := findFile(basefs, "song.ini")
-    if err == nil {
-        defer iniFile.Close()
-        song.SongInfo = loadSongInfo(iniFile)
-        log.Printf("Loaded song info: %+v", song.SongInfo)
+    return &song, nil
+}
+
+// the time of the last event in any track of the midi file
+func lastChartEvent(notesData []byte) time.Duration {
+    var last int64
+
+    reader := smflib.ReadTracksFrom(bytes.NewReader(notesData))
+    if reader.Error() != nil {
+        return 0
     }
 
-    return &song, nil
+    reader.Do(func (event smflib.TrackEvent) {
+        last = max(last, event.AbsMicroSeconds)
+    })
+
+    return time.Microsecond * time.Duration(last)
+}
+
+// the decoded audio is the most accurate source for the length of the song, song.ini is used if there
+// is no audio, and the song always lasts at least until the last event in the chart
+func reconcileSongLength(audioLength time.Duration, iniLength time.Duration, lastEvent time.Duration) time.Duration {
+    length := audioLength
+    if length <= 0 {
+        length = iniLength
+    } else if iniLength > 0 {
+        difference := max(audioLength - iniLength, iniLength - audioLength)
+        if difference > time.Second {
+            log.Printf("Warning: audio length %v differs from song.ini song_length %v", audioLength, iniLength)
+        }
+    }
+
+    return max(length, lastEvent)
 }
 
 // load song info from song.ini file
@@ -706,6 +997,17 @@ func loadSongInfo(file fs.File) SongInfo {
                     if err == nil {
                         out.SongLength = time.Millisecond * time.Duration(v)
                     }
+                case "preview_start_time":
+                    // some charts use -1 to mean no preview time
+                    v, err := strconv.ParseInt(value, 10, 64)
+                    if err == nil && v > 0 {
+                        out.PreviewStart = time.Millisecond * time.Duration(v)
+                    }
+                case "preview_end_time":
+                    v, err := strconv.ParseInt(value, 10, 64)
+                    if err == nil && v > 0 {
+                        out.PreviewEnd = time.Millisecond * time.Duration(v)
+                    }
             }
         }
     }
@@ -861,8 +1163,23 @@ type Engine struct {
 
     Coroutine *coroutine.Coroutine
     Configuration *ConfigurationManager
-
-    // GamepadIds map[ebiten.GamepadID]struct{}
+    Mixer *Mixer
+    SoundEffects *SoundBank
+    Players *PlayerLibrary
+    // the player who is playing
+    Player *PlayerProfile
+    // the current player's controls, shared by the menus and gameplay
+    Input *InputProfile
+
+    // connected controllers and their names
+    GamepadIds map[ebiten.GamepadID]string
+    Toasts []Toast
+    // set when the controller being played was unplugged
+    InputLost bool
+    // the current player's midi device, or nil if there isn't one
+    Midi *MidiInput
+    // show the input debug overlay while playing
+    ShowInputDebug bool
 
     // GuitarButtonMesh *tetra3d.Mesh
 }
@@ -886,39 +1203,44 @@ func (engine *Engine) LastDrawer() func(screen *ebiten.Image) {
     return func(screen *ebiten.Image) {}
 }
 
-func loadMp3(audioContext *audio.Context, file fs.File, name string) (*audio.Player, time.Duration, func(), error) {
-    allData, err := io.ReadAll(bufio.NewReader(file))
-    if err != nil {
-        return nil, 0, nil, err
+// 16-bit samples, 2 channels
+const PCMBytesPerFrame = 2 * 2
+
+// the exact playing time of 'size' bytes of 16-bit stereo pcm data
+func pcmDuration(size int64, sampleRate int) time.Duration {
+    if sampleRate <= 0 {
+        return 0
     }
 
-    // this decodes on the fly, so we need all the data in memory when we pass a reader
-    songReader, err := mp3.DecodeWithSampleRate(audioContext.SampleRate(), bytes.NewReader(allData))
+    frames := size / PCMBytesPerFrame
+    rate := int64(sampleRate)
+
+    // split into whole seconds and the remainder to avoid overflowing for long songs
+    return time.Duration(frames / rate) * time.Second + time.Duration(frames % rate) * time.Second / time.Duration(rate)
+}
+
+// the decoders read from source on the fly, so it must stay open until the player is closed
+func loadMp3(audioContext *audio.Context, source io.ReadSeeker, name string) (*audio.Player, time.Duration, func(), error) {
+    songReader, err := mp3.DecodeWithSampleRate(audioContext.SampleRate(), source)
     if err != nil {
         return nil, 0, nil, err
     }
 
-    // divide by 2 for 16-bit samples, divide by 2 for stereo
-    length := songReader.Length() / 2 / 2 / int64(songReader.SampleRate())
-    // log.Printf("OGG file '%s' rate %v bytes %v length: %v", name, songReader.SampleRate(), songReader.Length(), time.Duration(length) * time.Second)
+    length := pcmDuration(songReader.Length(), songReader.SampleRate())
+    // log.Printf("OGG file '%s' rate %v bytes %v length: %v", name, songReader.SampleRate(), songReader.Length(), length)
 
     songPlayer, err := audioContext.NewPlayer(songReader)
     if err != nil {
         return nil, 0, nil, err
     }
 
-    log.Printf("Loaded MP3 file '%s' length %d", name, len(allData))
+    log.Printf("Loaded MP3 file '%s' length %d", name, songReader.Length())
 
-    return songPlayer, time.Duration(length) * time.Second, func(){}, nil
+    return songPlayer, length, func(){}, nil
 }
 
-func loadOpus(audioContext *audio.Context, file fs.File, name string) (*audio.Player, time.Duration, func(), error) {
-    allData, err := io.ReadAll(bufio.NewReader(file))
-    if err != nil {
-        return nil, 0, nil, err
-    }
-
-    opusPlayer, err := opusgo.NewPlayerFromReader(bytes.NewReader(allData))
+func loadOpus(audioContext *audio.Context, source io.ReadSeeker, name string) (*audio.Player, time.Duration, func(), error) {
+    opusPlayer, err := opusgo.NewPlayerFromReader(source)
     if err != nil {
         return nil, 0, nil, err
     }
@@ -926,7 +1248,7 @@ func loadOpus(audioContext *audio.Context, file fs.File, name string) (*audio.Pl
     length := opusPlayer.Length()
     duration, err := opusPlayer.TotalDuration()
     if err != nil {
-        duration = time.Duration(float64(length / 4) / float64(opusgo.OpusSampleRateHz) * float64(time.Second))
+        duration = pcmDuration(length, opusgo.OpusSampleRateHz)
     }
     opusPlayer.Seek(0, io.SeekStart)
 
@@ -939,30 +1261,55 @@ func loadOpus(audioContext *audio.Context, file fs.File, name string) (*audio.Pl
     return player, duration, func(){}, nil
 }
 
-func loadOgg(audioContext *audio.Context, file fs.File, name string) (*audio.Player, time.Duration, func(), error) {
-    allData, err := io.ReadAll(bufio.NewReader(file))
+func loadOgg(audioContext *audio.Context, source io.ReadSeeker, name string) (*audio.Player, time.Duration, func(), error) {
+    songReader, err := vorbis.DecodeWithSampleRate(audioContext.SampleRate(), source)
     if err != nil {
         return nil, 0, nil, err
     }
 
-    // this decodes on the fly, so we need all the data in memory when we pass a reader
-    songReader, err := vorbis.DecodeWithSampleRate(audioContext.SampleRate(), bytes.NewReader(allData))
+    length := pcmDuration(songReader.Length(), songReader.SampleRate())
+    // log.Printf("OGG file '%s' rate %v bytes %v length: %v", name, songReader.SampleRate(), songReader.Length(), length)
+
+    songPlayer, err := audioContext.NewPlayer(songReader)
     if err != nil {
         return nil, 0, nil, err
     }
 
-    // divide by 2 for 16-bit samples, divide by 2 for stereo
-    length := songReader.Length() / 2 / 2 / int64(songReader.SampleRate())
-    // log.Printf("OGG file '%s' rate %v bytes %v length: %v", name, songReader.SampleRate(), songReader.Length(), time.Duration(length) * time.Second)
+    // log.Printf("Loaded OGG file '%s' length %d", name, songReader.Length())
 
-    songPlayer, err := audioContext.NewPlayer(songReader)
+    return songPlayer, length, func(){}, nil
+}
+
+func loadWav(audioContext *audio.Context, source io.ReadSeeker, name string) (*audio.Player, time.Duration, func(), error) {
+    stream, err := wav.Decode(source)
     if err != nil {
         return nil, 0, nil, err
     }
 
-    // log.Printf("Loaded OGG file '%s' length %d", name, len(allData))
+    length := pcmDuration(stream.Length(), stream.SampleRate())
+
+    player, err := audioContext.NewPlayer(audio.ResampleReader(stream, stream.Length(), stream.SampleRate(), audioContext.SampleRate()))
+    if err != nil {
+        return nil, 0, nil, err
+    }
 
-    return songPlayer, time.Duration(length) * time.Second, func(){}, nil
+    return player, length, func(){}, nil
+}
+
+func loadFlac(audioContext *audio.Context, source io.ReadSeeker, name string) (*audio.Player, time.Duration, func(), error) {
+    stream, err := flac.Decode(source)
+    if err != nil {
+        return nil, 0, nil, err
+    }
+
+    length := pcmDuration(stream.Length(), stream.SampleRate())
+
+    player, err := audioContext.NewPlayer(audio.ResampleReader(stream, stream.Length(), stream.SampleRate(), audioContext.SampleRate()))
+    if err != nil {
+        return nil, 0, nil, err
+    }
+
+    return player, length, func(){}, nil
 }
 
 func MakeEngine(audioContext *audio.Context, songDirectory string, ticks int) (*Engine, error) {
@@ -971,25 +1318,39 @@ func MakeEngine(audioContext *audio.Context, songDirectory string, ticks int) (*
         return nil, fmt.Errorf("Failed to load font: %v", err)
     }
 
+    configuration := NewConfigurationManager()
+    ebiten.SetFullscreen(configuration.Settings.Video.Fullscreen)
+    ebiten.SetVsyncEnabled(configuration.Settings.Video.VSync)
+    mixer := NewMixer(configuration.LoadMixerSettings())
+
     var engine *Engine
 
     engine = &Engine{
         AudioContext: audioContext,
         Font: font,
         Ticks: ticks,
-        // GamepadIds: make(map[ebiten.GamepadID]struct{}),
+        GamepadIds: make(map[ebiten.GamepadID]string),
         Coroutine: coroutine.MakeCoroutine(func(yield coroutine.YieldFunc) error {
             if songDirectory != "" {
-                err := playSong(yield, engine, songDirectory, DefaultSongSettings(), NewInputProfile())
+                _, err := playSong(yield, engine, songDirectory, DefaultSongSettings(), engine.Input)
                 return err
             }
 
             return mainMenu(engine, yield)
         }),
-        Configuration: &ConfigurationManager{},
+        Configuration: configuration,
+        Players: configuration.LoadPlayers(),
+        Mixer: mixer,
+        SoundEffects: LoadSoundBank(audioContext, mixer, mixer.SoundSet()),
         // GuitarButtonMesh: tetra3d.NewCylinderMesh(2, 40, 50, false),
     }
 
+    engine.SetPlayer(engine.Players.CurrentPlayer())
+
+    for _, warning := range configuration.Warnings {
+        engine.ShowToast(warning)
+    }
+
     /*
     song, err := MakeSong(audioContext, songDirectory)
     if err != nil {
@@ -1010,6 +1371,10 @@ func (engine *Engine) Close() {
     */
 }
 
+// saves a picture of the screen to the working directory. the function keys up to F5 are left for
+// keyboard layouts that play with them
+const ScreenshotKey = ebiten.KeyF12
+
 func (engine *Engine) TakeScreenshot() {
     output := ebiten.NewImage(ScreenWidth, ScreenHeight)
     output.Fill(color.NRGBA{R: 0, G: 0, B: 0, A: 255})
@@ -1032,39 +1397,14 @@ func (engine *Engine) Update() error {
             case ebiten.KeyEscape, ebiten.KeyCapsLock:
                 return ebiten.Termination
                 */
-            case ebiten.KeyF1:
+            case ScreenshotKey:
                 engine.TakeScreenshot()
         }
     }
 
-    /*
-    newGamepadIDs := inpututil.AppendJustConnectedGamepadIDs(nil)
-    for _, id := range newGamepadIDs {
-        log.Printf("Gamepad connected: %v '%s'", id, ebiten.GamepadName(id))
-        engine.GamepadIds[id] = struct{}{}
-
-        if !engine.Input.HasGamepad {
-            engine.Input.HasGamepad = true
-            engine.Input.GamepadID = id
-            // works for PDP Rock Band 4 Jaguar
-            engine.Input.GamepadButtons = map[InputAction]ebiten.GamepadButton{
-                InputActionGreen: ebiten.GamepadButton3,
-                InputActionRed: ebiten.GamepadButton4,
-                InputActionYellow: ebiten.GamepadButton5,
-                InputActionBlue: ebiten.GamepadButton6,
-                InputActionOrange: ebiten.GamepadButton7,
-                InputActionStrumUp: ebiten.GamepadButton13,
-                InputActionStrumDown: ebiten.GamepadButton15,
-            }
-        }
-    }
+    engine.updateGamepads()
 
-    for id := range engine.GamepadIds {
-        if inpututil.IsGamepadJustDisconnected(id) {
-            delete(engine.GamepadIds, id)
-        }
-    }
-    */
+    engine.Input.Update()
 
     if ebiten.IsWindowBeingClosed() {
         engine.Coroutine.Stop()
@@ -1086,11 +1426,14 @@ func (engine *Engine) Update() error {
 
 type SongSettings struct {
     Difficulty string
+    Timing TimingWindows
+    AlternateStrum bool
 }
 
 func DefaultSongSettings() SongSettings {
     return SongSettings{
         Difficulty: "medium",
+        Timing: TimingWindowsForDifficulty("medium"),
     }
 }
 
@@ -1494,14 +1837,19 @@ type ParticleManager struct {
     ParticleMesh *tetra3d.Mesh
     Particles []*Particle
     Scene *tetra3d.Scene
+    // where the flames of each fret start
+    Layout HighwayLayout
+    FlameColor tetra3d.Color
 }
 
-func NewParticleManager(scene *tetra3d.Scene) *ParticleManager {
+func NewParticleManager(scene *tetra3d.Scene, layout HighwayLayout, flameColor tetra3d.Color) *ParticleManager {
     particleMesh := tetra3d.NewIcosphereMesh(1)
 
     return &ParticleManager{
         ParticleMesh: particleMesh,
         Scene: scene,
+        Layout: layout,
+        FlameColor: flameColor,
     }
 }
 
@@ -1509,13 +1857,10 @@ func (manager *ParticleManager) MakeFlame(fret int) {
 
     newParticles := rand.N(4) + 6
 
-    var color tetra3d.Color
-    color = tetra3d.NewColor(248.0/255.0, 134.0/255.0, 69.0/255.0, 1)
-
     for range newParticles {
         model := tetra3d.NewModel("Particle", manager.ParticleMesh)
-        model.Color = color
-        model.SetWorldPosition(float32((fret - 2) * 10), 0, 0)
+        model.Color = manager.FlameColor
+        model.SetWorldPosition(manager.Layout.LaneX(fret), 0, 0)
 
         manager.Scene.Root.AddChildren(model)
 
@@ -1587,8 +1932,6 @@ func loadSkin() *ebiten.Image {
         }
     }
 
-    // TODO: load user skins from "skins" directory on disk
-
     /*
     file, err := os.Open("skins/stars.jpg")
     if err != nil {
@@ -1622,107 +1965,98 @@ func lookAtVector(position tetra3d.Vector3, target tetra3d.Vector3) tetra3d.Vect
     return tetra3d.NewVector3(float32(pitch), float32(yaw), 0)
 }
 
-func playSong(yield coroutine.YieldFunc, engine *Engine, songPath string, settings SongSettings, input *InputProfile) error {
-    song, err := MakeSong(engine.AudioContext, songPath, settings.Difficulty)
+func playSong(yield coroutine.YieldFunc, engine *Engine, songPath string, settings SongSettings, input InputSource) (SongResult, error) {
+    song, err := MakeSong(engine.AudioContext, engine.Mixer, songPath, settings.Difficulty, settings.Timing)
     if err != nil {
-        return err
+        return SongResult{Path: songPath}, err
     }
 
     defer song.Close()
 
+    song.Effects = engine.SoundEffects
+    song.AlternateStrum = settings.AlternateStrum
+    song.Debug = &InputDebug{}
+
     scene := tetra3d.NewScene("Scene")
     scene.World.LightingOn = false
 
+    skin := engine.LoadSkin(engine.Player.NoteSkin)
+    if skin.Background != nil {
+        // the background is drawn behind the scene, so the scene is cleared to nothing
+        scene.World.ClearColor = tetra3d.NewColor(0, 0, 0, 0)
+    }
+
     makeMesh := func(color tetra3d.Color) *tetra3d.Mesh {
-        mesh := NewCylinderMesh(15, 4, 3)
+        mesh := NewCylinderMesh(skin.NoteStyle.Sides(), 4, 3)
         tetra3d.NewVertexSelection().SelectMeshPartByName(mesh, "CylinderTop").SetColor(1, color)
         mesh.SetActiveColorChannel(1)
         return mesh
     }
 
-    fretColor := func(fret int) tetra3d.Color {
-        switch fret {
-            case 0: return tetra3d.NewColor(1, 0, 0, 1)
-            case 1: return tetra3d.NewColor(0, 1, 0, 1)
-            case 2: return tetra3d.NewColor(1, 1, 0, 1)
-            case 3: return tetra3d.NewColor(0, 0, 1, 1)
-            case 4: return tetra3d.NewColor(1, 0.5, 0, 1)
-            default: return tetra3d.NewColor(1, 1, 1, 1)
-        }
-    }
+    layout := engine.Player.HighwayLayout(len(song.Frets))
 
-    timeToZ := func(t time.Duration) float32 {
-        return float32(t.Microseconds()) / 20000
+    var meshes []*tetra3d.Mesh
+    for i := range song.Frets {
+        meshes = append(meshes, makeMesh(skin.FretColor(song.Frets[i].InputAction)))
     }
 
-    redMesh := makeMesh(fretColor(0))
-    greenMesh := makeMesh(fretColor(1))
-    yellowMesh := makeMesh(fretColor(2))
-    blueMesh := makeMesh(fretColor(3))
-    orangeMesh := makeMesh(fretColor(4))
-
-    neckLength := 800
+    neckLength := layout.Length
 
     // neckMesh := make3dRectangle(70, 5, 300, tetra3d.NewColor(1, 1, 1, 1))
-    neckMesh := makePlane(70, neckLength, tetra3d.NewColor(1, 1, 1, 1))
+    neckMesh := makePlane(layout.NeckWidth(), neckLength, tetra3d.NewColor(1, 1, 1, 1))
     neckModel := tetra3d.NewModel("Neck", neckMesh)
     neckModel.Color = tetra3d.NewColor(1, 1, 1, 1)
-    neckModel.Move(0, -2, 50)
+    neckModel.Move(layout.Center, -2, NeckOverhang)
     scene.Root.AddChildren(neckModel)
 
-    guitarSkin := loadSkin()
-    neckMesh.MeshPartByMaterialName("Top").Material.Texture = guitarSkin
+    neckTexture := skin.Neck
+    if song.Highway != nil {
+        neckTexture = song.Highway
+    }
+    neckMesh.MeshPartByMaterialName("Top").Material.Texture = neckTexture
 
     for fretI := range song.Frets {
         fretLine := makePlane(1, neckLength, tetra3d.NewColor(0.7, 0.7, 0.7, 0.7))
         fretModel := tetra3d.NewModel("Fret", fretLine)
-        fretModel.Move(float32((fretI - 2) * 10), 1, 0)
+        // the neck is already moved to the center
+        fretModel.Move(layout.LaneX(fretI) - layout.Center, 1, 0)
         neckModel.AddChildren(fretModel)
     }
 
-    particleManager := NewParticleManager(scene)
+    particleManager := NewParticleManager(scene, layout, skin.FlameColor)
 
     makeButton := func(fret int, mesh *tetra3d.Mesh) *tetra3d.Model {
         button := tetra3d.NewModel("Button", mesh)
         button.Color = tetra3d.NewColor(1, 1, 1, 0.3)
-        xPos := (fret - 2) * 10
-        button.Move(float32(xPos), 0, 0)
+        button.Move(layout.LaneX(fret), 0, 0)
         return button
     }
 
-    redButton := makeButton(0, redMesh)
-    greenButton := makeButton(1, greenMesh)
-    yellowButton := makeButton(2, yellowMesh)
-    blueButton := makeButton(3, blueMesh)
-    orangeButton := makeButton(4, orangeMesh)
-
-    for _, button := range []*tetra3d.Model{redButton, greenButton, yellowButton, blueButton, orangeButton} {
+    var buttons []*tetra3d.Model
+    for i := range song.Frets {
+        button := makeButton(i, meshes[i])
+        buttons = append(buttons, button)
         scene.Root.AddChildren(button)
     }
 
-    lookPosition := tetra3d.NewVector3(0, 5, -60)
+    cameraSettings := engine.Configuration.Settings.Camera.Current()
+    lookPosition := cameraSettings.LookAt()
 
     camera := tetra3d.NewCamera(ScreenWidth, ScreenHeight)
     camera.PerspectiveCorrectedTextureMapping = true
-    camera.SetFar(800)
     // camera := tetra3d.NewCamera(300, 300)
-    camera.SetFieldOfView(30)
     // camera.SetLocalPosition(0, 10, 500)
-    camera.Move(0, 55, 145)
+    cameraSettings.Apply(camera, layout.Reach())
     camera.RenderDepth = true
     // camera.DepthMargin = 0.10
     // camera.RenderNormals = true
     // camera.Rotate(3.5, 0, 0, -0.8)
 
-    camera.SetLocalRotation(tetra3d.NewMatrix4LookAt(lookPosition, camera.WorldPosition(), tetra3d.NewVector3(0, 1, 0)))
-
     // camera.Node.Move(tetra3d.NewVector3(0, 0, -10))
     // camera.SetLocalRotation(tetra3d.NewMatrix4Rotate(0, 0, 0, 2))
 
     scene.Root.AddChildren(camera)
 
-    meshes := []*tetra3d.Mesh{redMesh, greenMesh, yellowMesh, blueMesh, orangeMesh}
-
     type NoteModel struct {
         Model *tetra3d.Model
         Note *Note
@@ -1737,16 +2071,15 @@ func playSong(yield coroutine.YieldFunc, engine *Engine, songPath string, settin
             model := tetra3d.NewModel("NoteRed", meshes[fretI])
             model.Color = tetra3d.NewColor(1, 1, 1, 1)
 
-            xPos := (fretI - 2) * 10
-
-            model.Move(float32(xPos), 0, float32(-note.Start.Milliseconds() / 50))
+            model.Move(layout.LaneX(fretI), 0, layout.TimeToZ(-note.Start))
+            model.Color.A = layout.NoteAlpha(layout.TimeToZ(note.Start))
             scene.Root.AddChildren(model)
 
             noteModel := NoteModel{Model: model, Note: note}
 
             if note.HasSustain() {
                 // sustainMesh := make3dRectangle(4, 0.1, timeToZ(note.End - note.Start), fretColor(fretI))
-                sustainMesh := makePlane(3, int(timeToZ(note.End - note.Start)), fretColor(fretI))
+                sustainMesh := makePlane(3, int(layout.TimeToZ(note.End - note.Start)), skin.FretColor(fret.InputAction))
                 sustainModel := tetra3d.NewModel("Sustain", sustainMesh)
                 sustainModel.Color = tetra3d.NewColor(1, 1, 1, 1)
                 sustainModel.Move(0, 2, 0)
@@ -1760,14 +2093,29 @@ func playSong(yield coroutine.YieldFunc, engine *Engine, songPath string, settin
     }
 
     engine.PushDrawer(func(screen *ebiten.Image) {
-        engine.DrawSong3d(screen, song, scene, camera)
+        engine.DrawSong3d(screen, song, scene, camera, skin.Background)
         // drawSong(screen, song, engine.Font)
+        if engine.ShowInputDebug {
+            engine.drawInputDebug(screen, song, input)
+        }
     })
     defer engine.PopDrawer()
 
     var counter uint64
 
-    song.Update(input, particleManager)
+    var inputQueue InputQueue
+
+    // only a controller unplugged during this song matters
+    engine.InputLost = false
+
+    // midi notes go straight into the queue as they arrive
+    if engine.Midi != nil {
+        engine.Midi.Attach(&inputQueue)
+        defer engine.Midi.Detach()
+    }
+
+    inputQueue.Poll(input, time.Now())
+    song.Update(inputQueue.Drain(), particleManager)
     for !song.Finished() {
         counter += 1
 
@@ -1782,15 +2130,36 @@ func playSong(yield coroutine.YieldFunc, engine *Engine, songPath string, settin
             switch key {
                 case ebiten.KeyEscape, ebiten.KeyCapsLock:
                     yield()
-                    return nil
+                    return song.MakeResult(songPath, false), nil
+                case InputDebugKey:
+                    engine.ShowInputDebug = !engine.ShowInputDebug
+                case SaveCameraKey:
+                    if engine.Configuration.Settings.Debug {
+                        engine.Configuration.Update(func(settings *Settings) {
+                            settings.Camera.Preset = CustomCameraName
+                            settings.Camera.Custom = CameraSettingsFrom(camera, lookPosition)
+                        })
+                        engine.ShowToast("Saved the camera as the custom camera")
+                    }
             }
         }
 
-        // these keys are mainly for debugging
+        if engine.InputLost {
+            engine.InputLost = false
+            if !waitForController(yield, engine, song) {
+                return song.MakeResult(songPath, false), nil
+            }
+            inputQueue.Reset()
+        }
+
+        // the free camera keys share keys with the controls, so they only work in debug mode
         moved := false
         var move tetra3d.Vector3
 
-        keys = inpututil.AppendPressedKeys(nil)
+        keys = nil
+        if engine.Configuration.Settings.Debug {
+            keys = inpututil.AppendPressedKeys(nil)
+        }
         for _, key := range keys {
             switch key {
                 case ebiten.KeyQ:
@@ -1813,11 +2182,11 @@ func playSong(yield coroutine.YieldFunc, engine *Engine, songPath string, settin
                     moved = true
 
                 case ebiten.KeyE:
-                    if camera.FieldOfView() < 179 {
+                    if camera.FieldOfView() < MaxCameraFieldOfView {
                         camera.SetFieldOfView(camera.FieldOfView() + 1)
                     }
                 case ebiten.KeyR:
-                    if camera.FieldOfView() > 1 {
+                    if camera.FieldOfView() > MinCameraFieldOfView {
                         camera.SetFieldOfView(camera.FieldOfView() - 1)
                     }
             }
@@ -1828,9 +2197,13 @@ func playSong(yield coroutine.YieldFunc, engine *Engine, songPath string, settin
             camera.SetLocalRotation(tetra3d.NewMatrix4LookAt(lookPosition, camera.WorldPosition(), tetra3d.NewVector3(0, 1, 0)))
         }
 
-        delta := time.Since(song.StartTime)
+        // notes are drawn ahead by however late the screen is
+        delta := time.Since(song.StartTime) + engine.Player.VideoOffset
 
-        song.Update(input, particleManager)
+        inputQueue.Poll(input, time.Now())
+        song.Whammy = input.Whammy()
+        song.FretStrum = input.FretStrum()
+        song.Update(engine.Player.adjustInput(inputQueue.Drain()), particleManager)
 
         // log.Printf("Notes: %v", len(notes))
         if counter % 2 == 0 {
@@ -1846,7 +2219,13 @@ func playSong(yield coroutine.YieldFunc, engine *Engine, songPath string, settin
 
                         z := min(1, max(0, float32(noteModel.Note.End - delta) / float32(noteModel.Note.End - noteModel.Note.Start)))
 
-                        noteModel.SustainModel.SetLocalScale(1, 1, z)
+                        // the whammy bar bends the sustain, making it wobble
+                        width := float32(1)
+                        if noteModel.Note.Sustain && song.Whammy >= WhammyThreshold {
+                            width += float32(song.Whammy * 1.5 * math.Abs(math.Sin(float64(counter) / 3)))
+                        }
+
+                        noteModel.SustainModel.SetLocalScale(width, 1, z)
                         position := noteModel.Model.WorldPosition()
                         noteModel.Model.SetWorldPosition(position.X, position.Y, 0)
                     } else {
@@ -1856,14 +2235,8 @@ func playSong(yield coroutine.YieldFunc, engine *Engine, songPath string, settin
                         x := position.X
                         y := position.Y
 
-                        alpha := float32(1.0)
-                        if elapsed > 0 {
-                            alpha = min(1, float32(time.Second * 6) / float32(elapsed))
-                        }
-
-                        noteModel.Model.Color.A = alpha
-
-                        noteModel.Model.SetWorldPosition(x, y, timeToZ(-elapsed))
+                        noteModel.Model.Color.A = layout.NoteAlpha(layout.TimeToZ(elapsed))
+                        noteModel.Model.SetWorldPosition(x, y, layout.TimeToZ(-elapsed))
                     }
 
                     notesOut = append(notesOut, noteModel)
@@ -1882,14 +2255,7 @@ func playSong(yield coroutine.YieldFunc, engine *Engine, songPath string, settin
 
             for i := range song.Frets {
                 fret := &song.Frets[i]
-                var button *tetra3d.Model
-                switch i {
-                    case 0: button = redButton
-                    case 1: button = greenButton
-                    case 2: button = yellowButton
-                    case 3: button = blueButton
-                    case 4: button = orangeButton
-                }
+                button := buttons[i]
                 if !fret.Press.IsZero() {
                     button.Color.A = min(1, button.Color.A + 0.06)
                     button.SetLocalScale(1, 1, 1)
@@ -1907,13 +2273,13 @@ func playSong(yield coroutine.YieldFunc, engine *Engine, songPath string, settin
 
     log.Printf("Song finished! Notes hit: %d, Notes missed: %d", song.NotesHit, song.NotesMissed)
 
-    return nil
+    return song.MakeResult(songPath, song.Finished()), nil
 }
 
-// true if the directory contains song.ogg, guitar.ogg, and notes.mid
+// true if the directory contains notes.mid along with at least one audio stem. songs don't need a
+// separate guitar stem, in which case the guitar is mixed into the song stem
 func isSongDirectory(path string) bool {
-    hasSong := false
-    hasGuitar := false
+    hasAudio := false
     hasNotes := false
 
     entries, err := os.ReadDir(path)
@@ -1928,13 +2294,18 @@ func isSongDirectory(path string) bool {
 
         name := strings.ToLower(entry.Name())
         switch name {
-            case "song.ogg", "song.mp3", "song.opus": hasSong = true
-            case "guitar.ogg", "guitar.mp3", "guitar.opus": hasGuitar = true
             case "notes.mid": hasNotes = true
+            default:
+                if isAudioFile(name) {
+                    stem := ParseStem(name)
+                    if stem != StemUnknown && stem != StemPreview {
+                        hasAudio = true
+                    }
+                }
         }
     }
 
-    return hasSong && hasGuitar && hasNotes
+    return hasAudio && hasNotes
 }
 
 func scanSongs(where string, depth int) []string {
@@ -2052,6 +2423,8 @@ func (engine *Engine) Draw(screen *ebiten.Image) {
         drawer := engine.Drawers[len(engine.Drawers)-1]
         drawer(screen)
     }
+
+    engine.drawToasts(screen)
 }
 
 func brightenColor(c color.NRGBA, amount float64) color.Color {
@@ -2088,7 +2461,22 @@ func darkenColor(c color.NRGBA, amount float64) color.Color {
     return c
 }
 
-func (engine *Engine) DrawSong3d(screen *ebiten.Image, song *Song, scene *tetra3d.Scene, camera *tetra3d.Camera) {
+// minutes:seconds, with both the elapsed time and the song length truncated the same way
+func formatSongTime(duration time.Duration) string {
+    seconds := int(duration / time.Second)
+    return fmt.Sprintf("%d:%02d", seconds / 60, seconds % 60)
+}
+
+func (engine *Engine) DrawSong3d(screen *ebiten.Image, song *Song, scene *tetra3d.Scene, camera *tetra3d.Camera, background *ebiten.Image) {
+
+    if background != nil {
+        // stretched to fill the screen
+        var options ebiten.DrawImageOptions
+        bounds := background.Bounds()
+        options.GeoM.Scale(float64(ScreenWidth) / float64(bounds.Dx()), float64(ScreenHeight) / float64(bounds.Dy()))
+        options.Filter = ebiten.FilterLinear
+        screen.DrawImage(background, &options)
+    }
 
     camera.Clear()
     camera.RenderScene(scene)
@@ -2119,7 +2507,7 @@ func (engine *Engine) DrawSong3d(screen *ebiten.Image, song *Song, scene *tetra3
 
     var textOptions text.DrawOptions
     textOptions.GeoM.Translate(ScreenWidth - 250, 10)
-    text.Draw(screen, fmt.Sprintf("Time: %v / %v", delta.Truncate(time.Second), song.SongLength.Truncate(time.Second)), face, &textOptions)
+    text.Draw(screen, fmt.Sprintf("Time: %v / %v", formatSongTime(delta), formatSongTime(song.SongLength)), face, &textOptions)
     textOptions.GeoM.Translate(0, 30)
     text.Draw(screen, fmt.Sprintf("Notes Hit: %d", song.NotesHit), face, &textOptions)
     textOptions.GeoM.Translate(0, 30)
@@ -2132,14 +2520,24 @@ func (engine *Engine) DrawSong3d(screen *ebiten.Image, song *Song, scene *tetra3
     text.Draw(screen, fmt.Sprintf("Notes: %d%%", percent), face, &textOptions)
     textOptions.GeoM.Translate(0, 30)
     text.Draw(screen, fmt.Sprintf("Score: %d", song.Score), face, &textOptions)
+    textOptions.GeoM.Translate(0, 30)
+    text.Draw(screen, fmt.Sprintf("Streak: %d", song.Streak), face, &textOptions)
+    textOptions.GeoM.Translate(0, 30)
+    if song.StarPowerActive {
+        textOptions.ColorScale.ScaleWithColor(color.NRGBA{R: 120, G: 220, B: 255, A: 255})
+    }
+    text.Draw(screen, fmt.Sprintf("Star Power: %d%%", int(song.StarPower * 100)), face, &textOptions)
+    textOptions.ColorScale.Reset()
 
     textOptions.GeoM.Reset()
     textOptions.GeoM.Translate(10, 10)
     text.Draw(screen, fmt.Sprintf("FPS: %0.2f", ebiten.ActualFPS()), face, &textOptions)
 
-    textOptions.GeoM.Translate(0, 20)
-    position := camera.WorldPosition()
-    text.Draw(screen, fmt.Sprintf("Camera: X: %v Y: %v Z: %v Fov: %v", position.X, position.Y, position.Z, camera.FieldOfView()), face, &textOptions)
+    if engine.Configuration.Settings.Debug {
+        textOptions.GeoM.Translate(0, 20)
+        position := camera.WorldPosition()
+        text.Draw(screen, fmt.Sprintf("Camera: X: %v Y: %v Z: %v Fov: %v", position.X, position.Y, position.Z, camera.FieldOfView()), face, &textOptions)
+    }
 
     if song.LyricBatch < len(song.LyricBatches) {
         batch := song.LyricBatches[song.LyricBatch]
@@ -2187,6 +2585,8 @@ func (engine *Engine) DrawSong3d(screen *ebiten.Image, song *Song, scene *tetra3
         }
     }
 
+    engine.drawJudgements(screen, song)
+
     if delta < time.Second * 2 && song.SongInfo.Name != "" {
         textOptions.GeoM.Translate(0, 20)
         face = &text.GoTextFace{
@@ -2214,6 +2614,41 @@ func (engine *Engine) DrawSong3d(screen *ebiten.Image, song *Song, scene *tetra3
 
 }
 
+// the judgements of recent hits float up above the highway and fade out
+func (engine *Engine) drawJudgements(screen *ebiten.Image, song *Song) {
+    face := &text.GoTextFace{
+        Source: engine.Font,
+        Size: 36,
+    }
+
+    smallFace := &text.GoTextFace{
+        Source: engine.Font,
+        Size: 20,
+    }
+
+    for _, popup := range song.Judgements {
+        age := time.Since(popup.Time)
+        progress := min(1, float64(age) / float64(JudgementDisplayTime))
+
+        var options text.DrawOptions
+        options.LayoutOptions.PrimaryAlign = text.AlignCenter
+        options.GeoM.Translate(ScreenWidth / 2, ScreenHeight - 420 - progress * 60)
+        options.ColorScale.ScaleWithColor(popup.Judgement.Color())
+        options.ColorScale.ScaleAlpha(float32(1 - progress))
+        text.Draw(screen, popup.Judgement.String(), face, &options)
+
+        if popup.ShowEarlyLate() {
+            hint := "Late"
+            if popup.Offset < 0 {
+                hint = "Early"
+            }
+
+            options.GeoM.Translate(0, 42)
+            text.Draw(screen, fmt.Sprintf("%v %dms", hint, absDuration(popup.Offset).Milliseconds()), smallFace, &options)
+        }
+    }
+}
+
 func (engine *Engine) Layout(outsideWidth, outsideHeight int) (int, int) {
     return ScreenWidth, ScreenHeight
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+    "slices"
+
+    "github.com/hajimehoshi/ebiten/v2"
+    "github.com/hajimehoshi/ebiten/v2/inpututil"
+
+    "github.com/ebitenui/ebitenui"
+    "github.com/ebitenui/ebitenui/widget"
+)
+
+// what a gamepad can do in a menu. the keyboard is handled by each menu directly
+type MenuAction int
+const (
+    MenuActionUp MenuAction = iota
+    MenuActionDown
+    MenuActionLeft
+    MenuActionRight
+    MenuActionConfirm
+    MenuActionBack
+    MenuActionPageUp
+    MenuActionPageDown
+)
+
+// the controls of the guitar being played, so the menus work like on a console
+var menuProfileActions = map[InputAction]MenuAction{
+    InputActionStrumUp: MenuActionUp,
+    InputActionStrumDown: MenuActionDown,
+    InputActionGreen: MenuActionConfirm,
+    InputActionRed: MenuActionBack,
+    InputActionBlue: MenuActionPageUp,
+    InputActionOrange: MenuActionPageDown,
+}
+
+// any gamepad with a standard layout, whether or not it has been set up
+var menuStandardButtons = map[ebiten.StandardGamepadButton]MenuAction{
+    ebiten.StandardGamepadButtonLeftTop: MenuActionUp,
+    ebiten.StandardGamepadButtonLeftBottom: MenuActionDown,
+    ebiten.StandardGamepadButtonLeftLeft: MenuActionLeft,
+    ebiten.StandardGamepadButtonLeftRight: MenuActionRight,
+    ebiten.StandardGamepadButtonRightBottom: MenuActionConfirm,
+    ebiten.StandardGamepadButtonRightRight: MenuActionBack,
+    ebiten.StandardGamepadButtonFrontTopLeft: MenuActionPageUp,
+    ebiten.StandardGamepadButtonFrontTopRight: MenuActionPageDown,
+}
+
+// the menu actions pressed on any gamepad this tick. an action is only reported once even if
+// several buttons map to it, such as a strum bar that is also the standard d-pad
+func (engine *Engine) MenuActions() []MenuAction {
+    var actions []MenuAction
+    add := func(action MenuAction) {
+        if !slices.Contains(actions, action) {
+            actions = append(actions, action)
+        }
+    }
+
+    // keyboard bindings are left out since the menus already handle the keyboard
+    if engine.Input != nil && engine.Input.CurrentProfile == UseProfileGamepad {
+        for inputAction, menuAction := range menuProfileActions {
+            if engine.Input.IsJustPressed(inputAction) {
+                add(menuAction)
+            }
+        }
+    }
+
+    for id := range engine.GamepadIds {
+        if !ebiten.IsStandardGamepadLayoutAvailable(id) {
+            continue
+        }
+
+        for button, menuAction := range menuStandardButtons {
+            if inpututil.IsStandardGamepadButtonJustPressed(id, button) {
+                add(menuAction)
+            }
+        }
+    }
+
+    slices.Sort(actions)
+    return actions
+}
+
+// move the focus of an ebitenui screen and press its buttons with a gamepad. returns true if the
+// player asked to leave the screen
+func (engine *Engine) HandleMenuInput(ui *ebitenui.UI) bool {
+    back := false
+
+    for _, action := range engine.MenuActions() {
+        switch action {
+            case MenuActionUp: ui.ChangeFocus(widget.FOCUS_PREVIOUS)
+            case MenuActionDown: ui.ChangeFocus(widget.FOCUS_NEXT)
+            case MenuActionLeft: ui.ChangeFocus(widget.FOCUS_WEST)
+            case MenuActionRight: ui.ChangeFocus(widget.FOCUS_EAST)
+            case MenuActionConfirm:
+                button, ok := ui.GetFocusedWidget().(*widget.Button)
+                if ok {
+                    button.Click()
+                }
+            case MenuActionBack:
+                back = true
+        }
+    }
+
+    return back
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "sync"
+    "time"
+    "slices"
+
+    "gitlab.com/gomidi/midi/v2"
+    "gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// the most latency compensation that can be set for a midi device
+const MaxMidiLatency = 200 * time.Millisecond
+
+// matches a note on any channel
+const MidiAnyChannel = -1
+
+// the channel drum kits send on, which is channel 10 counting from 1
+const MidiDrumChannel = 9
+
+// a midi note that plays an action
+type MidiBinding struct {
+    // MidiAnyChannel for notes on every channel
+    Channel int `json:"channel"`
+    Key uint8 `json:"key"`
+    Action InputAction `json:"action"`
+}
+
+func (binding MidiBinding) Matches(channel uint8, key uint8) bool {
+    return binding.Key == key && (binding.Channel == MidiAnyChannel || binding.Channel == int(channel))
+}
+
+func (binding MidiBinding) String() string {
+    if binding.Channel == MidiAnyChannel {
+        return fmt.Sprintf("Note %v (%v)", binding.Key, midi.Note(binding.Key))
+    }
+    return fmt.Sprintf("Note %v (%v) Ch %v", binding.Key, midi.Note(binding.Key), binding.Channel + 1)
+}
+
+// how a player's midi device is set up
+type MidiProfile struct {
+    // the name of the input port, empty if midi isn't used
+    Port string `json:"port"`
+    Bindings []MidiBinding `json:"bindings"`
+    // how late the device delivers notes, which is taken off the time of every note
+    LatencyMs int `json:"latency_ms"`
+    // hitting a pad plays its note without strumming
+    FretStrum bool `json:"fret_strum"`
+}
+
+// general midi drum notes, with each pad playing a fret like a drum chart
+func DefaultMidiProfile() MidiProfile {
+    return MidiProfile{
+        Bindings: []MidiBinding{
+            MidiBinding{Channel: MidiAnyChannel, Key: 36, Action: InputActionGreen}, // kick
+            MidiBinding{Channel: MidiAnyChannel, Key: 38, Action: InputActionRed}, // snare
+            MidiBinding{Channel: MidiAnyChannel, Key: 42, Action: InputActionYellow}, // closed hi-hat
+            MidiBinding{Channel: MidiAnyChannel, Key: 48, Action: InputActionBlue}, // high tom
+            MidiBinding{Channel: MidiAnyChannel, Key: 49, Action: InputActionOrange}, // crash
+            MidiBinding{Channel: MidiAnyChannel, Key: 51, Action: InputActionTilt}, // ride
+        },
+        FretStrum: true,
+    }
+}
+
+func (profile *MidiProfile) Latency() time.Duration {
+    return time.Duration(profile.LatencyMs) * time.Millisecond
+}
+
+// the action played by a note, or false if the note isn't bound
+func (profile *MidiProfile) Action(channel uint8, key uint8) (InputAction, bool) {
+    for _, binding := range profile.Bindings {
+        if binding.Matches(channel, key) {
+            return binding.Action, true
+        }
+    }
+
+    return InputActionNone, false
+}
+
+// the binding for an action, or false if no note plays it
+func (profile *MidiProfile) Binding(action InputAction) (MidiBinding, bool) {
+    index := slices.IndexFunc(profile.Bindings, func(binding MidiBinding) bool {
+        return binding.Action == action
+    })
+
+    if index == -1 {
+        return MidiBinding{}, false
+    }
+
+    return profile.Bindings[index], true
+}
+
+// make the note play the action, replacing whatever the action or the note was bound to before
+func (profile *MidiProfile) Bind(action InputAction, channel int, key uint8) {
+    profile.Bindings = slices.DeleteFunc(profile.Bindings, func(binding MidiBinding) bool {
+        overlaps := binding.Channel == channel || binding.Channel == MidiAnyChannel || channel == MidiAnyChannel
+        return binding.Action == action || (binding.Key == key && overlaps)
+    })
+
+    profile.Bindings = append(profile.Bindings, MidiBinding{Channel: channel, Key: key, Action: action})
+}
+
+func (profile MidiProfile) Clone() MidiProfile {
+    profile.Bindings = slices.Clone(profile.Bindings)
+    return profile
+}
+
+// the names of the midi input ports that can be opened
+func MidiPorts() []string {
+    ports, err := drivers.Ins()
+    if err != nil {
+        return nil
+    }
+
+    var names []string
+    for _, port := range ports {
+        names = append(names, port.String())
+    }
+
+    return names
+}
+
+// listens to a midi input port and turns notes into input events. midi drivers deliver
+// messages on their own goroutine as soon as they arrive, so events are pushed straight into the
+// queue of the song being played with the time they were received
+type MidiInput struct {
+    lock sync.Mutex
+    port drivers.In
+    stop func()
+
+    profile MidiProfile
+    // the song's queue, or nil when no song is playing
+    queue *InputQueue
+    // when set, the next note is handed to this instead of being played
+    capture func(channel uint8, key uint8)
+
+    // the time source, replaced by tests
+    now func() time.Time
+}
+
+func OpenMidiInput(port drivers.In, profile MidiProfile) (*MidiInput, error) {
+    input := &MidiInput{
+        port: port,
+        profile: profile.Clone(),
+        now: time.Now,
+    }
+
+    stop, err := midi.ListenTo(port, input.receive, midi.HandleError(func(err error) {
+        log.Printf("Midi error on %v: %v", port, err)
+    }))
+    if err != nil {
+        return nil, fmt.Errorf("Unable to listen to midi port %v: %v", port, err)
+    }
+
+    input.stop = stop
+    return input, nil
+}
+
+func (input *MidiInput) receive(message midi.Message, timestamp int32) {
+    var channel, key, velocity uint8
+    var pressed bool
+    switch {
+        case message.GetNoteStart(&channel, &key, &velocity):
+            pressed = true
+        case message.GetNoteEnd(&channel, &key):
+            pressed = false
+        default:
+            return
+    }
+
+    input.lock.Lock()
+    defer input.lock.Unlock()
+
+    if input.capture != nil {
+        if pressed {
+            input.capture(channel, key)
+            input.capture = nil
+        }
+        return
+    }
+
+    action, ok := input.profile.Action(channel, key)
+    if !ok || input.queue == nil {
+        return
+    }
+
+    input.queue.Push(InputEvent{
+        Action: action,
+        Pressed: pressed,
+        Time: input.now().Add(-input.profile.Latency()),
+        Tap: pressed && input.profile.FretStrum,
+    })
+}
+
+func (input *MidiInput) Name() string {
+    return input.port.String()
+}
+
+// use new bindings
+func (input *MidiInput) SetProfile(profile MidiProfile) {
+    input.lock.Lock()
+    defer input.lock.Unlock()
+    input.profile = profile.Clone()
+}
+
+// start sending notes to a song's queue
+func (input *MidiInput) Attach(queue *InputQueue) {
+    input.lock.Lock()
+    defer input.lock.Unlock()
+    input.queue = queue
+}
+
+func (input *MidiInput) Detach() {
+    input.Attach(nil)
+}
+
+// hand the next note to 'capture' rather than playing it. capture is called from the driver's
+// goroutine. passing nil stops waiting
+func (input *MidiInput) Capture(capture func(channel uint8, key uint8)) {
+    input.lock.Lock()
+    defer input.lock.Unlock()
+    input.capture = capture
+}
+
+func (input *MidiInput) Close() {
+    if input.stop != nil {
+        input.stop()
+        input.stop = nil
+    }
+
+    err := input.port.Close()
+    if err != nil {
+        log.Printf("Unable to close midi port %v: %v", input.port, err)
+    }
+}
+
+// open the current player's midi device, closing the one that was open
+func (engine *Engine) OpenMidi() {
+    if engine.Midi != nil {
+        engine.Midi.Close()
+        engine.Midi = nil
+    }
+
+    profile := engine.Input.Midi
+    if profile.Port == "" {
+        return
+    }
+
+    port, err := drivers.InByName(profile.Port)
+    if err == nil {
+        engine.Midi, err = OpenMidiInput(port, profile)
+    }
+
+    if err != nil {
+        engine.ShowToast(fmt.Sprintf("Unable to open midi device %v", profile.Port))
+        log.Printf("Unable to open midi device %v: %v", profile.Port, err)
+    }
+}
@@ -0,0 +1,8 @@
+//go:build cgo && !js
+
+package main
+
+// the midi driver needs cgo, so builds without it have no midi devices
+import (
+    _ "gitlab.com/gomidi/midi/v2/drivers/rtmididrv"
+)
@@ -0,0 +1,148 @@
+package main
+
+import (
+    "time"
+    "testing"
+
+    "gitlab.com/gomidi/midi/v2"
+    "gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// an in process midi port that delivers whatever is sent to it
+type fakeMidiPort struct {
+    open bool
+    listener func(message []byte, milliseconds int32)
+}
+
+func (port *fakeMidiPort) Open() error {
+    port.open = true
+    return nil
+}
+
+func (port *fakeMidiPort) Close() error {
+    port.open = false
+    return nil
+}
+
+func (port *fakeMidiPort) IsOpen() bool {
+    return port.open
+}
+
+func (port *fakeMidiPort) Number() int {
+    return 0
+}
+
+func (port *fakeMidiPort) String() string {
+    return "fake drums"
+}
+
+func (port *fakeMidiPort) Underlying() interface{} {
+    return nil
+}
+
+func (port *fakeMidiPort) Listen(onMessage func(message []byte, milliseconds int32), config drivers.ListenConfig) (func(), error) {
+    port.listener = onMessage
+    return func(){
+        port.listener = nil
+    }, nil
+}
+
+func (port *fakeMidiPort) Send(message midi.Message) {
+    if port.listener != nil {
+        port.listener(message, 0)
+    }
+}
+
+func TestMidiInput(testing *testing.T) {
+    port := &fakeMidiPort{}
+    profile := DefaultMidiProfile()
+    profile.LatencyMs = 20
+
+    input, err := OpenMidiInput(port, profile)
+    if err != nil {
+        testing.Fatalf("Unable to open midi input: %v", err)
+    }
+    defer input.Close()
+
+    now := time.Now()
+    input.now = func() time.Time {
+        return now
+    }
+
+    // nothing is queued until a song is playing
+    port.Send(midi.NoteOn(MidiDrumChannel, 38, 100))
+
+    var queue InputQueue
+    input.Attach(&queue)
+
+    port.Send(midi.NoteOn(MidiDrumChannel, 38, 100))
+    // a note on with no velocity is a release
+    port.Send(midi.NoteOn(MidiDrumChannel, 38, 0))
+    // not bound to anything
+    port.Send(midi.NoteOn(MidiDrumChannel, 60, 100))
+    port.Send(midi.ControlChange(MidiDrumChannel, 7, 100))
+
+    events := queue.Drain()
+    if len(events) != 2 {
+        testing.Fatalf("expected a press and a release but got %v", events)
+    }
+
+    if events[0].Action != InputActionRed || !events[0].Pressed || events[1].Pressed {
+        testing.Errorf("the snare should press and release red, got %v", events)
+    }
+
+    // pads play their notes without a strum
+    if !events[0].Tap || events[1].Tap {
+        testing.Errorf("only the press should be a tap, got %v", events)
+    }
+
+    if !events[0].Time.Equal(now.Add(-20 * time.Millisecond)) {
+        testing.Errorf("the latency should be taken off the note time, was %v", now.Sub(events[0].Time))
+    }
+
+    // binding takes the next note instead of playing it
+    var boundKey uint8
+    input.Capture(func(channel uint8, key uint8) {
+        boundKey = key
+    })
+    port.Send(midi.NoteOn(MidiDrumChannel, 45, 100))
+    port.Send(midi.NoteOn(MidiDrumChannel, 36, 100))
+
+    events = queue.Drain()
+    if boundKey != 45 || len(events) != 1 || events[0].Action != InputActionGreen {
+        testing.Errorf("the captured note should not be played, captured %v and got %v", boundKey, events)
+    }
+
+    input.Detach()
+    port.Send(midi.NoteOn(MidiDrumChannel, 36, 100))
+    if len(queue.Drain()) != 0 {
+        testing.Errorf("notes should not be queued after the song is detached")
+    }
+}
+
+func TestMidiBind(testing *testing.T) {
+    profile := DefaultMidiProfile()
+    profile.Bind(InputActionRed, MidiDrumChannel, 36)
+
+    action, ok := profile.Action(MidiDrumChannel, 36)
+    if !ok || action != InputActionRed {
+        testing.Errorf("the kick should play red but played %v", action)
+    }
+
+    // the snare lost its action and green lost its note
+    _, ok = profile.Action(MidiDrumChannel, 38)
+    if ok {
+        testing.Errorf("the old red note should be unbound")
+    }
+
+    _, ok = profile.Binding(InputActionGreen)
+    if ok {
+        testing.Errorf("green should be unbound once its note is taken")
+    }
+
+    // bound to one channel only
+    _, ok = profile.Action(0, 36)
+    if ok {
+        testing.Errorf("a note on another channel should not play")
+    }
+}
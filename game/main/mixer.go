@@ -0,0 +1,238 @@
+package main
+
+import (
+    "io"
+    "sync"
+    "slices"
+    "encoding/json"
+
+    "github.com/hajimehoshi/ebiten/v2/audio"
+)
+
+// which volume slider controls a player
+type MixerChannel int
+const (
+    // backing stems that the player isn't playing
+    MixerChannelMusic MixerChannel = iota
+    // the stem of the instrument being played
+    MixerChannelInstrument
+    MixerChannelPreview
+    MixerChannelEffects
+)
+
+func (channel MixerChannel) String() string {
+    switch channel {
+        case MixerChannelMusic: return "Music"
+        case MixerChannelInstrument: return "Instrument"
+        case MixerChannelPreview: return "Preview"
+        case MixerChannelEffects: return "Sound Effects"
+    }
+
+    return "Unknown"
+}
+
+var MixerChannels = []MixerChannel{MixerChannelMusic, MixerChannelInstrument, MixerChannelPreview, MixerChannelEffects}
+
+// all volumes are in the range 0-1
+type MixerSettings struct {
+    Master float64 `json:"master"`
+    Music float64 `json:"music"`
+    Instrument float64 `json:"instrument"`
+    Preview float64 `json:"preview"`
+    Effects float64 `json:"effects"`
+    // which of the sound effect sets to use
+    SoundSet string `json:"sound_set"`
+}
+
+func DefaultMixerSettings() MixerSettings {
+    return MixerSettings{
+        Master: 1,
+        Music: 1,
+        Instrument: 1,
+        Preview: 0.8,
+        Effects: 0.8,
+        SoundSet: DefaultSoundSet,
+    }
+}
+
+func (settings *MixerSettings) channelVolume(channel MixerChannel) *float64 {
+    switch channel {
+        case MixerChannelMusic: return &settings.Music
+        case MixerChannelInstrument: return &settings.Instrument
+        case MixerChannelPreview: return &settings.Preview
+        case MixerChannelEffects: return &settings.Effects
+    }
+
+    return nil
+}
+
+func (settings *MixerSettings) Serialize(out io.Writer) error {
+    encoder := json.NewEncoder(out)
+    encoder.SetIndent("", "  ")
+    return encoder.Encode(settings)
+}
+
+func LoadMixerSettings(in io.Reader) (MixerSettings, error) {
+    settings := DefaultMixerSettings()
+    decoder := json.NewDecoder(in)
+    err := decoder.Decode(&settings)
+    if err != nil {
+        return DefaultMixerSettings(), err
+    }
+
+    settings.clamp()
+
+    return settings, nil
+}
+
+// fix up values that are out of range, such as from a file edited by hand
+func (settings *MixerSettings) clamp() {
+    if settings.SoundSet == "" {
+        settings.SoundSet = DefaultSoundSet
+    }
+
+    settings.Master = clampVolume(settings.Master)
+    for _, channel := range MixerChannels {
+        volume := settings.channelVolume(channel)
+        *volume = clampVolume(*volume)
+    }
+}
+
+func clampVolume(volume float64) float64 {
+    return max(0, min(1, volume))
+}
+
+// the volume of every player is master * channel * the player's own gain, so changing a setting
+// immediately applies to everything that is playing
+type Mixer struct {
+    lock sync.Mutex
+    settings MixerSettings
+    tracks []*MixerTrack
+}
+
+type MixerTrack struct {
+    mixer *Mixer
+    channel MixerChannel
+    player *audio.Player
+    // set by the game for things like ducking a missed instrument or fading a preview
+    gain float64
+}
+
+func NewMixer(settings MixerSettings) *Mixer {
+    return &Mixer{
+        settings: settings,
+    }
+}
+
+func (mixer *Mixer) Settings() MixerSettings {
+    mixer.lock.Lock()
+    defer mixer.lock.Unlock()
+    return mixer.settings
+}
+
+func (mixer *Mixer) MasterVolume() float64 {
+    mixer.lock.Lock()
+    defer mixer.lock.Unlock()
+    return mixer.settings.Master
+}
+
+func (mixer *Mixer) SetMasterVolume(volume float64) {
+    mixer.lock.Lock()
+    defer mixer.lock.Unlock()
+    mixer.settings.Master = clampVolume(volume)
+    mixer.applyAll()
+}
+
+func (mixer *Mixer) SoundSet() string {
+    mixer.lock.Lock()
+    defer mixer.lock.Unlock()
+    return mixer.settings.SoundSet
+}
+
+func (mixer *Mixer) SetSoundSet(set string) {
+    mixer.lock.Lock()
+    defer mixer.lock.Unlock()
+    mixer.settings.SoundSet = set
+}
+
+func (mixer *Mixer) Volume(channel MixerChannel) float64 {
+    mixer.lock.Lock()
+    defer mixer.lock.Unlock()
+
+    volume := mixer.settings.channelVolume(channel)
+    if volume == nil {
+        return 0
+    }
+    return *volume
+}
+
+func (mixer *Mixer) SetVolume(channel MixerChannel, value float64) {
+    mixer.lock.Lock()
+    defer mixer.lock.Unlock()
+
+    volume := mixer.settings.channelVolume(channel)
+    if volume != nil {
+        *volume = clampVolume(value)
+        mixer.applyAll()
+    }
+}
+
+// must hold the lock
+func (mixer *Mixer) applyAll() {
+    for _, track := range mixer.tracks {
+        mixer.apply(track)
+    }
+}
+
+// must hold the lock
+func (mixer *Mixer) apply(track *MixerTrack) {
+    volume := mixer.settings.Master * track.gain
+    channel := mixer.settings.channelVolume(track.channel)
+    if channel != nil {
+        volume *= *channel
+    }
+    track.player.SetVolume(volume)
+}
+
+// route the player through the mixer. the track must be removed when the player is closed
+func (mixer *Mixer) Add(player *audio.Player, channel MixerChannel) *MixerTrack {
+    mixer.lock.Lock()
+    defer mixer.lock.Unlock()
+
+    track := &MixerTrack{
+        mixer: mixer,
+        channel: channel,
+        player: player,
+        gain: 1,
+    }
+
+    mixer.tracks = append(mixer.tracks, track)
+    mixer.apply(track)
+
+    return track
+}
+
+func (mixer *Mixer) Remove(track *MixerTrack) {
+    mixer.lock.Lock()
+    defer mixer.lock.Unlock()
+
+    mixer.tracks = slices.DeleteFunc(mixer.tracks, func(other *MixerTrack) bool {
+        return other == track
+    })
+}
+
+// route all the parts through the mixer, using channelFor to decide which volume each part uses
+func (mixer *Mixer) AddParts(parts []Part, channelFor func(part *Part) MixerChannel) {
+    for i := range parts {
+        part := &parts[i]
+        part.Track = mixer.Add(part.Player, channelFor(part))
+    }
+}
+
+func (track *MixerTrack) SetGain(gain float64) {
+    track.mixer.lock.Lock()
+    defer track.mixer.lock.Unlock()
+
+    track.gain = gain
+    track.mixer.apply(track)
+}
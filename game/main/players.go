@@ -0,0 +1,305 @@
+package main
+
+import (
+    "io"
+    "log"
+    "fmt"
+    "time"
+    "slices"
+    "strings"
+    "encoding/json"
+)
+
+const DefaultPlayerName = "Player 1"
+const DefaultNoteSkin = "default"
+
+// how many scores are remembered for each song
+const MaxScoreHistory = 20
+
+// the largest input or video offset a player can set
+const MaxCalibrationOffset = 500 * time.Millisecond
+
+type ScoreRecord struct {
+    Score int `json:"score"`
+    NotesHit int `json:"notes_hit"`
+    NotesMissed int `json:"notes_missed"`
+    Difficulty string `json:"difficulty"`
+    Date time.Time `json:"date"`
+}
+
+// everything that belongs to one person playing the game
+type PlayerProfile struct {
+    Name string
+    Input *InputProfile
+
+    // mirror the highway for left handed players
+    Lefty bool
+    Highway HighwaySettings
+    // how fast notes move down the highway, 1 is normal
+    HighwaySpeed float64
+    // how late the player's inputs arrive, which is taken off the time of every input
+    InputOffset time.Duration
+    // how late the screen shows a frame, which the notes are drawn ahead by
+    VideoOffset time.Duration
+    NoteSkin string
+
+    // the scores of completed songs, most recent last, by song path
+    Scores map[string][]ScoreRecord
+}
+
+func NewPlayerProfile(name string) *PlayerProfile {
+    return &PlayerProfile{
+        Name: name,
+        Input: NewInputProfile(),
+        Highway: DefaultHighwaySettings(),
+        HighwaySpeed: 1,
+        NoteSkin: DefaultNoteSkin,
+        Scores: make(map[string][]ScoreRecord),
+    }
+}
+
+func (player *PlayerProfile) AddScore(path string, record ScoreRecord) {
+    scores := append(player.Scores[path], record)
+    if len(scores) > MaxScoreHistory {
+        scores = scores[len(scores) - MaxScoreHistory:]
+    }
+    player.Scores[path] = scores
+}
+
+// the highest score for the song, or false if it has never been completed
+func (player *PlayerProfile) BestScore(path string) (ScoreRecord, bool) {
+    scores := player.Scores[path]
+    if len(scores) == 0 {
+        return ScoreRecord{}, false
+    }
+
+    return slices.MaxFunc(scores, func(a ScoreRecord, b ScoreRecord) int {
+        return a.Score - b.Score
+    }), true
+}
+
+// move input events back to when the player actually played them
+func (player *PlayerProfile) adjustInput(events []InputEvent) []InputEvent {
+    for i := range events {
+        events[i].Time = events[i].Time.Add(-player.InputOffset)
+    }
+    return events
+}
+
+type SerializedPlayerProfile struct {
+    Name string `json:"name"`
+    Input SerializedInputProfile `json:"input"`
+    Lefty bool `json:"lefty"`
+    Highway *HighwaySettings `json:"highway"`
+    HighwaySpeed float64 `json:"highway_speed"`
+    InputOffsetMs int64 `json:"input_offset_ms"`
+    VideoOffsetMs int64 `json:"video_offset_ms"`
+    NoteSkin string `json:"note_skin"`
+    Scores map[string][]ScoreRecord `json:"scores"`
+}
+
+func (player *PlayerProfile) Serialized() SerializedPlayerProfile {
+    highway := player.Highway
+    return SerializedPlayerProfile{
+        Name: player.Name,
+        Input: player.Input.Serialized(),
+        Lefty: player.Lefty,
+        Highway: &highway,
+        HighwaySpeed: player.HighwaySpeed,
+        InputOffsetMs: player.InputOffset.Milliseconds(),
+        VideoOffsetMs: player.VideoOffset.Milliseconds(),
+        NoteSkin: player.NoteSkin,
+        Scores: player.Scores,
+    }
+}
+
+func (serialized *SerializedPlayerProfile) Load() *PlayerProfile {
+    player := NewPlayerProfile(serialized.Name)
+    player.Input = serialized.Input.Load()
+    player.Lefty = serialized.Lefty
+    if serialized.Highway != nil {
+        player.Highway = serialized.Highway.clamp()
+    }
+    if serialized.HighwaySpeed > 0 {
+        player.HighwaySpeed = serialized.HighwaySpeed
+    }
+    player.InputOffset = time.Duration(serialized.InputOffsetMs) * time.Millisecond
+    player.VideoOffset = time.Duration(serialized.VideoOffsetMs) * time.Millisecond
+    if serialized.NoteSkin != "" {
+        player.NoteSkin = serialized.NoteSkin
+    }
+    if serialized.Scores != nil {
+        player.Scores = serialized.Scores
+    }
+
+    return player
+}
+
+type PlayerLibrary struct {
+    Players []*PlayerProfile
+    // the name of the player who played last
+    Current string
+}
+
+// a library with just the default player
+func NewPlayerLibrary() *PlayerLibrary {
+    return &PlayerLibrary{
+        Players: []*PlayerProfile{NewPlayerProfile(DefaultPlayerName)},
+        Current: DefaultPlayerName,
+    }
+}
+
+func (library *PlayerLibrary) Get(name string) *PlayerProfile {
+    for _, player := range library.Players {
+        if player.Name == name {
+            return player
+        }
+    }
+
+    return nil
+}
+
+func (library *PlayerLibrary) Add(name string) (*PlayerProfile, error) {
+    name = strings.TrimSpace(name)
+    if name == "" {
+        return nil, fmt.Errorf("Player name is empty")
+    }
+
+    if library.Get(name) != nil {
+        return nil, fmt.Errorf("There is already a player named '%v'", name)
+    }
+
+    player := NewPlayerProfile(name)
+    library.Players = append(library.Players, player)
+    return player, nil
+}
+
+// remove a player, unless it is the only one left
+func (library *PlayerLibrary) Remove(name string) bool {
+    if len(library.Players) <= 1 {
+        return false
+    }
+
+    before := len(library.Players)
+    library.Players = slices.DeleteFunc(library.Players, func(player *PlayerProfile) bool {
+        return player.Name == name
+    })
+
+    if library.Current == name {
+        library.Current = library.Players[0].Name
+    }
+
+    return len(library.Players) != before
+}
+
+// the player who played last, or the first player if they are gone
+func (library *PlayerLibrary) CurrentPlayer() *PlayerProfile {
+    player := library.Get(library.Current)
+    if player == nil {
+        player = library.Players[0]
+    }
+
+    return player
+}
+
+type SerializedPlayerLibrary struct {
+    Current string `json:"current"`
+    Players []SerializedPlayerProfile `json:"players"`
+}
+
+func (library *PlayerLibrary) Serialized() SerializedPlayerLibrary {
+    serialized := SerializedPlayerLibrary{
+        Current: library.Current,
+    }
+
+    for _, player := range library.Players {
+        serialized.Players = append(serialized.Players, player.Serialized())
+    }
+
+    return serialized
+}
+
+func (library *PlayerLibrary) Serialize(out io.Writer) error {
+    serialized := library.Serialized()
+    encoder := json.NewEncoder(out)
+    encoder.SetIndent("", "  ")
+    return encoder.Encode(&serialized)
+}
+
+func (serialized *SerializedPlayerLibrary) Load() (*PlayerLibrary, error) {
+    if len(serialized.Players) == 0 {
+        return nil, fmt.Errorf("No players found")
+    }
+
+    library := &PlayerLibrary{
+        Current: serialized.Current,
+    }
+
+    for _, player := range serialized.Players {
+        library.Players = append(library.Players, player.Load())
+    }
+
+    return library, nil
+}
+
+func LoadPlayerLibrary(in io.Reader) (*PlayerLibrary, error) {
+    var serialized SerializedPlayerLibrary
+    decoder := json.NewDecoder(in)
+    err := decoder.Decode(&serialized)
+    if err != nil {
+        return nil, err
+    }
+
+    return serialized.Load()
+}
+
+// switch to another player. controllers are handed over to the new player's controls, which
+// picks up their saved bindings
+func (engine *Engine) SetPlayer(player *PlayerProfile) {
+    if engine.Player == player {
+        return
+    }
+
+    if engine.Player != nil {
+        for id := range engine.Player.Input.GamepadProfiles {
+            engine.Player.Input.DetachGamepad(id)
+        }
+    }
+
+    engine.Player = player
+    engine.Input = player.Input
+    engine.Players.Current = player.Name
+
+    for id := range engine.GamepadIds {
+        profile, known := player.Input.AttachGamepad(id)
+        if known && profile.HasBindings() {
+            player.Input.SetGamepadProfile(profile)
+        }
+    }
+
+    engine.OpenMidi()
+}
+
+func (engine *Engine) SavePlayers() {
+    err := engine.Configuration.SavePlayers(engine.Players)
+    if err != nil {
+        log.Printf("Unable to save players: %v", err)
+    }
+}
+
+// remember the score of a song the current player finished
+func (engine *Engine) RecordScore(result SongResult, difficulty string) {
+    if !result.Completed {
+        return
+    }
+
+    engine.Player.AddScore(result.Path, ScoreRecord{
+        Score: result.Score,
+        NotesHit: result.NotesHit,
+        NotesMissed: result.NotesMissed,
+        Difficulty: difficulty,
+        Date: time.Now(),
+    })
+
+    engine.SavePlayers()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+    "bytes"
+    "time"
+    "testing"
+)
+
+func TestPlayerLibraryRoundTrip(testing *testing.T) {
+    library := NewPlayerLibrary()
+    player, err := library.Add("Second")
+    if err != nil {
+        testing.Fatalf("Unable to add player: %v", err)
+    }
+
+    player.Lefty = true
+    player.HighwaySpeed = 1.5
+    player.Highway.Position = -0.5
+    player.InputOffset = 25 * time.Millisecond
+    player.VideoOffset = -10 * time.Millisecond
+    player.Input.KeyboardProfile.SetInput(InputActionGreen, library.Players[0].Input.KeyboardProfile.GetInput(InputActionOrange))
+    player.AddScore("songs/a", ScoreRecord{Score: 100, Difficulty: "hard"})
+    player.AddScore("songs/a", ScoreRecord{Score: 300, Difficulty: "easy"})
+    player.AddScore("songs/a", ScoreRecord{Score: 200, Difficulty: "expert"})
+    library.Current = player.Name
+
+    var buffer bytes.Buffer
+    err = library.Serialize(&buffer)
+    if err != nil {
+        testing.Fatalf("Unable to serialize: %v", err)
+    }
+
+    loaded, err := LoadPlayerLibrary(&buffer)
+    if err != nil {
+        testing.Fatalf("Unable to load: %v", err)
+    }
+
+    if len(loaded.Players) != 2 {
+        testing.Fatalf("expected 2 players but got %v", len(loaded.Players))
+    }
+
+    second := loaded.CurrentPlayer()
+    if second.Name != "Second" || !second.Lefty || second.HighwaySpeed != 1.5 || second.InputOffset != player.InputOffset || second.VideoOffset != player.VideoOffset || second.Highway != player.Highway {
+        testing.Errorf("player settings were not kept: %+v", second)
+    }
+
+    if second.Input.KeyboardProfile.GetInput(InputActionGreen) != player.Input.KeyboardProfile.GetInput(InputActionGreen) {
+        testing.Errorf("key bindings were not kept")
+    }
+
+    best, ok := second.BestScore("songs/a")
+    if !ok || best.Score != 300 || best.Difficulty != "easy" {
+        testing.Errorf("best score should be 300 on easy but was %+v", best)
+    }
+
+    if _, ok := second.BestScore("songs/b"); ok {
+        testing.Errorf("unplayed song should have no best score")
+    }
+}
+
+func TestPlayerLibraryNames(testing *testing.T) {
+    library := NewPlayerLibrary()
+
+    if _, err := library.Add(DefaultPlayerName); err == nil {
+        testing.Errorf("duplicate name should not be allowed")
+    }
+
+    if _, err := library.Add("  "); err == nil {
+        testing.Errorf("empty name should not be allowed")
+    }
+
+    if library.Remove(DefaultPlayerName) {
+        testing.Errorf("the last player should not be removed")
+    }
+
+    library.Add("Other")
+    if !library.Remove(DefaultPlayerName) || library.CurrentPlayer().Name != "Other" {
+        testing.Errorf("removing the current player should switch to another")
+    }
+}
+
+func TestScoreHistoryLimit(testing *testing.T) {
+    player := NewPlayerProfile("test")
+    for i := range MaxScoreHistory + 5 {
+        player.AddScore("song", ScoreRecord{Score: i})
+    }
+
+    scores := player.Scores["song"]
+    if len(scores) != MaxScoreHistory || scores[0].Score != 5 {
+        testing.Errorf("only the most recent %v scores should be kept, got %v starting at %v", MaxScoreHistory, len(scores), scores[0].Score)
+    }
+}
+
+func TestHighwayLayout(testing *testing.T) {
+    player := NewPlayerProfile("Test")
+
+    layout := player.HighwayLayout(5)
+    if layout.LaneX(0) != -20 || layout.LaneX(2) != 0 || layout.LaneX(4) != 20 || layout.NeckWidth() != 70 {
+        testing.Errorf("the normal layout should have lanes 10 apart around the middle")
+    }
+
+    player.Lefty = true
+    player.Highway = HighwaySettings{Width: 1.2, Length: 3, Position: 0.5}
+    layout = player.HighwayLayout(5)
+
+    if layout.LaneX(0) != 30 + 24 || layout.LaneX(4) != 30 - 24 {
+        testing.Errorf("lefty should mirror the lanes around the moved center, green was at %v", layout.LaneX(0))
+    }
+
+    if layout.Length != int(DefaultHighwayLength * MaxHighwayLength) {
+        testing.Errorf("the length should be limited, was %v", layout.Length)
+    }
+}
+
+func TestNoteSpeed(testing *testing.T) {
+    player := NewPlayerProfile("Test")
+    normal := player.HighwayLayout(GuitarFrets)
+
+    player.HighwaySpeed = 2
+    fast := player.HighwayLayout(GuitarFrets)
+
+    if normal.TimeToZ(time.Second) != HighwayUnitsPerSecond || fast.TimeToZ(time.Second) != 2 * HighwayUnitsPerSecond {
+        testing.Errorf("notes should move %v a second at normal speed and twice that at double speed", HighwayUnitsPerSecond)
+    }
+
+    if fast.Lookahead() * 2 != normal.Lookahead() {
+        testing.Errorf("faster notes should be visible for less time, %v vs %v", fast.Lookahead(), normal.Lookahead())
+    }
+
+    // notes appear at the far end of the highway whatever the speed
+    for _, layout := range []HighwayLayout{normal, fast} {
+        if layout.NoteAlpha(layout.TimeToZ(layout.Lookahead() + time.Millisecond)) != 0 || layout.NoteAlpha(layout.TimeToZ(time.Second)) != 1 {
+            testing.Errorf("notes should be hidden past the end of the highway and solid near the buttons")
+        }
+    }
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+    "io"
+    "log"
+    "slices"
+    "encoding/json"
+
+    "github.com/kazzmir/rhythm/lib/coroutine"
+)
+
+type Playlist struct {
+    Name string `json:"name"`
+    Songs []string `json:"songs"`
+}
+
+func (playlist *Playlist) Contains(song string) bool {
+    return slices.Contains(playlist.Songs, song)
+}
+
+func (playlist *Playlist) Add(song string) {
+    if !playlist.Contains(song) {
+        playlist.Songs = append(playlist.Songs, song)
+    }
+}
+
+func (playlist *Playlist) Remove(song string) {
+    playlist.Songs = slices.DeleteFunc(playlist.Songs, func(other string) bool {
+        return other == song
+    })
+}
+
+// the favorites list and all user created playlists
+type PlaylistLibrary struct {
+    Favorites Playlist `json:"favorites"`
+    Playlists []*Playlist `json:"playlists"`
+}
+
+func NewPlaylistLibrary() *PlaylistLibrary {
+    return &PlaylistLibrary{
+        Favorites: Playlist{Name: "Favorites"},
+    }
+}
+
+func (library *PlaylistLibrary) IsFavorite(song string) bool {
+    return library.Favorites.Contains(song)
+}
+
+func (library *PlaylistLibrary) ToggleFavorite(song string) {
+    if library.IsFavorite(song) {
+        library.Favorites.Remove(song)
+    } else {
+        library.Favorites.Add(song)
+    }
+}
+
+// returns nil if no playlist has the given name
+func (library *PlaylistLibrary) GetPlaylist(name string) *Playlist {
+    for _, playlist := range library.Playlists {
+        if playlist.Name == name {
+            return playlist
+        }
+    }
+
+    return nil
+}
+
+// returns the existing playlist if one already has this name
+func (library *PlaylistLibrary) AddPlaylist(name string) *Playlist {
+    existing := library.GetPlaylist(name)
+    if existing != nil {
+        return existing
+    }
+
+    playlist := &Playlist{Name: name}
+    library.Playlists = append(library.Playlists, playlist)
+    return playlist
+}
+
+func (library *PlaylistLibrary) RemovePlaylist(name string) {
+    library.Playlists = slices.DeleteFunc(library.Playlists, func(playlist *Playlist) bool {
+        return playlist.Name == name
+    })
+}
+
+func (library *PlaylistLibrary) Serialize(out io.Writer) error {
+    encoder := json.NewEncoder(out)
+    encoder.SetIndent("", "  ")
+    return encoder.Encode(library)
+}
+
+func LoadPlaylistLibrary(in io.Reader) (*PlaylistLibrary, error) {
+    library := NewPlaylistLibrary()
+    decoder := json.NewDecoder(in)
+    err := decoder.Decode(library)
+    if err != nil {
+        return nil, err
+    }
+
+    library.Favorites.Name = "Favorites"
+
+    // drop unnamed playlists, they can't be selected in the ui
+    library.Playlists = slices.DeleteFunc(library.Playlists, func(playlist *Playlist) bool {
+        return playlist == nil || playlist.Name == ""
+    })
+
+    return library, nil
+}
+
+// the outcome of playing a single song
+type SongResult struct {
+    Path string
+    SongInfo SongInfo
+    NotesHit int
+    NotesMissed int
+    Score int
+    // the difficulty the song was played on
+    Difficulty string
+    // false if the player quit before the song ended
+    Completed bool
+    // why the song couldn't be played, nil if it was
+    Err error
+}
+
+// true if the song was quit or couldn't be played
+func (result *SongResult) Failed() bool {
+    return result.Err != nil || !result.Completed
+}
+
+func (result *SongResult) Percent() int {
+    total := result.NotesHit + result.NotesMissed
+    if total == 0 {
+        return 0
+    }
+
+    return result.NotesHit * 100 / total
+}
+
+// picks the settings for a song before it is played, or returns false if the player backed out
+type SetlistSetup func(songPath string) (SongSettings, bool)
+
+// set up and play each song in order. the setlist stops early if the player backs out of setting
+// up a song or quits out of one, or if a song can't be played, which is kept in the results with its
+// error so the summary can show it
+func playSetlist(yield coroutine.YieldFunc, engine *Engine, songs []string, setup SetlistSetup, input InputSource) []SongResult {
+    var results []SongResult
+
+    for i, songPath := range songs {
+        if i > 0 {
+            if yield() != nil {
+                break
+            }
+        }
+
+        settings, ok := setup(songPath)
+        if !ok {
+            break
+        }
+
+        result, err := playSong(yield, engine, songPath, settings, input)
+        result.Difficulty = settings.Difficulty
+        if err != nil {
+            log.Printf("Unable to play setlist song '%v': %v", songPath, err)
+            result.Err = err
+            results = append(results, result)
+            break
+        }
+
+        results = append(results, result)
+
+        if !result.Completed {
+            break
+        }
+    }
+
+    return results
+}
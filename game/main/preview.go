@@ -0,0 +1,201 @@
+package main
+
+import (
+    "log"
+    "time"
+    "strings"
+    "context"
+    "io/fs"
+    "path/filepath"
+
+    "github.com/hajimehoshi/ebiten/v2/audio"
+)
+
+// how much of the song to play before looping back to the preview start
+const PreviewLength = 30 * time.Second
+const PreviewFade = 2 * time.Second
+
+// the point in the song where the preview should begin. if the song.ini doesn't specify
+// a preview time then start a third of the way in, which usually skips the intro
+func previewStartTime(info SongInfo, songLength time.Duration) time.Duration {
+    if info.PreviewStart > 0 && info.PreviewStart < songLength {
+        return info.PreviewStart
+    }
+
+    return songLength / 3
+}
+
+// how long the preview window is, starting from 'start'
+func previewWindowLength(info SongInfo, start time.Duration, songLength time.Duration) time.Duration {
+    length := PreviewLength
+    if info.PreviewEnd > start {
+        length = info.PreviewEnd - start
+    }
+
+    return max(0, min(length, songLength - start))
+}
+
+// volume at 'offset' into a preview window of the given length, fading in at the start and out at the end
+func previewVolume(offset time.Duration, length time.Duration) float64 {
+    if offset <= 0 || offset >= length {
+        return 0
+    }
+
+    fade := min(PreviewFade, length / 2)
+    if fade <= 0 {
+        return 1
+    }
+
+    if offset < fade {
+        return float64(offset) / float64(fade)
+    }
+
+    if length - offset < fade {
+        return float64(length - offset) / float64(fade)
+    }
+
+    return 1
+}
+
+// load the audio needed to play a preview. if the song has a dedicated preview file then only that
+// is loaded, otherwise all of the stems are loaded. loading stops early if quit is canceled.
+func loadPreviewParts(quit context.Context, audioContext *audio.Context, songFS fs.FS) ([]Part, time.Duration, []func(), error) {
+    var previewFile string
+    var stems []string
+
+    err := fs.WalkDir(songFS, ".", func(path string, entry fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+
+        if entry.IsDir() || !isAudioFile(path) {
+            return nil
+        }
+
+        if ParseStem(path) == StemPreview {
+            previewFile = path
+        } else {
+            stems = append(stems, path)
+        }
+
+        return nil
+    })
+
+    if err != nil {
+        return nil, 0, nil, err
+    }
+
+    if previewFile != "" {
+        stems = []string{previewFile}
+    }
+
+    var longest time.Duration
+    var parts []Part
+    var cleanups []func()
+
+    for _, path := range stems {
+        if quit.Err() != nil {
+            for _, part := range parts {
+                part.Player.Close()
+            }
+            for _, cleanup := range cleanups {
+                cleanup()
+            }
+            return nil, 0, nil, quit.Err()
+        }
+
+        player, duration, cleanup, err := loadAudio2(audioContext, songFS, path, strings.ToLower(filepath.Ext(path)))
+        if err != nil {
+            log.Printf("Unable to load preview audio '%v': %v", path, err)
+            continue
+        }
+
+        parts = append(parts, Part{
+            Name: strings.TrimSuffix(path, filepath.Ext(path)),
+            Stem: ParseStem(path),
+            Player: player,
+        })
+        cleanups = append(cleanups, cleanup)
+        longest = max(longest, duration)
+    }
+
+    return parts, longest, cleanups, nil
+}
+
+// play a looping preview of the song in songFS until quit is canceled
+func playPreview(quit context.Context, audioContext *audio.Context, mixer *Mixer, songFS fs.FS) {
+    var info SongInfo
+    iniFile, err := findFile(songFS, "song.ini")
+    if err == nil {
+        info = loadSongInfo(iniFile)
+        iniFile.Close()
+    }
+
+    parts, songLength, cleanups, err := loadPreviewParts(quit, audioContext, songFS)
+    if err != nil || len(parts) == 0 {
+        return
+    }
+
+    mixer.AddParts(parts, func(part *Part) MixerChannel {
+        return MixerChannelPreview
+    })
+
+    defer func() {
+        for i := range parts {
+            parts[i].Close()
+        }
+
+        for _, cleanup := range cleanups {
+            cleanup()
+        }
+    }()
+
+    var start time.Duration
+    length := min(PreviewLength, songLength)
+
+    // a dedicated preview file is already cut to the right section
+    if len(parts) > 1 || parts[0].Stem != StemPreview {
+        start = previewStartTime(info, songLength)
+        length = previewWindowLength(info, start, songLength)
+    }
+
+    if length <= 0 {
+        return
+    }
+
+    restart := func() {
+        for i := range parts {
+            part := &parts[i]
+            part.SetVolume(0)
+            err := part.Player.SetPosition(start)
+            if err != nil {
+                log.Printf("Unable to seek preview to %v: %v", start, err)
+            }
+            part.Player.Play()
+        }
+    }
+
+    restart()
+
+    ticker := time.NewTicker(time.Second / 30)
+    defer ticker.Stop()
+
+    for {
+        select {
+            case <-quit.Done():
+                return
+            case <-ticker.C:
+                // the first part is used as the clock for all of them
+                offset := parts[0].Player.Position() - start
+                if offset >= length || !parts[0].Player.IsPlaying() {
+                    restart()
+                    continue
+                }
+
+                volume := previewVolume(offset, length)
+                for i := range parts {
+                    parts[i].SetVolume(volume)
+                }
+        }
+    }
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+    "os"
+    "fmt"
+    "log"
+    "math"
+    "image"
+    "sync"
+    "strings"
+    "io/fs"
+    "image/color"
+    "path/filepath"
+    "encoding/json"
+
+    "github.com/hajimehoshi/ebiten/v2"
+    "github.com/hajimehoshi/ebiten/v2/vector"
+    "github.com/solarlune/tetra3d"
+)
+
+// user skins each have a directory with a skin.json manifest in it, inside a "skins" directory in
+// either the config directory or the working directory
+const SkinsDirectoryName = "skins"
+const SkinManifestFile = "skin.json"
+
+// a song can bring its own neck texture
+const SongHighwayFile = "highway.png"
+
+type NoteStyle int
+const (
+    NoteStyleRound NoteStyle = iota
+    NoteStyleHexagon
+    NoteStyleSquare
+)
+
+func ParseNoteStyle(name string) (NoteStyle, error) {
+    switch strings.ToLower(name) {
+        case "", "round": return NoteStyleRound, nil
+        case "hexagon": return NoteStyleHexagon, nil
+        case "square": return NoteStyleSquare, nil
+    }
+
+    return NoteStyleRound, fmt.Errorf("Unknown note style '%v'", name)
+}
+
+// how many sides the note and button models have
+func (style NoteStyle) Sides() int {
+    switch style {
+        case NoteStyleHexagon: return 6
+        case NoteStyleSquare: return 4
+    }
+
+    return 15
+}
+
+// what a skin.json holds. images are relative to the skin's directory and colors are #rrggbb
+type SkinManifest struct {
+    Name string `json:"name"`
+    Neck string `json:"neck"`
+    // green, red, yellow, blue and orange
+    FretColors []string `json:"fret_colors"`
+    NoteStyle string `json:"note_style"`
+    FlameColor string `json:"flame_color"`
+    // drawn behind the highway
+    Background string `json:"background"`
+
+    // where the manifest was found, empty for the built in skin
+    Directory string `json:"-"`
+}
+
+type Skin struct {
+    Name string
+    Neck *ebiten.Image
+    // by fret, green first
+    FretColors []tetra3d.Color
+    NoteStyle NoteStyle
+    FlameColor tetra3d.Color
+    // nil to draw the highway on a plain background
+    Background *ebiten.Image
+}
+
+// the skin that comes with the game, which uses one of the embedded neck textures
+func DefaultSkinManifest() SkinManifest {
+    return SkinManifest{
+        Name: DefaultNoteSkin,
+    }
+}
+
+// the built in neck texture is one of the embedded textures picked at random, so it is picked
+// once to look the same in the skin picker as it does in every song
+var defaultNeck = sync.OnceValue(loadSkin)
+
+// parse a color written as #rrggbb
+func parseHexColor(value string) (color.NRGBA, error) {
+    var red, green, blue uint8
+    _, err := fmt.Sscanf(value, "#%02x%02x%02x", &red, &green, &blue)
+    if err != nil {
+        return color.NRGBA{}, fmt.Errorf("Invalid color '%v': %v", value, err)
+    }
+
+    return color.NRGBA{R: red, G: green, B: blue, A: 255}, nil
+}
+
+func toTetraColor(value color.NRGBA) tetra3d.Color {
+    return tetra3d.NewColor(float32(value.R) / 255, float32(value.G) / 255, float32(value.B) / 255, 1)
+}
+
+// decode a png or jpeg
+func loadImageFile(fsys fs.FS, name string) (*ebiten.Image, error) {
+    file, err := fsys.Open(name)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    decoded, _, err := image.Decode(file)
+    if err != nil {
+        return nil, fmt.Errorf("Unable to decode image '%v': %v", name, err)
+    }
+
+    return ebiten.NewImageFromImage(decoded), nil
+}
+
+// the song's own neck texture, or nil if it doesn't have one
+func loadSongHighway(songFS fs.FS) *ebiten.Image {
+    file, err := findFile(songFS, SongHighwayFile)
+    if err != nil {
+        return nil
+    }
+    defer file.Close()
+
+    texture, err := loadPng(file)
+    if err != nil {
+        log.Printf("Unable to load %v: %v", SongHighwayFile, err)
+        return nil
+    }
+
+    return texture
+}
+
+// the settings of the manifest, with anything missing or broken taken from the built in skin
+func (manifest *SkinManifest) Load() *Skin {
+    skin := &Skin{
+        Name: manifest.Name,
+        FlameColor: tetra3d.NewColor(248.0/255.0, 134.0/255.0, 69.0/255.0, 1),
+    }
+
+    for i := range GuitarFrets {
+        skin.FretColors = append(skin.FretColors, fretColor(InputActionGreen + InputAction(i)))
+    }
+
+    var err error
+    skin.NoteStyle, err = ParseNoteStyle(manifest.NoteStyle)
+    if err != nil {
+        log.Printf("Skin %v: %v", manifest.Name, err)
+    }
+
+    for i, value := range manifest.FretColors {
+        if i >= len(skin.FretColors) {
+            break
+        }
+
+        parsed, err := parseHexColor(value)
+        if err != nil {
+            log.Printf("Skin %v: %v", manifest.Name, err)
+            continue
+        }
+        skin.FretColors[i] = toTetraColor(parsed)
+    }
+
+    if manifest.FlameColor != "" {
+        parsed, err := parseHexColor(manifest.FlameColor)
+        if err != nil {
+            log.Printf("Skin %v: %v", manifest.Name, err)
+        } else {
+            skin.FlameColor = toTetraColor(parsed)
+        }
+    }
+
+    if manifest.Directory != "" {
+        skinFS := os.DirFS(manifest.Directory)
+
+        if manifest.Neck != "" {
+            skin.Neck, err = loadImageFile(skinFS, manifest.Neck)
+            if err != nil {
+                log.Printf("Skin %v: unable to load the neck: %v", manifest.Name, err)
+            }
+        }
+
+        if manifest.Background != "" {
+            skin.Background, err = loadImageFile(skinFS, manifest.Background)
+            if err != nil {
+                log.Printf("Skin %v: unable to load the background: %v", manifest.Name, err)
+            }
+        }
+    }
+
+    if skin.Neck == nil {
+        skin.Neck = defaultNeck()
+    }
+
+    return skin
+}
+
+// the color of a fret's notes
+func (skin *Skin) FretColor(action InputAction) tetra3d.Color {
+    index := int(action - InputActionGreen)
+    if index >= 0 && index < len(skin.FretColors) {
+        return skin.FretColors[index]
+    }
+
+    return fretColor(action)
+}
+
+// a flat picture of the skin for the skin picker
+func (skin *Skin) Preview(width int, height int) *ebiten.Image {
+    preview := ebiten.NewImage(width, height)
+    preview.Fill(color.NRGBA{R: 20, G: 23, B: 26, A: 255})
+
+    drawScaled := func(source *ebiten.Image, x float64, y float64, areaWidth float64, areaHeight float64) {
+        var options ebiten.DrawImageOptions
+        bounds := source.Bounds()
+        options.GeoM.Scale(areaWidth / float64(bounds.Dx()), areaHeight / float64(bounds.Dy()))
+        options.GeoM.Translate(x, y)
+        options.Filter = ebiten.FilterLinear
+        preview.DrawImage(source, &options)
+    }
+
+    if skin.Background != nil {
+        drawScaled(skin.Background, 0, 0, float64(width), float64(height))
+    }
+
+    // the neck down the middle with a note in each lane
+    neckWidth := float64(width) / 2
+    neckX := (float64(width) - neckWidth) / 2
+    drawScaled(skin.Neck, neckX, 0, neckWidth, float64(height))
+
+    lane := float32(neckWidth) / float32(GuitarFrets + 2)
+    radius := lane * 0.4
+    for fret := range GuitarFrets {
+        x := float32(neckX) + lane * float32(fret + 1) + lane / 2
+        y := float32(height) * (0.3 + 0.1 * float32(fret % 2))
+        fill := skin.FretColors[fret].ToNRGBA64()
+
+        switch skin.NoteStyle {
+            case NoteStyleRound:
+                vector.FillCircle(preview, x, y, radius, fill, true)
+            default:
+                var path vector.Path
+                sides := skin.NoteStyle.Sides()
+                for side := range sides {
+                    angle := float64(side) / float64(sides) * 2 * math.Pi
+                    px := x + radius * float32(math.Cos(angle))
+                    py := y + radius * float32(math.Sin(angle))
+                    if side == 0 {
+                        path.MoveTo(px, py)
+                    } else {
+                        path.LineTo(px, py)
+                    }
+                }
+                path.Close()
+                var drawOptions vector.DrawPathOptions
+                drawOptions.AntiAlias = true
+                drawOptions.ColorScale.ScaleWithColor(fill)
+                vector.FillPath(preview, &path, nil, &drawOptions)
+        }
+    }
+
+    // a flame over the strike line
+    vector.FillCircle(preview, float32(width) / 2, float32(height) * 0.85, radius, skin.FlameColor.ToNRGBA64(), true)
+
+    return preview
+}
+
+// find every skin in the directories, with the built in skin first. skins with the same name as
+// one found earlier are skipped
+func FindSkins(directories []string) []SkinManifest {
+    skins := []SkinManifest{DefaultSkinManifest()}
+    names := map[string]bool{DefaultNoteSkin: true}
+
+    for _, directory := range directories {
+        entries, err := os.ReadDir(directory)
+        if err != nil {
+            continue
+        }
+
+        for _, entry := range entries {
+            if !entry.IsDir() {
+                continue
+            }
+
+            path := filepath.Join(directory, entry.Name())
+            data, err := os.ReadFile(filepath.Join(path, SkinManifestFile))
+            if err != nil {
+                continue
+            }
+
+            var manifest SkinManifest
+            err = json.Unmarshal(data, &manifest)
+            if err != nil {
+                log.Printf("Unable to read skin %v: %v", path, err)
+                continue
+            }
+
+            if manifest.Name == "" {
+                manifest.Name = entry.Name()
+            }
+            manifest.Directory = path
+
+            if names[manifest.Name] {
+                log.Printf("Skipping skin %v, there is already a skin named '%v'", path, manifest.Name)
+                continue
+            }
+            names[manifest.Name] = true
+
+            skins = append(skins, manifest)
+        }
+    }
+
+    return skins
+}
+
+// where user skins are kept
+func (engine *Engine) SkinDirectories() []string {
+    return []string{filepath.Join(engine.Configuration.Directory, SkinsDirectoryName), SkinsDirectoryName}
+}
+
+// load a skin by name, falling back to the built in skin if it is gone
+func (engine *Engine) LoadSkin(name string) *Skin {
+    for _, manifest := range FindSkins(engine.SkinDirectories()) {
+        if manifest.Name == name {
+            return manifest.Load()
+        }
+    }
+
+    log.Printf("Unable to find skin '%v', using the default skin", name)
+    manifest := DefaultSkinManifest()
+    return manifest.Load()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+    "os"
+    "testing"
+    "path/filepath"
+)
+
+func TestFindSkins(testing *testing.T) {
+    directory := testing.TempDir()
+
+    writeSkin := func(folder string, manifest string) {
+        path := filepath.Join(directory, folder)
+        err := os.MkdirAll(path, 0755)
+        if err != nil {
+            testing.Fatalf("Unable to make skin directory: %v", err)
+        }
+        err = os.WriteFile(filepath.Join(path, SkinManifestFile), []byte(manifest), 0644)
+        if err != nil {
+            testing.Fatalf("Unable to write manifest: %v", err)
+        }
+    }
+
+    writeSkin("neon", `{"name": "Neon", "fret_colors": ["#ff00ff", "#00ffff"], "note_style": "hexagon", "flame_color": "#0080ff"}`)
+    // named after its folder
+    writeSkin("plain", `{}`)
+    // the name is taken by the built in skin
+    writeSkin("other", `{"name": "default"}`)
+    writeSkin("broken", `{"name": `)
+
+    skins := FindSkins([]string{directory, filepath.Join(directory, "missing")})
+
+    var names []string
+    for _, skin := range skins {
+        names = append(names, skin.Name)
+    }
+    if len(names) != 3 || names[0] != DefaultNoteSkin || names[1] != "Neon" || names[2] != "plain" {
+        testing.Fatalf("Unexpected skins: %v", names)
+    }
+
+    neon := skins[1]
+    if neon.Directory != filepath.Join(directory, "neon") {
+        testing.Errorf("Unexpected directory %v", neon.Directory)
+    }
+
+    style, err := ParseNoteStyle(neon.NoteStyle)
+    if err != nil || style.Sides() != 6 {
+        testing.Errorf("Expected a hexagon, got %v: %v", style, err)
+    }
+
+    _, err = ParseNoteStyle("triangle")
+    if err == nil {
+        testing.Errorf("Expected an unknown note style to fail")
+    }
+
+    color, err := parseHexColor(neon.FlameColor)
+    if err != nil || color.R != 0 || color.G != 0x80 || color.B != 0xff || color.A != 255 {
+        testing.Errorf("Unexpected flame color %v: %v", color, err)
+    }
+
+    _, err = parseHexColor("orange")
+    if err == nil {
+        testing.Errorf("Expected an invalid color to fail")
+    }
+}
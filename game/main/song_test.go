@@ -0,0 +1,547 @@
+package main
+
+import (
+    "time"
+    "slices"
+    "testing"
+
+    "github.com/hajimehoshi/ebiten/v2"
+)
+
+type testFlames struct {
+    flames []int
+}
+
+func (flames *testFlames) MakeFlame(fret int) {
+    flames.flames = append(flames.flames, fret)
+}
+
+var testStart = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// a song where frets[i] holds the start times of the notes on fret i
+func makeTestSong(frets ...[]time.Duration) *Song {
+    song := &Song{
+        Frets: make([]Fret, 5),
+        Timing: DefaultTimingWindows(),
+        JudgementCounts: make(map[Judgement]int),
+        StartTime: testStart,
+    }
+
+    for i := range song.Frets {
+        song.Frets[i].InputAction = GameActions[i]
+    }
+
+    for i, starts := range frets {
+        for _, start := range starts {
+            song.Frets[i].Notes = append(song.Frets[i].Notes, Note{
+                Start: start,
+                End: start + 50 * time.Millisecond,
+            })
+        }
+    }
+
+    return song
+}
+
+type testEvent struct {
+    At time.Duration
+    Action InputAction
+    Pressed bool
+}
+
+// update the song every tick until 'until', delivering each event on the first tick after it
+// happened, the way the input queue would
+func playTestEvents(song *Song, events []testEvent, tick time.Duration, until time.Duration) *testFlames {
+    var flames testFlames
+    var queue InputQueue
+
+    next := 0
+    for now := tick; now <= until; now += tick {
+        for next < len(events) && events[next].At <= now {
+            queue.Push(InputEvent{
+                Action: events[next].Action,
+                Pressed: events[next].Pressed,
+                Time: testStart.Add(events[next].At),
+            })
+            next += 1
+        }
+
+        song.UpdateAt(testStart.Add(now), queue.Drain(), &flames)
+    }
+
+    return &flames
+}
+
+func ms(value int) time.Duration {
+    return time.Duration(value) * time.Millisecond
+}
+
+func TestJudgeAtEventTime(testing *testing.T) {
+    song := makeTestSong([]time.Duration{ms(1000)})
+
+    // a long frame means the strum is noticed 80ms after it happened
+    playTestEvents(song, []testEvent{
+        {At: ms(950), Action: InputActionGreen, Pressed: true},
+        {At: ms(1000), Action: InputActionStrumDown, Pressed: true},
+    }, ms(80), ms(2000))
+
+    if song.NotesHit != 1 || song.NotesMissed != 0 {
+        testing.Fatalf("note should be hit, hit %v missed %v", song.NotesHit, song.NotesMissed)
+    }
+
+    if song.JudgementCounts[JudgementPerfect] != 1 {
+        testing.Errorf("strum exactly on the note should be perfect: %v", song.JudgementCounts)
+    }
+}
+
+func TestLateDelivery(testing *testing.T) {
+    song := makeTestSong([]time.Duration{ms(1000)})
+
+    // the frame that sees the strum is already past the late window, but the strum itself was on time
+    playTestEvents(song, []testEvent{
+        {At: ms(900), Action: InputActionGreen, Pressed: true},
+        {At: ms(1010), Action: InputActionStrumDown, Pressed: true},
+    }, ms(250), ms(2000))
+
+    if song.NotesHit != 1 {
+        testing.Errorf("note should be judged at the time of the strum, hit %v missed %v", song.NotesHit, song.NotesMissed)
+    }
+}
+
+func TestEventOrder(testing *testing.T) {
+    song := makeTestSong([]time.Duration{ms(1000)})
+
+    // the fret is let go before the strum, even though all three arrive in the same frame
+    playTestEvents(song, []testEvent{
+        {At: ms(985), Action: InputActionGreen, Pressed: true},
+        {At: ms(990), Action: InputActionGreen, Pressed: false},
+        {At: ms(995), Action: InputActionStrumDown, Pressed: true},
+    }, ms(100), ms(2000))
+
+    if song.NotesHit != 0 || song.NotesMissed != 1 {
+        testing.Errorf("strum without a held fret should not hit, hit %v missed %v", song.NotesHit, song.NotesMissed)
+    }
+}
+
+func TestQueueOrder(testing *testing.T) {
+    var queue InputQueue
+
+    queue.Push(InputEvent{Action: InputActionStrumDown, Pressed: true, Time: testStart.Add(ms(30))})
+    queue.Push(InputEvent{Action: InputActionGreen, Pressed: true, Time: testStart.Add(ms(10))})
+    queue.Push(InputEvent{Action: InputActionRed, Pressed: true, Time: testStart.Add(ms(30))})
+    queue.Push(InputEvent{Action: InputActionYellow, Pressed: true, Time: testStart.Add(ms(20))})
+
+    expected := []InputAction{InputActionGreen, InputActionYellow, InputActionStrumDown, InputActionRed}
+
+    events := queue.Drain()
+    if len(events) != len(expected) {
+        testing.Fatalf("expected %v events but got %v", len(expected), len(events))
+    }
+
+    for i, event := range events {
+        if event.Action != expected[i] {
+            testing.Errorf("event %v should be %v but was %v", i, expected[i], event.Action)
+        }
+    }
+
+    if len(queue.Drain()) != 0 {
+        testing.Errorf("queue should be empty after draining")
+    }
+}
+
+type testNote struct {
+    Fret int
+    Start time.Duration
+    Length time.Duration
+}
+
+func makeTestChart(notes []testNote) *Song {
+    song := makeTestSong()
+
+    for _, note := range notes {
+        length := note.Length
+        if length == 0 {
+            length = ms(50)
+        }
+
+        fret := &song.Frets[note.Fret]
+        fret.Notes = append(fret.Notes, Note{
+            Start: note.Start,
+            End: note.Start + length,
+        })
+    }
+
+    return song
+}
+
+// press and release an action
+func tap(action InputAction, at time.Duration, hold time.Duration) []testEvent {
+    return []testEvent{
+        {At: at, Action: action, Pressed: true},
+        {At: at + hold, Action: action, Pressed: false},
+    }
+}
+
+// play the song with scripted input, polling it every tick like playSong does
+func playScripted(song *Song, events []testEvent, until time.Duration) {
+    var inputEvents []InputEvent
+    for _, event := range events {
+        inputEvents = append(inputEvents, InputEvent{
+            Action: event.Action,
+            Pressed: event.Pressed,
+            Time: testStart.Add(event.At),
+        })
+    }
+
+    input := NewScriptedInput(inputEvents)
+    var queue InputQueue
+    var flames testFlames
+
+    tick := time.Second / 120
+    for now := time.Duration(0); now <= until; now += tick {
+        input.Advance(testStart.Add(now))
+        queue.Poll(input, testStart.Add(now))
+        song.UpdateAt(testStart.Add(now), queue.Drain(), &flames)
+    }
+}
+
+func concatEvents(groups ...[]testEvent) []testEvent {
+    var out []testEvent
+    for _, group := range groups {
+        out = append(out, group...)
+    }
+    return out
+}
+
+func TestJudgement(testing *testing.T) {
+    strum := func(at time.Duration) []testEvent {
+        return tap(InputActionStrumDown, at, ms(30))
+    }
+
+    tests := []struct {
+        Name string
+        Chart []testNote
+        Events []testEvent
+        Hit int
+        Missed int
+        Streak int
+        // inclusive range of the expected score
+        MinScore int
+        MaxScore int
+        Judgement Judgement
+    }{
+        {
+            Name: "single hit",
+            Chart: []testNote{{Fret: 0, Start: ms(1000)}},
+            Events: concatEvents(tap(InputActionGreen, ms(900), ms(300)), strum(ms(1000))),
+            Hit: 1, Streak: 1, MinScore: 5, MaxScore: 5,
+            Judgement: JudgementPerfect,
+        },
+        {
+            Name: "late hit",
+            Chart: []testNote{{Fret: 0, Start: ms(1000)}},
+            Events: concatEvents(tap(InputActionGreen, ms(900), ms(300)), strum(ms(1080))),
+            Hit: 1, Streak: 1, MinScore: 4, MaxScore: 4,
+            Judgement: JudgementGreat,
+        },
+        {
+            Name: "no input",
+            Chart: []testNote{{Fret: 0, Start: ms(1000)}, {Fret: 1, Start: ms(1500)}},
+            Missed: 2,
+        },
+        {
+            Name: "wrong fret",
+            Chart: []testNote{{Fret: 0, Start: ms(1000)}},
+            Events: concatEvents(tap(InputActionRed, ms(900), ms(300)), strum(ms(1000))),
+            Missed: 1,
+        },
+        {
+            Name: "too early",
+            Chart: []testNote{{Fret: 0, Start: ms(1000)}},
+            Events: concatEvents(tap(InputActionGreen, ms(500), ms(800)), strum(ms(600))),
+            Missed: 1,
+        },
+        {
+            Name: "chord",
+            Chart: []testNote{{Fret: 0, Start: ms(1000)}, {Fret: 2, Start: ms(1000)}},
+            Events: concatEvents(tap(InputActionGreen, ms(900), ms(300)), tap(InputActionYellow, ms(920), ms(280)), strum(ms(1010))),
+            Hit: 2, Streak: 2, MinScore: 10, MaxScore: 10,
+            Judgement: JudgementPerfect,
+        },
+        {
+            Name: "chord with an extra fret",
+            Chart: []testNote{{Fret: 0, Start: ms(1000)}, {Fret: 2, Start: ms(1000)}},
+            Events: concatEvents(tap(InputActionGreen, ms(900), ms(300)), tap(InputActionYellow, ms(900), ms(300)), tap(InputActionBlue, ms(900), ms(300)), strum(ms(1000))),
+            Missed: 2,
+        },
+        {
+            Name: "sustain held",
+            Chart: []testNote{{Fret: 0, Start: ms(1000), Length: ms(1000)}},
+            Events: concatEvents(tap(InputActionGreen, ms(900), ms(1200)), strum(ms(1000))),
+            Hit: 1, Streak: 1, MinScore: 100, MaxScore: 130,
+            Judgement: JudgementPerfect,
+        },
+        {
+            Name: "sustain released early",
+            Chart: []testNote{{Fret: 0, Start: ms(1000), Length: ms(1000)}},
+            Events: concatEvents(tap(InputActionGreen, ms(900), ms(300)), strum(ms(1000))),
+            Hit: 1, Streak: 1, MinScore: 20, MaxScore: 40,
+            Judgement: JudgementPerfect,
+        },
+        {
+            Name: "overstrum breaks the streak",
+            Chart: []testNote{{Fret: 0, Start: ms(1000)}},
+            Events: concatEvents(tap(InputActionGreen, ms(900), ms(300)), strum(ms(1000)), strum(ms(1500))),
+            Hit: 1, Streak: 0, MinScore: 5, MaxScore: 5,
+            Judgement: JudgementPerfect,
+        },
+    }
+
+    for _, test := range tests {
+        song := makeTestChart(test.Chart)
+        playScripted(song, test.Events, ms(3000))
+
+        if song.NotesHit != test.Hit || song.NotesMissed != test.Missed {
+            testing.Errorf("%v: expected %v hit and %v missed but got %v and %v", test.Name, test.Hit, test.Missed, song.NotesHit, song.NotesMissed)
+        }
+
+        if song.Streak != test.Streak {
+            testing.Errorf("%v: expected a streak of %v but got %v", test.Name, test.Streak, song.Streak)
+        }
+
+        if song.Score < test.MinScore || song.Score > test.MaxScore {
+            testing.Errorf("%v: expected a score between %v and %v but got %v", test.Name, test.MinScore, test.MaxScore, song.Score)
+        }
+
+        if test.Hit > 0 && song.JudgementCounts[test.Judgement] != test.Hit {
+            testing.Errorf("%v: expected every hit to be %v but got %v", test.Name, test.Judgement, song.JudgementCounts)
+        }
+    }
+}
+
+func TestStrumDirections(testing *testing.T) {
+    chart := []testNote{{Fret: 0, Start: ms(1000)}, {Fret: 0, Start: ms(1300)}, {Fret: 0, Start: ms(1600)}}
+    fret := tap(InputActionGreen, ms(900), ms(800))
+
+    // up and down both strum
+    song := makeTestChart(chart)
+    playScripted(song, concatEvents(fret, tap(InputActionStrumUp, ms(1000), ms(30)), tap(InputActionStrumUp, ms(1300), ms(30)), tap(InputActionStrumDown, ms(1600), ms(30))), ms(2000))
+    if song.NotesHit != 3 {
+        testing.Errorf("strumming up should hit notes, hit %v missed %v", song.NotesHit, song.NotesMissed)
+    }
+
+    // the second up strum doesn't count when strums have to alternate
+    song = makeTestChart(chart)
+    song.AlternateStrum = true
+    playScripted(song, concatEvents(fret, tap(InputActionStrumUp, ms(1000), ms(30)), tap(InputActionStrumUp, ms(1300), ms(30)), tap(InputActionStrumDown, ms(1600), ms(30))), ms(2000))
+    if song.NotesHit != 2 || song.NotesMissed != 1 {
+        testing.Errorf("repeated strum should not hit, hit %v missed %v", song.NotesHit, song.NotesMissed)
+    }
+
+    // after a pause either direction is fine
+    song = makeTestChart([]testNote{{Fret: 0, Start: ms(1000)}, {Fret: 0, Start: ms(2000)}})
+    song.AlternateStrum = true
+    playScripted(song, concatEvents(tap(InputActionGreen, ms(900), ms(1200)), tap(InputActionStrumDown, ms(1000), ms(30)), tap(InputActionStrumDown, ms(2000), ms(30))), ms(3000))
+    if song.NotesHit != 2 {
+        testing.Errorf("strum after a pause should hit, hit %v missed %v", song.NotesHit, song.NotesMissed)
+    }
+}
+
+func TestFretStrum(testing *testing.T) {
+    chart := []testNote{{Fret: 0, Start: ms(1000)}, {Fret: 1, Start: ms(1300)}, {Fret: 1, Start: ms(1600)}}
+
+    // pressing the fret plays the note, and strums are ignored
+    song := makeTestChart(chart)
+    song.FretStrum = true
+    playScripted(song, concatEvents(tap(InputActionGreen, ms(1000), ms(100)), tap(InputActionRed, ms(1300), ms(100)), tap(InputActionStrumDown, ms(1450), ms(30)), tap(InputActionRed, ms(1600), ms(100))), ms(2000))
+    if song.NotesHit != 3 || song.NotesMissed != 0 {
+        testing.Errorf("fret presses should hit every note, hit %v missed %v", song.NotesHit, song.NotesMissed)
+    }
+
+    // a tap with nothing to play breaks the streak like an overstrum
+    song = makeTestChart(chart)
+    song.FretStrum = true
+    playScripted(song, concatEvents(tap(InputActionGreen, ms(1000), ms(100)), tap(InputActionYellow, ms(1150), ms(50))), ms(1200))
+    if song.NotesHit != 1 || song.Streak != 0 {
+        testing.Errorf("a stray tap should reset the streak, hit %v streak %v", song.NotesHit, song.Streak)
+    }
+
+    // without the mode a fret press alone does nothing
+    song = makeTestChart(chart)
+    playScripted(song, concatEvents(tap(InputActionGreen, ms(1000), ms(100)), tap(InputActionRed, ms(1300), ms(100)), tap(InputActionRed, ms(1600), ms(100))), ms(2000))
+    if song.NotesHit != 0 {
+        testing.Errorf("fret presses should need a strum, hit %v", song.NotesHit)
+    }
+
+    keyboard := KeyboardLayouts[len(KeyboardLayouts) - 1].Profile
+    if keyboard.Layout() != len(KeyboardLayouts) - 1 || !keyboard.FretStrum {
+        testing.Errorf("the tap only layout should press frets to strum")
+    }
+
+    keyboard.GreenButton = ebiten.KeyZ
+    if keyboard.Layout() != -1 {
+        testing.Errorf("a changed key should not match a layout")
+    }
+}
+
+func TestKeyboardLayoutKeys(testing *testing.T) {
+    for _, layout := range KeyboardLayouts {
+        for _, action := range GameActions {
+            key := layout.Profile.GetInput(action)
+            if slices.Contains(GlobalKeys, key) {
+                testing.Errorf("layout %v binds %v to %v, which is already used by the game", layout.Name, action, key)
+            }
+        }
+    }
+}
+
+func TestMidiTap(testing *testing.T) {
+    // a guitar strums the green note while a drum pad taps the red one. the guitar's fret press on
+    // yellow is not a tap, so that note still needs a strum
+    song := makeTestChart([]testNote{{Fret: 0, Start: ms(1000)}, {Fret: 1, Start: ms(1300)}, {Fret: 2, Start: ms(1600)}})
+    events := []InputEvent{
+        {Action: InputActionGreen, Pressed: true, Time: testStart.Add(ms(900))},
+        {Action: InputActionStrumDown, Pressed: true, Time: testStart.Add(ms(1000))},
+        {Action: InputActionStrumDown, Pressed: false, Time: testStart.Add(ms(1030))},
+        {Action: InputActionGreen, Pressed: false, Time: testStart.Add(ms(1100))},
+        {Action: InputActionRed, Pressed: true, Time: testStart.Add(ms(1300)), Tap: true},
+        {Action: InputActionRed, Pressed: false, Time: testStart.Add(ms(1350))},
+        {Action: InputActionYellow, Pressed: true, Time: testStart.Add(ms(1600))},
+        {Action: InputActionYellow, Pressed: false, Time: testStart.Add(ms(1700))},
+    }
+
+    var flames testFlames
+    tick := time.Second / 120
+    for now := time.Duration(0); now <= ms(2000); now += tick {
+        var due []InputEvent
+        for len(events) > 0 && !events[0].Time.After(testStart.Add(now)) {
+            due = append(due, events[0])
+            events = events[1:]
+        }
+        song.UpdateAt(testStart.Add(now), due, &flames)
+    }
+
+    if song.Frets[0].Notes[0].State != NoteStateHit || song.Frets[1].Notes[0].State != NoteStateHit {
+        testing.Errorf("the strum and the tap should both hit, hit %v", song.NotesHit)
+    }
+
+    if song.Frets[2].Notes[0].State != NoteStateMissed {
+        testing.Errorf("a guitar fret press without a strum should not play the note")
+    }
+}
+
+func TestScriptedInput(testing *testing.T) {
+    input := NewScriptedInput([]InputEvent{
+        {Action: InputActionRed, Pressed: false, Time: testStart.Add(ms(40))},
+        {Action: InputActionRed, Pressed: true, Time: testStart.Add(ms(10))},
+    })
+
+    input.Advance(testStart.Add(ms(20)))
+    if !input.IsJustPressed(InputActionRed) || !input.IsHeld(InputActionRed) {
+        testing.Errorf("red should be pressed")
+    }
+
+    if !input.ChangeTime(InputActionRed).Equal(testStart.Add(ms(10))) {
+        testing.Errorf("press time should come from the script but was %v", input.ChangeTime(InputActionRed))
+    }
+
+    input.Advance(testStart.Add(ms(30)))
+    if input.IsJustPressed(InputActionRed) || !input.IsHeld(InputActionRed) {
+        testing.Errorf("red should still be held but not just pressed")
+    }
+
+    input.Advance(testStart.Add(ms(50)))
+    if !input.IsJustReleased(InputActionRed) || input.IsHeld(InputActionRed) || !input.Done() {
+        testing.Errorf("red should be released")
+    }
+}
+
+func TestStarPower(testing *testing.T) {
+    // bending a long sustain fills the meter
+    song := makeTestChart([]testNote{{Fret: 0, Start: ms(1000), Length: ms(8000)}})
+    song.Whammy = 1
+    playScripted(song, concatEvents(tap(InputActionGreen, ms(900), ms(8500)), tap(InputActionStrumDown, ms(1000), ms(30))), ms(9500))
+
+    if song.StarPower < StarPowerMinimum {
+        testing.Fatalf("whammy on a sustain should fill the meter, got %v", song.StarPower)
+    }
+
+    // tilting releases it and doubles the points for the next note
+    chart := []testNote{{Fret: 0, Start: ms(1000)}}
+    events := concatEvents(tap(InputActionTilt, ms(500), ms(100)), tap(InputActionGreen, ms(900), ms(300)), tap(InputActionStrumDown, ms(1000), ms(30)))
+
+    song = makeTestChart(chart)
+    song.StarPower = StarPowerMinimum
+    playScripted(song, events, ms(2000))
+    if song.Score != JudgementPerfect.Score() * StarPowerMultiplier {
+        testing.Errorf("star power should multiply the score, got %v", song.Score)
+    }
+    if !song.StarPowerActive || song.StarPower >= StarPowerMinimum {
+        testing.Errorf("star power should be active and draining, meter %v", song.StarPower)
+    }
+
+    // an empty meter can't be activated
+    song = makeTestChart(chart)
+    playScripted(song, events, ms(2000))
+    if song.StarPowerActive || song.Score != JudgementPerfect.Score() {
+        testing.Errorf("star power should not activate without a full enough meter, score %v", song.Score)
+    }
+}
+
+func TestPauseResume(testing *testing.T) {
+    song := makeTestChart([]testNote{{Fret: 0, Start: ms(1000)}})
+
+    var flames testFlames
+    song.UpdateAt(testStart.Add(ms(500)), nil, &flames)
+
+    // paused for ten seconds just before the note, which would otherwise have gone by
+    song.Pause(testStart.Add(ms(900)))
+    song.Resume(testStart.Add(ms(10900)))
+
+    var queue InputQueue
+    queue.Push(InputEvent{Action: InputActionGreen, Pressed: true, Time: testStart.Add(ms(10950))})
+    queue.Push(InputEvent{Action: InputActionStrumDown, Pressed: true, Time: testStart.Add(ms(11000))})
+    song.UpdateAt(testStart.Add(ms(11010)), queue.Drain(), &flames)
+    song.UpdateAt(testStart.Add(ms(12000)), nil, &flames)
+
+    if song.NotesHit != 1 || song.NotesMissed != 0 {
+        testing.Errorf("the note should be played after resuming, hit %v missed %v", song.NotesHit, song.NotesMissed)
+    }
+}
+
+func TestInputDebug(testing *testing.T) {
+    song := makeTestChart([]testNote{{Fret: 0, Start: ms(1000)}, {Fret: 0, Start: ms(1500)}, {Fret: 1, Start: ms(2000)}, {Fret: 2, Start: ms(3000)}})
+    song.Debug = &InputDebug{}
+
+    events := concatEvents(
+        // 20ms late
+        tap(InputActionGreen, ms(900), ms(800)), tap(InputActionStrumDown, ms(1020), ms(30)),
+        // 40ms early
+        tap(InputActionStrumDown, ms(1460), ms(30)),
+        // red is played along with yellow, which has nothing to play
+        tap(InputActionRed, ms(1900), ms(200)), tap(InputActionYellow, ms(1900), ms(200)), tap(InputActionStrumDown, ms(2000), ms(30)),
+        // strummed well before the yellow note
+        tap(InputActionYellow, ms(2400), ms(100)), tap(InputActionStrumDown, ms(2450), ms(30)),
+    )
+    playScripted(song, events, ms(4000))
+
+    var kinds []DebugResultKind
+    for _, result := range song.Debug.Results {
+        kinds = append(kinds, result.Kind)
+    }
+
+    expected := []DebugResultKind{DebugResultHit, DebugResultHit, DebugResultWrongFret, DebugResultOverstrum, DebugResultNotPlayed}
+    if !slices.Equal(kinds, expected) {
+        testing.Fatalf("expected results %v but got %v", expected, kinds)
+    }
+
+    overstrum := song.Debug.Results[3]
+    if overstrum.Fret != 2 || overstrum.Offset != -ms(550) {
+        testing.Errorf("the overstrum should be measured against the yellow note, was fret %v at %v", overstrum.Fret, overstrum.Offset)
+    }
+
+    mean, deviation := song.Debug.OffsetStats()
+    if mean != -ms(10) || deviation != ms(30) {
+        testing.Errorf("expected a mean of -10ms and deviation of 30ms but got %v and %v", mean, deviation)
+    }
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+    "time"
+)
+
+// star power is built up by bending sustains with the whammy bar and released by tilting the
+// guitar. while it is active every note is worth more
+
+// how far the whammy has to be pushed to count as being used
+const WhammyThreshold = 0.15
+
+// how much of the meter one second of a whammied sustain fills
+const StarPowerFillRate = 0.08
+
+// the meter has to be at least this full to be activated
+const StarPowerMinimum = 0.5
+
+// how much of the meter drains each second while star power is active
+const StarPowerDrainRate = 0.1
+
+const StarPowerMultiplier = 2
+
+// points for hitting a note, or for a tick of a sustain, are multiplied by this
+func (song *Song) scoreMultiplier() int {
+    if song.StarPowerActive {
+        return StarPowerMultiplier
+    }
+    return 1
+}
+
+func (song *Song) activateStarPower() {
+    if song.StarPowerActive || song.StarPower < StarPowerMinimum {
+        return
+    }
+
+    song.StarPowerActive = true
+    song.Effects.Play(SoundEffectStarPower)
+}
+
+// advance the meter by the time since the last update
+func (song *Song) updateStarPower(elapsed time.Duration, whammied bool) {
+    if whammied {
+        song.StarPower = min(1, song.StarPower + StarPowerFillRate * elapsed.Seconds())
+    }
+
+    if song.StarPowerActive {
+        song.StarPower -= StarPowerDrainRate * elapsed.Seconds()
+        if song.StarPower <= 0 {
+            song.StarPower = 0
+            song.StarPowerActive = false
+        }
+    }
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+    "slices"
+    "strings"
+    "path/filepath"
+)
+
+// the standard audio file names used by rock band and clone hero songs
+type Stem int
+const (
+    StemUnknown Stem = iota
+    StemSong
+    StemGuitar
+    StemRhythm
+    StemBass
+    StemKeys
+    StemVocals
+    StemVocals1
+    StemVocals2
+    StemDrums
+    StemDrums1
+    StemDrums2
+    StemDrums3
+    StemDrums4
+    StemCrowd
+    StemPreview
+)
+
+var stemNames = map[Stem]string{
+    StemSong: "song",
+    StemGuitar: "guitar",
+    StemRhythm: "rhythm",
+    StemBass: "bass",
+    StemKeys: "keys",
+    StemVocals: "vocals",
+    StemVocals1: "vocals_1",
+    StemVocals2: "vocals_2",
+    StemDrums: "drums",
+    StemDrums1: "drums_1",
+    StemDrums2: "drums_2",
+    StemDrums3: "drums_3",
+    StemDrums4: "drums_4",
+    StemCrowd: "crowd",
+    StemPreview: "preview",
+}
+
+func (stem Stem) String() string {
+    name, ok := stemNames[stem]
+    if ok {
+        return name
+    }
+
+    return "unknown"
+}
+
+// the stem for an audio file, based on its name without the extension
+func ParseStem(path string) Stem {
+    base := strings.ToLower(filepath.Base(path))
+    base = strings.TrimSuffix(base, filepath.Ext(base))
+
+    for stem, name := range stemNames {
+        if name == base {
+            return stem
+        }
+    }
+
+    return StemUnknown
+}
+
+// the part of the chart being played
+type Instrument int
+const (
+    InstrumentGuitar Instrument = iota
+    InstrumentRhythm
+    InstrumentBass
+    InstrumentKeys
+    InstrumentDrums
+    InstrumentVocals
+)
+
+func (instrument Instrument) String() string {
+    switch instrument {
+        case InstrumentGuitar: return "guitar"
+        case InstrumentRhythm: return "rhythm"
+        case InstrumentBass: return "bass"
+        case InstrumentKeys: return "keys"
+        case InstrumentDrums: return "drums"
+        case InstrumentVocals: return "vocals"
+    }
+
+    return "unknown"
+}
+
+// the stems that are quieted when the player misses a note on this instrument. if a song doesn't
+// have any of these stems then the instrument is mixed into the song stem and nothing is ducked
+func (instrument Instrument) DuckStems() []Stem {
+    switch instrument {
+        case InstrumentGuitar: return []Stem{StemGuitar}
+        case InstrumentRhythm: return []Stem{StemRhythm}
+        case InstrumentBass: return []Stem{StemBass}
+        case InstrumentKeys: return []Stem{StemKeys}
+        case InstrumentDrums: return []Stem{StemDrums, StemDrums1, StemDrums2, StemDrums3, StemDrums4}
+        case InstrumentVocals: return []Stem{StemVocals, StemVocals1, StemVocals2}
+    }
+
+    return nil
+}
+
+func (instrument Instrument) Ducks(stem Stem) bool {
+    return slices.Contains(instrument.DuckStems(), stem)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+    "time"
+    "image/color"
+)
+
+// how accurately a note was hit
+type Judgement int
+const (
+    JudgementPerfect Judgement = iota
+    JudgementGreat
+    JudgementGood
+)
+
+var Judgements = []Judgement{JudgementPerfect, JudgementGreat, JudgementGood}
+
+func (judgement Judgement) String() string {
+    switch judgement {
+        case JudgementPerfect: return "Perfect"
+        case JudgementGreat: return "Great"
+        case JudgementGood: return "Good"
+    }
+
+    return "Unknown"
+}
+
+// points awarded for hitting a note with this judgement
+func (judgement Judgement) Score() int {
+    switch judgement {
+        case JudgementPerfect: return 5
+        case JudgementGreat: return 4
+        case JudgementGood: return 2
+    }
+
+    return 0
+}
+
+func (judgement Judgement) Color() color.Color {
+    switch judgement {
+        case JudgementPerfect: return color.NRGBA{R: 255, G: 215, B: 0, A: 255}
+        case JudgementGreat: return color.NRGBA{R: 80, G: 230, B: 80, A: 255}
+        case JudgementGood: return color.NRGBA{R: 100, G: 170, B: 255, A: 255}
+    }
+
+    return color.White
+}
+
+// the offsets from a note's start time that count as hitting it. offsets are positive when the
+// player is late and negative when early
+type TimingWindows struct {
+    // how far before the note it can be hit
+    Early time.Duration
+    // how far after the note it can be hit
+    Late time.Duration
+    // hits within these distances of the note, early or late, get the better judgements
+    Perfect time.Duration
+    Great time.Duration
+}
+
+// the windows that were used for every difficulty before they were configurable
+func DefaultTimingWindows() TimingWindows {
+    return TimingWindows{
+        Early: 250 * time.Millisecond,
+        Late: 150 * time.Millisecond,
+        Perfect: 50 * time.Millisecond,
+        Great: 110 * time.Millisecond,
+    }
+}
+
+// harder difficulties need more accurate hits
+func TimingWindowsForDifficulty(difficulty string) TimingWindows {
+    switch difficulty {
+        case "easy": return DefaultTimingWindows()
+        case "medium":
+            return TimingWindows{
+                Early: 220 * time.Millisecond,
+                Late: 140 * time.Millisecond,
+                Perfect: 45 * time.Millisecond,
+                Great: 100 * time.Millisecond,
+            }
+        case "hard":
+            return TimingWindows{
+                Early: 190 * time.Millisecond,
+                Late: 130 * time.Millisecond,
+                Perfect: 40 * time.Millisecond,
+                Great: 90 * time.Millisecond,
+            }
+        case "expert":
+            return TimingWindows{
+                Early: 160 * time.Millisecond,
+                Late: 120 * time.Millisecond,
+                Perfect: 35 * time.Millisecond,
+                Great: 80 * time.Millisecond,
+            }
+    }
+
+    return DefaultTimingWindows()
+}
+
+// true if a note at this offset can still be hit
+func (windows TimingWindows) Contains(offset time.Duration) bool {
+    return offset >= -windows.Early && offset <= windows.Late
+}
+
+func absDuration(value time.Duration) time.Duration {
+    if value < 0 {
+        return -value
+    }
+    return value
+}
+
+func (windows TimingWindows) Judge(offset time.Duration) Judgement {
+    offset = absDuration(offset)
+
+    switch {
+        case offset <= windows.Perfect: return JudgementPerfect
+        case offset <= windows.Great: return JudgementGreat
+        default: return JudgementGood
+    }
+}
+
+// how long a judgement stays on screen
+const JudgementDisplayTime = 600 * time.Millisecond
+
+// shown on the highway after a hit
+type JudgementPopup struct {
+    Judgement Judgement
+    Offset time.Duration
+    Time time.Time
+}
+
+// true if the hit was far enough off to tell the player which way they missed the perfect window
+func (popup *JudgementPopup) ShowEarlyLate() bool {
+    return popup.Judgement != JudgementPerfect && popup.Offset != 0
+}
+
+// with alternate strumming on, a strum this long after the previous one can go either way
+const AlternateStrumReset = 400 * time.Millisecond
@@ -6,11 +6,13 @@ import (
     "os"
     "fmt"
     "time"
+    "math"
     "strings"
     "image/color"
     "math/rand/v2"
     "path/filepath"
     "context"
+    "log"
 
     "github.com/kazzmir/rhythm/lib/coroutine"
     "github.com/kazzmir/rhythm/lib/colorconv"
@@ -67,12 +69,96 @@ func makeButton(text string, tface text.Face, maxWidth int, onClick func(args *w
     )
 }
 
-func chooseSong(yield coroutine.YieldFunc, engine *Engine, background *Background, face *text.GoTextFace) string {
+func makeArrowButton(tface text.Face, arrow *widget.GraphicImage, onClick func(args *widget.ButtonClickedEventArgs)) *widget.Button {
+    baseColor := color.NRGBA{R: 100, G: 160, B: 210, A: 255}
+    borderColor := color.NRGBA{R: 250, G: 250, B: 250, A: 100}
+    alpha := 120
+
+    return widget.NewButton(
+        widget.ButtonOpts.Image(&widget.ButtonImage{
+            Idle: ui_image.NewBorderedNineSliceColor(translucent(darkenColor(baseColor, 0.4), alpha), borderColor, 1),
+            Hover: ui_image.NewBorderedNineSliceColor(translucent(baseColor, alpha), borderColor, 1),
+            Pressed: ui_image.NewBorderedNineSliceColor(translucent(brightenColor(baseColor, 0.4), alpha), borderColor, 1),
+        }),
+        widget.ButtonOpts.TextAndImage("", &tface, arrow, &widget.ButtonTextColor{
+            Idle: color.White,
+            Hover: color.White,
+            Pressed: color.White,
+            Disabled: color.Gray{Y: 128},
+        }),
+        widget.ButtonOpts.TextPadding(&widget.Insets{Top: 2, Bottom: 2, Left: 5, Right: 5}),
+        widget.ButtonOpts.ClickedHandler(onClick),
+    )
+}
+
+func makeArrowImages(tface text.Face) (widget.GraphicImage, widget.GraphicImage) {
+    _, textHeight := text.Measure("A", tface, 0)
+
+    leftArrowImage := makeLeftArrow(int(textHeight), int(textHeight), color.White)
+    leftArrow := widget.GraphicImage{
+        Idle: leftArrowImage,
+        Disabled: leftArrowImage,
+        Pressed: leftArrowImage,
+        Hover: leftArrowImage,
+    }
+
+    rightArrowImage := makeRightArrow(int(textHeight), int(textHeight), color.White)
+    rightArrow := widget.GraphicImage{
+        Idle: rightArrowImage,
+        Disabled: rightArrowImage,
+        Pressed: rightArrowImage,
+        Hover: rightArrowImage,
+    }
+
+    return leftArrow, rightArrow
+}
+
+func makeTextInput(tface text.Face, placeholder string, maxWidth int, onSubmit func(text string)) *widget.TextInput {
+    borderColor := color.NRGBA{R: 250, G: 250, B: 250, A: 100}
+
+    return widget.NewTextInput(
+        widget.TextInputOpts.WidgetOpts(
+            widget.WidgetOpts.LayoutData(widget.GridLayoutData{
+                MaxWidth: maxWidth,
+            }),
+            widget.WidgetOpts.MinSize(maxWidth, 0),
+        ),
+        widget.TextInputOpts.Image(&widget.TextInputImage{
+            Idle: ui_image.NewBorderedNineSliceColor(color.NRGBA{R: 30, G: 30, B: 30, A: 200}, borderColor, 1),
+            Disabled: ui_image.NewBorderedNineSliceColor(color.NRGBA{R: 80, G: 80, B: 80, A: 200}, borderColor, 1),
+        }),
+        widget.TextInputOpts.Face(&tface),
+        widget.TextInputOpts.Color(&widget.TextInputColor{
+            Idle: color.White,
+            Disabled: color.Gray{Y: 128},
+            Caret: color.White,
+            DisabledCaret: color.Gray{Y: 128},
+        }),
+        widget.TextInputOpts.Padding(&widget.Insets{Top: 2, Bottom: 2, Left: 5, Right: 5}),
+        widget.TextInputOpts.Placeholder(placeholder),
+        widget.TextInputOpts.IgnoreEmptySubmit(true),
+        widget.TextInputOpts.SubmitHandler(func (args *widget.TextInputChangedEventArgs) {
+            onSubmit(strings.TrimSpace(args.InputText))
+        }),
+    )
+}
+
+type SongSelection struct {
+    // the song to play, or the first song of the setlist
+    Song string
+    // if non-empty then every song in the list is played back to back
+    Setlist []string
+}
+
+func chooseSong(yield coroutine.YieldFunc, engine *Engine, background *Background, face *text.GoTextFace, playlists *PlaylistLibrary) SongSelection {
     chosen := false
 
     var tface text.Face = face
 
     song := ""
+    var setlist []string
+
+    var ui ebitenui.UI
 
     rootContainer := widget.NewContainer(
         widget.ContainerOpts.Layout(widget.NewRowLayout(
@@ -82,7 +168,10 @@ func chooseSong(yield coroutine.YieldFunc, engine *Engine, background *Backgroun
         )),
     )
 
-    songPaths := scanSongs(".", 0)
+    var songPaths []string
+    for _, path := range engine.Configuration.Settings.Library.SongPaths {
+        songPaths = append(songPaths, scanSongs(path, 0)...)
+    }
 
     songPaths = slices.SortedFunc(slices.Values(songPaths), func(a, b string) int {
         ax := filepath.Base(strings.ToLower(a))
@@ -90,6 +179,53 @@ func chooseSong(yield coroutine.YieldFunc, engine *Engine, background *Backgroun
         return cmp.Compare(ax, bx)
     })
 
+    available := make(map[string]bool)
+    for _, songPath := range songPaths {
+        available[songPath] = true
+    }
+
+    // view 0 shows every song, view 1 shows the favorites, and the rest are the user's playlists
+    viewIndex := 0
+
+    viewCount := func() int {
+        return len(playlists.Playlists) + 2
+    }
+
+    viewPlaylist := func() *Playlist {
+        switch {
+            case viewIndex == 1: return &playlists.Favorites
+            case viewIndex > 1 && viewIndex - 2 < len(playlists.Playlists): return playlists.Playlists[viewIndex - 2]
+        }
+
+        return nil
+    }
+
+    viewName := func() string {
+        playlist := viewPlaylist()
+        if playlist == nil {
+            return "All Songs"
+        }
+
+        return playlist.Name
+    }
+
+    // the songs in the current view that still exist on disk, in playlist order
+    viewSongs := func() []string {
+        playlist := viewPlaylist()
+        if playlist == nil {
+            return songPaths
+        }
+
+        var out []string
+        for _, songPath := range playlist.Songs {
+            if available[songPath] {
+                out = append(out, songPath)
+            }
+        }
+
+        return out
+    }
+
     songContainer := widget.NewContainer(
         widget.ContainerOpts.Layout(widget.NewRowLayout(
             widget.RowLayoutOpts.Direction(widget.DirectionHorizontal),
@@ -124,7 +260,7 @@ func chooseSong(yield coroutine.YieldFunc, engine *Engine, background *Backgroun
         widget.ListOpts.HideHorizontalSlider(),
         widget.ListOpts.ContainerOpts(widget.ContainerOpts.WidgetOpts(
             widget.WidgetOpts.LayoutData(widget.RowLayoutData{
-                MaxHeight: ScreenHeight - 20,
+                MaxHeight: ScreenHeight - 120,
             }),
             widget.WidgetOpts.MinSize(0, 200),
         )),
@@ -133,11 +269,19 @@ func chooseSong(yield coroutine.YieldFunc, engine *Engine, background *Backgroun
         widget.ListOpts.EntryLabelFunc(
             func (e any) string {
                 name := e.(string)
+                if playlists.IsFavorite(name) {
+                    return "* " + filepath.Base(name)
+                }
                 return filepath.Base(name)
             },
         ),
         widget.ListOpts.EntrySelectedHandler(func (args *widget.ListEntrySelectedEventArgs) {
             entry := args.Entry.(string)
+            // the list re-selects the current song after being refreshed, so don't restart the preview
+            if entry == song {
+                return
+            }
+
             song = entry
 
             newImage := loadAlbumImage(os.DirFS(song))
@@ -152,6 +296,7 @@ func chooseSong(yield coroutine.YieldFunc, engine *Engine, background *Backgroun
             playSongQuit, playSongCancel = context.WithCancel(mainQuit)
 
             localQuit := playSongQuit
+            previewPath := song
             go func() {
                 select {
                     case <-time.After(200 * time.Millisecond):
@@ -159,23 +304,7 @@ func chooseSong(yield coroutine.YieldFunc, engine *Engine, background *Backgroun
                         return
                 }
 
-                parts, _, cleanups, err := loadSongParts(engine.AudioContext, os.DirFS(song))
-                if err == nil {
-                    for _, part := range parts {
-                        part.Player.Play()
-                    }
-
-                    select {
-                        case <-localQuit.Done():
-                            for _, part := range parts {
-                                part.Player.Pause()
-                            }
-
-                            for _, cleanup := range cleanups {
-                                cleanup()
-                            }
-                    }
-                }
+                playPreview(localQuit, engine.AudioContext, engine.Mixer, os.DirFS(previewPath))
             }()
         }),
         widget.ListOpts.EntryColor(&widget.ListEntryColor{
@@ -189,35 +318,194 @@ func chooseSong(yield coroutine.YieldFunc, engine *Engine, background *Backgroun
         }),
     )
 
-    for _, songPath := range songPaths {
-        songList.AddEntry(songPath)
+    viewLabel := widget.NewLabel(
+        widget.LabelOpts.Text(viewName(), &tface, &widget.LabelColor{
+            Idle: color.White,
+            Disabled: color.Gray{Y: 128},
+        }),
+    )
+
+    // rebuild the list entries, keeping the current song selected if it is still in the view
+    refreshList := func() {
+        viewLabel.Label = viewName()
+
+        current := viewSongs()
+        entries := make([]any, 0, len(current))
+        for _, songPath := range current {
+            entries = append(entries, songPath)
+        }
+        songList.SetEntries(entries)
+
+        if slices.Contains(current, song) {
+            songList.SetSelectedEntry(song)
+        }
+
+        songList.Focus(true)
     }
 
-    /*
-    playButton := makeButton("Play Selected Song", tface, 200, func (args *widget.ButtonClickedEventArgs) {
+    changeView := func(direction int) {
+        viewIndex = (viewIndex + direction + viewCount()) % viewCount()
+        refreshList()
+    }
+
+    savePlaylists := func() {
+        err := engine.Configuration.SavePlaylists(playlists)
+        if err != nil {
+            log.Printf("Unable to save playlists: %v", err)
+        }
+    }
+
+    toggleFavorite := func() {
         if song != "" {
-            chosen = true
+            playlists.ToggleFavorite(song)
+            savePlaylists()
+            refreshList()
         }
-    })
-    */
+    }
+
+    removeFromPlaylist := func() {
+        playlist := viewPlaylist()
+        if playlist != nil && song != "" {
+            playlist.Remove(song)
+            savePlaylists()
+            refreshList()
+        }
+    }
+
+    startSetlist := func() {
+        if viewPlaylist() != nil {
+            songs := viewSongs()
+            if len(songs) > 0 {
+                song = songs[0]
+                setlist = songs
+                chosen = true
+            }
+        }
+    }
+
+    // true while the playlist picker is shown instead of the song list
+    pickingPlaylist := false
+    // focused once the picker has been shown for a tick, so the key that opened the picker isn't
+    // typed into it
+    var focusInput *widget.TextInput
+
+    var showPlaylistPicker func()
+    showPlaylistPicker = func() {
+        if song == "" {
+            return
+        }
+
+        pickingPlaylist = true
+
+        picker := widget.NewContainer(
+            widget.ContainerOpts.Layout(widget.NewGridLayout(
+                widget.GridLayoutOpts.Columns(1),
+                widget.GridLayoutOpts.DefaultStretch(true, false),
+                widget.GridLayoutOpts.Spacing(0, 10),
+                widget.GridLayoutOpts.Padding(&widget.Insets{Top: 80, Left: 20, Right: 10, Bottom: 10}),
+            )),
+        )
+
+        closePicker := func() {
+            pickingPlaylist = false
+            ui.Container = rootContainer
+            refreshList()
+        }
+
+        picker.AddChild(widget.NewLabel(
+            widget.LabelOpts.Text(fmt.Sprintf("Add '%v' to playlist", filepath.Base(song)), &tface, &widget.LabelColor{
+                Idle: color.White,
+                Disabled: color.Gray{Y: 128},
+            }),
+        ))
+
+        for _, playlist := range playlists.Playlists {
+            label := playlist.Name
+            if playlist.Contains(song) {
+                label = fmt.Sprintf("%v (already added)", playlist.Name)
+            }
+
+            picker.AddChild(makeButton(label, tface, 400, func (args *widget.ButtonClickedEventArgs) {
+                playlist.Add(song)
+                savePlaylists()
+                closePicker()
+            }))
+        }
+
+        nameInput := makeTextInput(tface, "New playlist name", 400, func (name string) {
+            if name != "" {
+                playlists.AddPlaylist(name).Add(song)
+                savePlaylists()
+                closePicker()
+            }
+        })
+        picker.AddChild(nameInput)
+
+        picker.AddChild(makeButton("Cancel", tface, 400, func (args *widget.ButtonClickedEventArgs) {
+            closePicker()
+        }))
+
+        ui.Container = picker
+        focusInput = nameInput
+    }
+
+    leftArrow, rightArrow := makeArrowImages(tface)
+
+    viewBox := widget.NewContainer(
+        widget.ContainerOpts.Layout(widget.NewRowLayout(
+            widget.RowLayoutOpts.Direction(widget.DirectionHorizontal),
+            widget.RowLayoutOpts.Spacing(5),
+        )),
+    )
+
+    viewBox.AddChild(makeArrowButton(tface, &leftArrow, func (args *widget.ButtonClickedEventArgs) {
+        changeView(-1)
+    }))
+    viewBox.AddChild(viewLabel)
+    viewBox.AddChild(makeArrowButton(tface, &rightArrow, func (args *widget.ButtonClickedEventArgs) {
+        changeView(1)
+    }))
+
+    buttonBox := widget.NewContainer(
+        widget.ContainerOpts.Layout(widget.NewRowLayout(
+            widget.RowLayoutOpts.Direction(widget.DirectionHorizontal),
+            widget.RowLayoutOpts.Spacing(12),
+        )),
+    )
+
+    buttonBox.AddChild(makeButton("Favorite (F)", tface, 220, func (args *widget.ButtonClickedEventArgs) {
+        toggleFavorite()
+    }))
+
+    buttonBox.AddChild(makeButton("Add to Playlist (P)", tface, 280, func (args *widget.ButtonClickedEventArgs) {
+        showPlaylistPicker()
+    }))
+
+    buttonBox.AddChild(makeButton("Remove (Del)", tface, 220, func (args *widget.ButtonClickedEventArgs) {
+        removeFromPlaylist()
+    }))
+
+    buttonBox.AddChild(makeButton("Play Setlist (S)", tface, 240, func (args *widget.ButtonClickedEventArgs) {
+        startSetlist()
+    }))
 
     backButton := makeButton("Back", tface, 200, func (args *widget.ButtonClickedEventArgs) {
         song = ""
+        setlist = nil
         chosen = true
     })
+    buttonBox.AddChild(backButton)
 
     songContainer.AddChild(songList)
     songContainer.AddChild(albumGraphic)
 
+    rootContainer.AddChild(viewBox)
     rootContainer.AddChild(songContainer)
-    // rootContainer.AddChild(playButton)
-    rootContainer.AddChild(backButton)
+    rootContainer.AddChild(buttonBox)
 
-    songList.Focus(true)
+    refreshList()
 
-    ui := ebitenui.UI{
-        Container: rootContainer,
-    }
+    ui.Container = rootContainer
 
     engine.PushDrawer(func(screen *ebiten.Image) {
         background.Draw(screen)
@@ -242,6 +530,29 @@ func chooseSong(yield coroutine.YieldFunc, engine *Engine, background *Backgroun
 
     for !chosen {
 
+        if pickingPlaylist {
+            if inpututil.IsKeyJustPressed(ebiten.KeyEscape) || engine.HandleMenuInput(&ui) {
+                pickingPlaylist = false
+                focusInput = nil
+                ui.Container = rootContainer
+                refreshList()
+            }
+
+            if focusInput != nil {
+                focusInput.Focus(true)
+                focusInput = nil
+            }
+
+            background.Update()
+            ui.Update()
+
+            if yield() != nil {
+                return SongSelection{}
+            }
+
+            continue
+        }
+
         keys := inpututil.AppendPressedKeys(nil)
         for _, key := range keys {
             switch key {
@@ -265,10 +576,10 @@ func chooseSong(yield coroutine.YieldFunc, engine *Engine, background *Backgroun
         for _, key := range keys {
             switch key {
                 case ebiten.KeyEscape, ebiten.KeyCapsLock:
-                    return ""
+                    return SongSelection{}
                 case ebiten.KeyEnter:
                     if song != "" {
-                        return song
+                        return SongSelection{Song: song}
                     }
                 case ebiten.KeyDown:
                     songList.FocusNext()
@@ -284,6 +595,45 @@ func chooseSong(yield coroutine.YieldFunc, engine *Engine, background *Backgroun
                     for range 10 {
                         songList.FocusPrevious()
                     }
+                case ebiten.KeyLeft:
+                    changeView(-1)
+                case ebiten.KeyRight:
+                    changeView(1)
+                case ebiten.KeyF:
+                    toggleFavorite()
+                case ebiten.KeyP:
+                    showPlaylistPicker()
+                case ebiten.KeyDelete:
+                    removeFromPlaylist()
+                case ebiten.KeyS:
+                    startSetlist()
+            }
+        }
+
+        for _, action := range engine.MenuActions() {
+            switch action {
+                case MenuActionBack:
+                    return SongSelection{}
+                case MenuActionConfirm:
+                    if song != "" {
+                        return SongSelection{Song: song}
+                    }
+                case MenuActionDown:
+                    songList.FocusNext()
+                case MenuActionUp:
+                    songList.FocusPrevious()
+                case MenuActionPageDown:
+                    for range 10 {
+                        songList.FocusNext()
+                    }
+                case MenuActionPageUp:
+                    for range 10 {
+                        songList.FocusPrevious()
+                    }
+                case MenuActionLeft:
+                    changeView(-1)
+                case MenuActionRight:
+                    changeView(1)
             }
         }
 
@@ -303,11 +653,11 @@ func chooseSong(yield coroutine.YieldFunc, engine *Engine, background *Backgroun
         ui.Update()
 
         if yield() != nil {
-            return ""
+            return SongSelection{}
         }
     }
 
-    return song
+    return SongSelection{Song: song, Setlist: setlist}
 }
 
 type ColorHSV struct {
@@ -614,6 +964,91 @@ func waitForGamepadInput(yield coroutine.YieldFunc, gamepadId ebiten.GamepadID,
     return ebiten.GamepadButton(-1)
 }
 
+// find which axis the player moves and the values it reports at rest and when moved all the way.
+// the rest values are taken when the screen opens, so the control should be left alone until then
+func calibrateAxis(yield coroutine.YieldFunc, gamepadId ebiten.GamepadID, instructions string, face text.Face, drawManager DrawManager) (AxisBinding, bool) {
+    axes := ebiten.GamepadAxisCount(gamepadId)
+    rest := make([]float64, axes)
+    extreme := make([]float64, axes)
+    for axis := range axes {
+        rest[axis] = ebiten.GamepadAxisValue(gamepadId, axis)
+        extreme[axis] = rest[axis]
+    }
+
+    // the axis that has moved the furthest from rest
+    moved := func() int {
+        best := -1
+        distance := 0.0
+        for axis := range axes {
+            if math.Abs(extreme[axis] - rest[axis]) > distance {
+                best = axis
+                distance = math.Abs(extreme[axis] - rest[axis])
+            }
+        }
+        return best
+    }
+
+    // the axis has to move at least this far to be chosen
+    minimumMove := 0.4
+
+    previousDrawer := drawManager.LastDrawer()
+
+    drawManager.PushDrawer(func(screen *ebiten.Image) {
+        previousDrawer(screen)
+
+        x := float32(400)
+        y := float32(300)
+        width := float32(600)
+        height := float32(300)
+
+        vector.FillRect(screen, x, y, width, height, color.NRGBA{R: 0, G: 0, B: 0, A: 200}, true)
+        vector.StrokeRect(screen, x, y, width, height, 1, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, true)
+        var textOptions text.DrawOptions
+        textOptions.GeoM.Translate(float64(x + 10), float64(y + 2))
+        text.Draw(screen, instructions, face, &textOptions)
+        textOptions.GeoM.Translate(0, 30)
+        text.Draw(screen, "then let it go. Escape to cancel", face, &textOptions)
+
+        axis := moved()
+        if axis != -1 {
+            binding := AxisBinding{Axis: axis, Rest: rest[axis], Max: extreme[axis]}
+            textOptions.GeoM.Translate(0, 30)
+            text.Draw(screen, fmt.Sprintf("Axis %v: %0.2f to %0.2f", axis, rest[axis], extreme[axis]), face, &textOptions)
+
+            ax, ay := textOptions.GeoM.Apply(0, 40)
+            vector.StrokeRect(screen, float32(ax), float32(ay), 200, 10, 1, color.White, true)
+            vector.FillRect(screen, float32(ax), float32(ay), float32(200 * binding.Value(gamepadId)), 10, color.NRGBA{R: 0, G: 200, B: 255, A: 255}, true)
+        }
+    })
+    defer drawManager.PopDrawer()
+
+    for {
+        if yield() != nil {
+            return AxisBinding{}, false
+        }
+
+        if inpututil.IsKeyJustPressed(ebiten.KeyEscape) || inpututil.IsKeyJustPressed(ebiten.KeyCapsLock) {
+            yield()
+            return AxisBinding{}, false
+        }
+
+        for axis := range axes {
+            value := ebiten.GamepadAxisValue(gamepadId, axis)
+            if math.Abs(value - rest[axis]) > math.Abs(extreme[axis] - rest[axis]) {
+                extreme[axis] = value
+            }
+        }
+
+        // done once the axis has been moved far enough and has come back to rest
+        axis := moved()
+        if axis != -1 && math.Abs(extreme[axis] - rest[axis]) >= minimumMove {
+            if math.Abs(ebiten.GamepadAxisValue(gamepadId, axis) - rest[axis]) < 0.1 {
+                return AxisBinding{Axis: axis, Rest: rest[axis], Max: extreme[axis]}, true
+            }
+        }
+    }
+}
+
 func makeLeftArrow(width, height int, col color.Color) *ebiten.Image {
     out := ebiten.NewImage(width, height)
 
@@ -642,7 +1077,7 @@ func makeRightArrow(width, height int, col color.Color) *ebiten.Image {
     return out
 }
 
-func makeInputMenu(yield coroutine.YieldFunc, tface text.Face, drawManager DrawManager, inputProfile *InputProfile, configuration *ConfigurationManager) *widget.Container {
+func makeInputMenu(yield coroutine.YieldFunc, tface text.Face, drawManager DrawManager, inputProfile *InputProfile, save func()) *widget.Container {
     _, textHeight := text.Measure("A", tface, 0)
 
     container := widget.NewContainer(
@@ -672,23 +1107,7 @@ func makeInputMenu(yield coroutine.YieldFunc, tface text.Face, drawManager DrawM
         inputs = append(inputs, ebiten.GamepadName(gamepad))
     }
 
-    // leftArrowImage := ebiten.NewImage(int(textHeight), int(textHeight))
-    // leftArrowImage.Fill(color.White)
-    leftArrowImage := makeLeftArrow(int(textHeight), int(textHeight), color.White)
-    leftArrow := widget.GraphicImage{
-        Idle: leftArrowImage,
-        Disabled: leftArrowImage,
-        Pressed: leftArrowImage,
-        Hover: leftArrowImage,
-    }
-
-    rightArrowImage := makeRightArrow(int(textHeight), int(textHeight), color.White)
-    rightArrow := widget.GraphicImage{
-        Idle: rightArrowImage,
-        Disabled: rightArrowImage,
-        Pressed: rightArrowImage,
-        Hover: rightArrowImage,
-    }
+    leftArrow, rightArrow := makeArrowImages(tface)
 
     var setupButtons func(inputIndex int)
     setupButtons = func(inputIndex int) {
@@ -719,28 +1138,10 @@ func makeInputMenu(yield coroutine.YieldFunc, tface text.Face, drawManager DrawM
             )),
         )
 
-        baseColor := color.NRGBA{R: 100, G: 160, B: 210, A: 255}
-        borderColor := color.NRGBA{R: 250, G: 250, B: 250, A: 100}
-        alpha := 120
-
         // previous button
-        inputBox.AddChild(widget.NewButton(
-            widget.ButtonOpts.Image(&widget.ButtonImage{
-                Idle: ui_image.NewBorderedNineSliceColor(translucent(darkenColor(baseColor, 0.4), alpha), borderColor, 1),
-                Hover: ui_image.NewBorderedNineSliceColor(translucent(baseColor, alpha), borderColor, 1),
-                Pressed: ui_image.NewBorderedNineSliceColor(translucent(brightenColor(baseColor, 0.4), alpha), borderColor, 1),
-            }),
-            widget.ButtonOpts.TextAndImage("", &tface, &leftArrow, &widget.ButtonTextColor{
-                Idle: color.White,
-                Hover: color.White,
-                Pressed: color.White,
-                Disabled: color.Gray{Y: 128},
-            }),
-            widget.ButtonOpts.TextPadding(&widget.Insets{Top: 2, Bottom: 2, Left: 5, Right: 5}),
-            widget.ButtonOpts.ClickedHandler(func (args *widget.ButtonClickedEventArgs) {
-                setupButtons((inputIndex - 1 + len(inputs)) % len(inputs))
-            }),
-        ))
+        inputBox.AddChild(makeArrowButton(tface, &leftArrow, func (args *widget.ButtonClickedEventArgs) {
+            setupButtons((inputIndex - 1 + len(inputs)) % len(inputs))
+        }))
 
         inputBox.AddChild(widget.NewLabel(
             widget.LabelOpts.Text(inputs[inputIndex], &tface, &widget.LabelColor{
@@ -750,26 +1151,51 @@ func makeInputMenu(yield coroutine.YieldFunc, tface text.Face, drawManager DrawM
         ))
 
         // next button
-        inputBox.AddChild(widget.NewButton(
-            widget.ButtonOpts.Image(&widget.ButtonImage{
-                Idle: ui_image.NewBorderedNineSliceColor(translucent(darkenColor(baseColor, 0.4), alpha), borderColor, 1),
-                Hover: ui_image.NewBorderedNineSliceColor(translucent(baseColor, alpha), borderColor, 1),
-                Pressed: ui_image.NewBorderedNineSliceColor(translucent(brightenColor(baseColor, 0.4), alpha), borderColor, 1),
-            }),
-            widget.ButtonOpts.TextAndImage("", &tface, &rightArrow, &widget.ButtonTextColor{
-                Idle: color.White,
-                Hover: color.White,
-                Pressed: color.White,
-                Disabled: color.Gray{Y: 128},
-            }),
-            widget.ButtonOpts.TextPadding(&widget.Insets{Top: 2, Bottom: 2, Left: 5, Right: 5}),
-            widget.ButtonOpts.ClickedHandler(func (args *widget.ButtonClickedEventArgs) {
-                setupButtons((inputIndex + 1) % len(inputs))
-            }),
-        ))
+        inputBox.AddChild(makeArrowButton(tface, &rightArrow, func (args *widget.ButtonClickedEventArgs) {
+            setupButtons((inputIndex + 1) % len(inputs))
+        }))
 
         container.AddChild(inputBox)
 
+        if inputIndex == 0 {
+            keyboard := inputProfile.KeyboardProfile
+
+            addValueRow(container, tface, "Layout", func() string {
+                index := keyboard.Layout()
+                if index == -1 {
+                    return "Custom"
+                }
+                return KeyboardLayouts[index].Name
+            }, func(direction int) {
+                index := max(0, keyboard.Layout())
+                *keyboard = KeyboardLayouts[(index + direction + len(KeyboardLayouts)) % len(KeyboardLayouts)].Profile
+                save()
+
+                // show the new keys
+                setupButtons(0)
+            })
+
+            fretStrum := func() string {
+                if keyboard.FretStrum {
+                    return "On"
+                }
+                return "Off"
+            }
+
+            container.AddChild(widget.NewLabel(
+                widget.LabelOpts.Text("Fret Press Strums", &tface, &widget.LabelColor{
+                    Idle: color.White,
+                    Disabled: color.Gray{Y: 128},
+                }),
+            ))
+
+            container.AddChild(makeButton(fretStrum(), tface, 200, func (args *widget.ButtonClickedEventArgs) {
+                keyboard.FretStrum = !keyboard.FretStrum
+                args.Button.SetText(fretStrum())
+                save()
+            }))
+        }
+
         makeButtonImage := func(col color.Color) *ebiten.Image {
             out := ebiten.NewImage(int(textHeight), int(textHeight))
 
@@ -792,7 +1218,7 @@ func makeInputMenu(yield coroutine.YieldFunc, tface text.Face, drawManager DrawM
             makeButtonImage(color.RGBA{R: 255, G: 192, B: 203, A: 255}),
         }
 
-        for i, inputName := range []InputAction{InputActionGreen, InputActionRed, InputActionYellow, InputActionBlue, InputActionOrange, InputActionStrumUp, InputActionStrumDown} {
+        for i, inputName := range []InputAction{InputActionGreen, InputActionRed, InputActionYellow, InputActionBlue, InputActionOrange, InputActionStrumUp, InputActionStrumDown, InputActionTilt} {
             box := widget.NewContainer(
                 widget.ContainerOpts.Layout(widget.NewRowLayout(
                     widget.RowLayoutOpts.Direction(widget.DirectionHorizontal),
@@ -815,13 +1241,14 @@ func makeInputMenu(yield coroutine.YieldFunc, tface text.Face, drawManager DrawM
 
             if inputIndex == 0 {
                 // TODO: keyboard input
-                var button *widget.Button
-                button = makeButton(inputProfile.KeyboardProfile.GetInput(inputName).String(), tface, 200, func (args *widget.ButtonClickedEventArgs) {
+                button := makeButton(inputProfile.KeyboardProfile.GetInput(inputName).String(), tface, 200, func (args *widget.ButtonClickedEventArgs) {
                     key := waitForKeyboardInput(yield, tface, drawManager)
-                    button.SetText(key.String())
                     inputProfile.KeyboardProfile.SetInput(inputName, key)
 
-                    configuration.SaveConfiguration(inputProfile.Serialize)
+                    save()
+
+                    // the keys may no longer match the layout that is shown
+                    setupButtons(0)
                 })
 
                 container.AddChild(button)
@@ -837,11 +1264,38 @@ func makeInputMenu(yield coroutine.YieldFunc, tface text.Face, drawManager DrawM
                     button.SetText(fmt.Sprintf("Button %v", input))
                     profile.SetInput(inputName, input)
 
-                    configuration.SaveConfiguration(inputProfile.Serialize)
+                    save()
                 })
                 container.AddChild(button)
             }
         }
+
+        // analog controls are only on gamepads
+        if inputIndex > 0 {
+            gamepadId := gamepads[inputIndex - 1]
+            profile := inputProfile.GetGamepadProfile(gamepadId)
+
+            addAxis := func(name string, instructions string, binding *AxisBinding) {
+                container.AddChild(widget.NewLabel(
+                    widget.LabelOpts.Text(name, &tface, &widget.LabelColor{
+                        Idle: color.White,
+                        Disabled: color.Gray{Y: 128},
+                    }),
+                ))
+
+                container.AddChild(makeButton(binding.String(), tface, 200, func (args *widget.ButtonClickedEventArgs) {
+                    calibrated, ok := calibrateAxis(yield, gamepadId, instructions, tface, drawManager)
+                    if ok {
+                        *binding = calibrated
+                        args.Button.SetText(binding.String())
+                        save()
+                    }
+                }))
+            }
+
+            addAxis("Whammy", "Push the whammy bar all the way down", &profile.Whammy)
+            addAxis("Tilt Axis", "Tilt the guitar up as far as it goes", &profile.Tilt)
+        }
     }
 
     setupButtons(0)
@@ -849,7 +1303,570 @@ func makeInputMenu(yield coroutine.YieldFunc, tface text.Face, drawManager DrawM
     return container
 }
 
-func doSettingsMenu(yield coroutine.YieldFunc, engine *Engine, background *Background, face *text.GoTextFace, inputProfile *InputProfile, configuration *ConfigurationManager) {
+// wait for a note from the midi device. returns false if the player gave up
+func waitForMidiInput(yield coroutine.YieldFunc, device *MidiInput, face text.Face, drawManager DrawManager) (uint8, uint8, bool) {
+    type midiNote struct {
+        channel uint8
+        key uint8
+    }
+
+    notes := make(chan midiNote, 1)
+    device.Capture(func(channel uint8, key uint8) {
+        notes <- midiNote{channel: channel, key: key}
+    })
+    defer device.Capture(nil)
+
+    previousDrawer := drawManager.LastDrawer()
+
+    drawManager.PushDrawer(func(screen *ebiten.Image) {
+        previousDrawer(screen)
+
+        x := float32(400)
+        y := float32(300)
+        width := float32(600)
+        height := float32(300)
+
+        vector.FillRect(screen, x, y, width, height, color.NRGBA{R: 0, G: 0, B: 0, A: 200}, true)
+        vector.StrokeRect(screen, x, y, width, height, 1, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, true)
+        var textOptions text.DrawOptions
+        textOptions.GeoM.Translate(float64(x + 10), float64(y + 2))
+        text.Draw(screen, fmt.Sprintf("Hit a pad on %v", device.Name()), face, &textOptions)
+        textOptions.GeoM.Translate(0, 30)
+        text.Draw(screen, "Escape to cancel", face, &textOptions)
+    })
+    defer drawManager.PopDrawer()
+
+    for {
+        if yield() != nil {
+            return 0, 0, false
+        }
+
+        if inpututil.IsKeyJustPressed(ebiten.KeyEscape) || inpututil.IsKeyJustPressed(ebiten.KeyCapsLock) {
+            yield()
+            return 0, 0, false
+        }
+
+        select {
+            case note := <-notes:
+                return note.channel, note.key, true
+            default:
+        }
+    }
+}
+
+// pick the midi device of the current player and which notes play each action
+func makeMidiMenu(yield coroutine.YieldFunc, tface text.Face, engine *Engine) *widget.Container {
+    container := widget.NewContainer(
+        widget.ContainerOpts.Layout(widget.NewGridLayout(
+            widget.GridLayoutOpts.Columns(2),
+            widget.GridLayoutOpts.DefaultStretch(false, false),
+            widget.GridLayoutOpts.Spacing(20, 10),
+            widget.GridLayoutOpts.Padding(&widget.Insets{Top: 80, Left: 20, Right: 10, Bottom: 10}),
+        )),
+    )
+
+    profile := &engine.Input.Midi
+
+    // the device uses a copy of the bindings, so it is told about every change
+    apply := func() {
+        if engine.Midi != nil {
+            engine.Midi.SetProfile(*profile)
+        }
+        engine.SavePlayers()
+    }
+
+    addValueRow(container, tface, "Device", func() string {
+        if profile.Port == "" {
+            return "None"
+        }
+        return profile.Port
+    }, func(direction int) {
+        ports := append([]string{""}, MidiPorts()...)
+        index := max(0, slices.Index(ports, profile.Port))
+        profile.Port = ports[(index + direction + len(ports)) % len(ports)]
+        engine.SavePlayers()
+        engine.OpenMidi()
+    })
+
+    addValueRow(container, tface, "Latency", func() string {
+        return fmt.Sprintf("%v ms", profile.LatencyMs)
+    }, func(direction int) {
+        latency := profile.LatencyMs + direction * 5
+        profile.LatencyMs = min(max(latency, 0), int(MaxMidiLatency.Milliseconds()))
+        apply()
+    })
+
+    fretStrum := func() string {
+        if profile.FretStrum {
+            return "On"
+        }
+        return "Off"
+    }
+
+    container.AddChild(widget.NewLabel(
+        widget.LabelOpts.Text("Pads Strum", &tface, &widget.LabelColor{
+            Idle: color.White,
+            Disabled: color.Gray{Y: 128},
+        }),
+    ))
+
+    container.AddChild(makeButton(fretStrum(), tface, 200, func (args *widget.ButtonClickedEventArgs) {
+        profile.FretStrum = !profile.FretStrum
+        args.Button.SetText(fretStrum())
+        apply()
+    }))
+
+    bindingText := func(action InputAction) string {
+        binding, ok := profile.Binding(action)
+        if !ok {
+            return "Unbound"
+        }
+        return binding.String()
+    }
+
+    var buttons []*widget.Button
+
+    for _, action := range GameActions {
+        container.AddChild(widget.NewLabel(
+            widget.LabelOpts.Text(action.String(), &tface, &widget.LabelColor{
+                Idle: color.White,
+                Disabled: color.Gray{Y: 128},
+            }),
+        ))
+
+        button := makeButton(bindingText(action), tface, 300, func (args *widget.ButtonClickedEventArgs) {
+            if engine.Midi == nil {
+                engine.ShowToast("Pick a midi device first")
+                return
+            }
+
+            channel, key, ok := waitForMidiInput(yield, engine.Midi, tface, engine)
+            if ok {
+                profile.Bind(action, int(channel), key)
+                apply()
+
+                // binding a note takes it away from any other action
+                for i, other := range GameActions {
+                    buttons[i].SetText(bindingText(other))
+                }
+            }
+        })
+
+        buttons = append(buttons, button)
+        container.AddChild(button)
+    }
+
+    return container
+}
+
+// how much the volume changes with each click of an arrow
+const VolumeStep = 0.05
+
+// add a label to a two column container followed by arrows that change the value, where direction
+// is -1 or 1
+func addValueRow(container *widget.Container, tface text.Face, name string, value func() string, change func(direction int)) {
+    leftArrow, rightArrow := makeArrowImages(tface)
+
+    container.AddChild(widget.NewLabel(
+        widget.LabelOpts.Text(name, &tface, &widget.LabelColor{
+            Idle: color.White,
+            Disabled: color.Gray{Y: 128},
+        }),
+    ))
+
+    box := widget.NewContainer(
+        widget.ContainerOpts.Layout(widget.NewRowLayout(
+            widget.RowLayoutOpts.Direction(widget.DirectionHorizontal),
+            widget.RowLayoutOpts.Spacing(5),
+        )),
+    )
+
+    valueLabel := widget.NewLabel(
+        widget.LabelOpts.Text(value(), &tface, &widget.LabelColor{
+            Idle: color.White,
+            Disabled: color.Gray{Y: 128},
+        }),
+    )
+
+    update := func(direction int) {
+        change(direction)
+        valueLabel.Label = value()
+    }
+
+    box.AddChild(makeArrowButton(tface, &leftArrow, func (args *widget.ButtonClickedEventArgs) {
+        update(-1)
+    }))
+
+    box.AddChild(valueLabel)
+
+    box.AddChild(makeArrowButton(tface, &rightArrow, func (args *widget.ButtonClickedEventArgs) {
+        update(1)
+    }))
+
+    container.AddChild(box)
+}
+
+func makeAudioMenu(tface text.Face, engine *Engine, configuration *ConfigurationManager) *widget.Container {
+    mixer := engine.Mixer
+
+    container := widget.NewContainer(
+        widget.ContainerOpts.Layout(widget.NewGridLayout(
+            widget.GridLayoutOpts.Columns(2),
+            widget.GridLayoutOpts.DefaultStretch(false, false),
+            widget.GridLayoutOpts.Spacing(20, 10),
+            widget.GridLayoutOpts.Padding(&widget.Insets{Top: 80, Left: 20, Right: 10, Bottom: 10}),
+        )),
+    )
+
+    save := func() {
+        err := configuration.SaveMixerSettings(mixer.Settings())
+        if err != nil {
+            log.Printf("Unable to save audio settings: %v", err)
+        }
+    }
+
+    addRow := func(name string, value func() string, change func(direction int)) {
+        addValueRow(container, tface, name, value, func(direction int) {
+            change(direction)
+            save()
+        })
+    }
+
+    addVolumeRow := func(name string, get func() float64, set func(float64)) {
+        addRow(name, func() string {
+            return fmt.Sprintf("%3d%%", int(get() * 100 + 0.5))
+        }, func(direction int) {
+            set(get() + float64(direction) * VolumeStep)
+        })
+    }
+
+    addVolumeRow("Master", mixer.MasterVolume, mixer.SetMasterVolume)
+
+    for _, channel := range MixerChannels {
+        addVolumeRow(channel.String(), func() float64 {
+            return mixer.Volume(channel)
+        }, func(volume float64) {
+            mixer.SetVolume(channel, volume)
+        })
+    }
+
+    addRow("Sound Effect Set", mixer.SoundSet, func(direction int) {
+        sets := SoundSets()
+        index := max(0, slices.Index(sets, mixer.SoundSet()))
+        mixer.SetSoundSet(sets[(index + direction + len(sets)) % len(sets)])
+
+        engine.SoundEffects.Close()
+        engine.SoundEffects = LoadSoundBank(engine.AudioContext, mixer, mixer.SoundSet())
+
+        // let the player hear what they picked
+        engine.SoundEffects.Play(SoundEffectStreak)
+    })
+
+    return container
+}
+
+// pick who is playing, or add a new player. returns when a player is picked or the screen is left
+func choosePlayer(yield coroutine.YieldFunc, engine *Engine, background *Background, face *text.GoTextFace) {
+    quit := false
+
+    var tface text.Face = face
+
+    rootContainer := widget.NewContainer(
+        widget.ContainerOpts.Layout(widget.NewGridLayout(
+            widget.GridLayoutOpts.Columns(1),
+            widget.GridLayoutOpts.DefaultStretch(true, false),
+            widget.GridLayoutOpts.Spacing(0, 10),
+            widget.GridLayoutOpts.Padding(&widget.Insets{Top: 80, Left: 20, Right: 10, Bottom: 10}),
+        )),
+    )
+
+    rootContainer.AddChild(widget.NewLabel(
+        widget.LabelOpts.Text("Who is playing?", &tface, &widget.LabelColor{
+            Idle: color.White,
+            Disabled: color.Gray{Y: 128},
+        }),
+    ))
+
+    pick := func(player *PlayerProfile) {
+        engine.SetPlayer(player)
+        engine.SavePlayers()
+        quit = true
+    }
+
+    for _, player := range engine.Players.Players {
+        button := makeButton(player.Name, tface, 400, func (args *widget.ButtonClickedEventArgs) {
+            pick(player)
+        })
+        rootContainer.AddChild(button)
+
+        if player == engine.Player {
+            button.Focus(true)
+        }
+    }
+
+    errorLabel := widget.NewLabel(
+        widget.LabelOpts.Text("", &tface, &widget.LabelColor{
+            Idle: color.NRGBA{R: 255, G: 100, B: 100, A: 255},
+            Disabled: color.Gray{Y: 128},
+        }),
+    )
+
+    rootContainer.AddChild(makeTextInput(tface, "New player name", 400, func (name string) {
+        player, err := engine.Players.Add(name)
+        if err != nil {
+            errorLabel.Label = err.Error()
+            return
+        }
+
+        pick(player)
+    }))
+
+    rootContainer.AddChild(errorLabel)
+
+    ui := ebitenui.UI{
+        Container: rootContainer,
+    }
+
+    engine.PushDrawer(func(screen *ebiten.Image) {
+        background.Draw(screen)
+        ui.Draw(screen)
+    })
+    defer engine.PopDrawer()
+
+    for !quit {
+        keys := inpututil.AppendJustPressedKeys(nil)
+        for _, key := range keys {
+            switch key {
+                case ebiten.KeyEscape, ebiten.KeyCapsLock:
+                    quit = true
+                case ebiten.KeyDown:
+                    ui.ChangeFocus(widget.FOCUS_NEXT)
+                case ebiten.KeyUp:
+                    ui.ChangeFocus(widget.FOCUS_PREVIOUS)
+            }
+        }
+
+        if engine.HandleMenuInput(&ui) {
+            quit = true
+        }
+
+        background.Update()
+        ui.Update()
+        if yield() != nil {
+            return
+        }
+    }
+}
+
+// the settings that belong to the current player
+func makePlayerMenu(yield coroutine.YieldFunc, tface text.Face, engine *Engine, background *Background, face *text.GoTextFace, reopen func()) *widget.Container {
+    player := engine.Player
+
+    container := widget.NewContainer(
+        widget.ContainerOpts.Layout(widget.NewGridLayout(
+            widget.GridLayoutOpts.Columns(2),
+            widget.GridLayoutOpts.DefaultStretch(false, false),
+            widget.GridLayoutOpts.Spacing(20, 10),
+            widget.GridLayoutOpts.Padding(&widget.Insets{Top: 80, Left: 20, Right: 10, Bottom: 10}),
+        )),
+    )
+
+    container.AddChild(widget.NewLabel(
+        widget.LabelOpts.Text("Player", &tface, &widget.LabelColor{
+            Idle: color.White,
+            Disabled: color.Gray{Y: 128},
+        }),
+    ))
+
+    container.AddChild(makeButton(player.Name, tface, 300, func (args *widget.ButtonClickedEventArgs) {
+        choosePlayer(yield, engine, background, face)
+        reopen()
+    }))
+
+    addOffsetRow := func(name string, offset *time.Duration) {
+        addValueRow(container, tface, name, func() string {
+            return fmt.Sprintf("%+d ms", offset.Milliseconds())
+        }, func(direction int) {
+            *offset = min(MaxCalibrationOffset, max(-MaxCalibrationOffset, *offset + time.Duration(direction) * 5 * time.Millisecond))
+            engine.SavePlayers()
+        })
+    }
+
+    addOffsetRow("Input Offset", &player.InputOffset)
+    addOffsetRow("Video Offset", &player.VideoOffset)
+
+    lefty := func() string {
+        if player.Lefty {
+            return "On"
+        }
+        return "Off"
+    }
+
+    container.AddChild(widget.NewLabel(
+        widget.LabelOpts.Text("Lefty Flip", &tface, &widget.LabelColor{
+            Idle: color.White,
+            Disabled: color.Gray{Y: 128},
+        }),
+    ))
+
+    container.AddChild(makeButton(lefty(), tface, 300, func (args *widget.ButtonClickedEventArgs) {
+        player.Lefty = !player.Lefty
+        args.Button.SetText(lefty())
+        engine.SavePlayers()
+    }))
+
+    addHighwayRow := func(name string, value *float64, format func(float64) string) {
+        addValueRow(container, tface, name, func() string {
+            return format(*value)
+        }, func(direction int) {
+            // rounded so repeated steps don't drift
+            *value = math.Round((*value + float64(direction) * HighwayStep) * 10) / 10
+            player.Highway = player.Highway.clamp()
+            engine.SavePlayers()
+        })
+    }
+
+    percent := func(value float64) string {
+        return fmt.Sprintf("%d%%", int(math.Round(value * 100)))
+    }
+
+    addValueRow(container, tface, "Note Speed", func() string {
+        lookahead := player.HighwayLayout(GuitarFrets).Lookahead()
+        return fmt.Sprintf("%vx (%0.1fs ahead)", player.HighwaySpeed, lookahead.Seconds())
+    }, func(direction int) {
+        speed := player.HighwaySpeed + float64(direction) * HighwaySpeedStep
+        player.HighwaySpeed = min(MaxHighwaySpeed, max(MinHighwaySpeed, speed))
+        engine.SavePlayers()
+    })
+
+    addHighwayRow("Highway Width", &player.Highway.Width, percent)
+    addHighwayRow("Highway Length", &player.Highway.Length, percent)
+    addHighwayRow("Highway Position", &player.Highway.Position, func(value float64) string {
+        switch {
+            case value < 0: return fmt.Sprintf("Left %v", percent(-value))
+            case value > 0: return fmt.Sprintf("Right %v", percent(value))
+        }
+        return "Center"
+    })
+
+    if len(engine.Players.Players) > 1 {
+        container.AddChild(widget.NewLabel(
+            widget.LabelOpts.Text("", &tface, &widget.LabelColor{
+                Idle: color.White,
+                Disabled: color.Gray{Y: 128},
+            }),
+        ))
+
+        container.AddChild(makeButton(fmt.Sprintf("Delete %v", player.Name), tface, 300, func (args *widget.ButtonClickedEventArgs) {
+            if engine.Players.Remove(player.Name) {
+                engine.SetPlayer(engine.Players.CurrentPlayer())
+                engine.SavePlayers()
+                reopen()
+            }
+        }))
+    }
+
+    return container
+}
+
+// pick one of the camera presets, or move the custom camera around
+func makeCameraMenu(tface text.Face, engine *Engine, reopen func()) *widget.Container {
+    container := widget.NewContainer(
+        widget.ContainerOpts.Layout(widget.NewGridLayout(
+            widget.GridLayoutOpts.Columns(2),
+            widget.GridLayoutOpts.DefaultStretch(false, false),
+            widget.GridLayoutOpts.Spacing(20, 10),
+            widget.GridLayoutOpts.Padding(&widget.Insets{Top: 80, Left: 20, Right: 10, Bottom: 10}),
+        )),
+    )
+
+    config := &engine.Configuration.Settings.Camera
+
+    addValueRow(container, tface, "Camera", func() string {
+        return config.Preset
+    }, func(direction int) {
+        names := CameraNames()
+        index := max(0, slices.Index(names, config.Preset))
+        engine.Configuration.Update(func(settings *Settings) {
+            settings.Camera.Preset = names[(index + direction + len(names)) % len(names)]
+        })
+
+        // the rows below show the new camera
+        reopen()
+    })
+
+    // changing any part of the camera makes it the custom camera
+    addCameraRow := func(name string, step float32, value func(camera *CameraSettings) *float32) {
+        addValueRow(container, tface, name, func() string {
+            current := config.Current()
+            return fmt.Sprintf("%v", *value(&current))
+        }, func(direction int) {
+            customized := config.Preset != CustomCameraName
+            engine.Configuration.Update(func(settings *Settings) {
+                settings.Camera.Customize(func(camera *CameraSettings) {
+                    *value(camera) += float32(direction) * step
+                })
+            })
+
+            if customized {
+                reopen()
+            }
+        })
+    }
+
+    addCameraRow("Position X", 5, func(camera *CameraSettings) *float32 { return &camera.X })
+    addCameraRow("Position Y", 5, func(camera *CameraSettings) *float32 { return &camera.Y })
+    addCameraRow("Position Z", 5, func(camera *CameraSettings) *float32 { return &camera.Z })
+    addCameraRow("Look At X", 5, func(camera *CameraSettings) *float32 { return &camera.LookX })
+    addCameraRow("Look At Y", 5, func(camera *CameraSettings) *float32 { return &camera.LookY })
+    addCameraRow("Look At Z", 5, func(camera *CameraSettings) *float32 { return &camera.LookZ })
+    addCameraRow("Field Of View", 1, func(camera *CameraSettings) *float32 { return &camera.FieldOfView })
+
+    return container
+}
+
+// pick the player's skin, with a picture of how it looks
+func makeSkinMenu(tface text.Face, engine *Engine, reopen func()) *widget.Container {
+    container := widget.NewContainer(
+        widget.ContainerOpts.Layout(widget.NewGridLayout(
+            widget.GridLayoutOpts.Columns(2),
+            widget.GridLayoutOpts.DefaultStretch(false, false),
+            widget.GridLayoutOpts.Spacing(20, 10),
+            widget.GridLayoutOpts.Padding(&widget.Insets{Top: 80, Left: 20, Right: 10, Bottom: 10}),
+        )),
+    )
+
+    player := engine.Player
+    skins := FindSkins(engine.SkinDirectories())
+
+    addValueRow(container, tface, "Skin", func() string {
+        return player.NoteSkin
+    }, func(direction int) {
+        index := max(0, slices.IndexFunc(skins, func(manifest SkinManifest) bool {
+            return manifest.Name == player.NoteSkin
+        }))
+        player.NoteSkin = skins[(index + direction + len(skins)) % len(skins)].Name
+        engine.SavePlayers()
+
+        // show the new skin
+        reopen()
+    })
+
+    container.AddChild(widget.NewLabel(
+        widget.LabelOpts.Text("Preview", &tface, &widget.LabelColor{
+            Idle: color.White,
+            Disabled: color.Gray{Y: 128},
+        }),
+    ))
+
+    container.AddChild(widget.NewGraphic(
+        widget.GraphicOpts.Image(engine.LoadSkin(player.NoteSkin).Preview(300, 300)),
+    ))
+
+    return container
+}
+
+func doSettingsMenu(yield coroutine.YieldFunc, engine *Engine, background *Background, face *text.GoTextFace, configuration *ConfigurationManager) {
     quit := false
 
     var tface text.Face = face
@@ -877,11 +1894,17 @@ func doSettingsMenu(yield coroutine.YieldFunc, engine *Engine, background *Backg
         if ebiten.IsFullscreen() {
             fullscreenButton = makeButton("Windowed Mode", tface, maxButtonWidth, func (args *widget.ButtonClickedEventArgs) {
                 ebiten.SetFullscreen(false)
+                engine.Configuration.Update(func(settings *Settings) {
+                    settings.Video.Fullscreen = false
+                })
                 makeFullscreenButton()
             })
         } else {
             fullscreenButton = makeButton("Fullscreen", tface, maxButtonWidth, func (args *widget.ButtonClickedEventArgs) {
                 ebiten.SetFullscreen(true)
+                engine.Configuration.Update(func(settings *Settings) {
+                    settings.Video.Fullscreen = true
+                })
                 makeFullscreenButton()
             })
         }
@@ -894,11 +1917,63 @@ func doSettingsMenu(yield coroutine.YieldFunc, engine *Engine, background *Backg
 
     rootContainer.AddChild(makeButton(fmt.Sprintf("VSync Toggle: %v", ebiten.IsVsyncEnabled()), tface, maxButtonWidth, func (args *widget.ButtonClickedEventArgs) {
         ebiten.SetVsyncEnabled(!ebiten.IsVsyncEnabled())
+        engine.Configuration.Update(func(settings *Settings) {
+            settings.Video.VSync = ebiten.IsVsyncEnabled()
+        })
         args.Button.SetText(fmt.Sprintf("VSync Toggle: %v", ebiten.IsVsyncEnabled()))
     }))
 
     rootContainer.AddChild(makeButton(fmt.Sprintf("Configure input/joystick"), tface, maxButtonWidth, func (args *widget.ButtonClickedEventArgs) {
-        ui.Container = makeInputMenu(yield, tface, engine, inputProfile, configuration)
+        ui.Container = makeInputMenu(yield, tface, engine, engine.Input, engine.SavePlayers)
+    }))
+
+    rootContainer.AddChild(makeButton("Configure MIDI device", tface, maxButtonWidth, func (args *widget.ButtonClickedEventArgs) {
+        ui.Container = makeMidiMenu(yield, tface, engine)
+    }))
+
+    var openPlayerMenu func()
+    openPlayerMenu = func() {
+        ui.Container = makePlayerMenu(yield, tface, engine, background, face, openPlayerMenu)
+    }
+
+    rootContainer.AddChild(makeButton("Player profile", tface, maxButtonWidth, func (args *widget.ButtonClickedEventArgs) {
+        openPlayerMenu()
+    }))
+
+    rootContainer.AddChild(makeButton("Audio volume", tface, maxButtonWidth, func (args *widget.ButtonClickedEventArgs) {
+        ui.Container = makeAudioMenu(tface, engine, configuration)
+    }))
+
+    var openCameraMenu func()
+    openCameraMenu = func() {
+        ui.Container = makeCameraMenu(tface, engine, openCameraMenu)
+    }
+
+    rootContainer.AddChild(makeButton("Camera", tface, maxButtonWidth, func (args *widget.ButtonClickedEventArgs) {
+        openCameraMenu()
+    }))
+
+    var openSkinMenu func()
+    openSkinMenu = func() {
+        ui.Container = makeSkinMenu(tface, engine, openSkinMenu)
+    }
+
+    rootContainer.AddChild(makeButton("Skin", tface, maxButtonWidth, func (args *widget.ButtonClickedEventArgs) {
+        openSkinMenu()
+    }))
+
+    debugMode := func() string {
+        if configuration.Settings.Debug {
+            return "Debug Mode: On"
+        }
+        return "Debug Mode: Off"
+    }
+
+    rootContainer.AddChild(makeButton(debugMode(), tface, maxButtonWidth, func (args *widget.ButtonClickedEventArgs) {
+        configuration.Update(func(settings *Settings) {
+            settings.Debug = !settings.Debug
+        })
+        args.Button.SetText(debugMode())
     }))
 
     rootContainer.AddChild(makeButton("Back", tface, maxButtonWidth, func (args *widget.ButtonClickedEventArgs) {
@@ -913,12 +1988,21 @@ func doSettingsMenu(yield coroutine.YieldFunc, engine *Engine, background *Backg
     })
     defer engine.PopDrawer()
 
+    // leaving one of the screens opened from settings goes back to settings
+    goBack := func() {
+        if ui.Container != rootContainer {
+            ui.Container = rootContainer
+        } else {
+            quit = true
+        }
+    }
+
     for !quit {
         keys := inpututil.AppendJustPressedKeys(nil)
         for _, key := range keys {
             switch key {
                 case ebiten.KeyEscape, ebiten.KeyCapsLock:
-                    quit = true
+                    goBack()
                 case ebiten.KeyDown:
                     ui.ChangeFocus(widget.FOCUS_NEXT)
                 case ebiten.KeyUp:
@@ -926,6 +2010,10 @@ func doSettingsMenu(yield coroutine.YieldFunc, engine *Engine, background *Backg
             }
         }
 
+        if engine.HandleMenuInput(&ui) {
+            goBack()
+        }
+
         background.Update()
         ui.Update()
         if yield() != nil {
@@ -935,8 +2023,7 @@ func doSettingsMenu(yield coroutine.YieldFunc, engine *Engine, background *Backg
 }
 
 func setupSong(yield coroutine.YieldFunc, engine *Engine, songPath string, face *text.GoTextFace, background *Background) (SongSettings, bool) {
-    var settings SongSettings
-    settings.Difficulty = "medium"
+    settings := engine.Configuration.Settings.Gameplay.SongSettings()
 
     var tface text.Face = face
 
@@ -960,6 +2047,10 @@ func setupSong(yield coroutine.YieldFunc, engine *Engine, songPath string, face
         for _, difficulty := range []string{"expert", "hard", "medium", "easy"} {
             container.AddChild(makeButton(difficulty, tface, 200, func (args *widget.ButtonClickedEventArgs) {
                 settings.Difficulty = difficulty
+                settings.Timing = TimingWindowsForDifficulty(difficulty)
+                engine.Configuration.Update(func(saved *Settings) {
+                    saved.Gameplay.Difficulty = difficulty
+                })
                 ui.Container = buildRootContainer()
             }))
         }
@@ -984,12 +2075,37 @@ func setupSong(yield coroutine.YieldFunc, engine *Engine, songPath string, face
             }),
         ))
 
+        best, ok := engine.Player.BestScore(songPath)
+        if ok {
+            rootContainer.AddChild(widget.NewLabel(
+                widget.LabelOpts.Text(fmt.Sprintf("Best score: %v on %v", best.Score, best.Difficulty), &tface, &widget.LabelColor{
+                    Idle: color.White,
+                    Disabled: color.Gray{Y: 128},
+                }),
+            ))
+        }
+
         readyButton := makeButton("Ready", tface, 200, func (args *widget.ButtonClickedEventArgs) {
             quit = true
         })
 
         rootContainer.AddChild(readyButton)
 
+        alternateStrum := func() string {
+            if settings.AlternateStrum {
+                return "Alternate Strum: On"
+            }
+            return "Alternate Strum: Off"
+        }
+
+        rootContainer.AddChild(makeButton(alternateStrum(), tface, 200, func (args *widget.ButtonClickedEventArgs) {
+            settings.AlternateStrum = !settings.AlternateStrum
+            engine.Configuration.Update(func(saved *Settings) {
+                saved.Gameplay.AlternateStrum = settings.AlternateStrum
+            })
+            args.Button.SetText(alternateStrum())
+        }))
+
         // readyButton.Focus(true)
 
         rootContainer.AddChild(makeButton("Difficulty", tface, 200, func (args *widget.ButtonClickedEventArgs) {
@@ -1023,6 +2139,11 @@ func setupSong(yield coroutine.YieldFunc, engine *Engine, songPath string, face
             }
         }
 
+        if engine.HandleMenuInput(&ui) {
+            quit = true
+            canceled = true
+        }
+
         ui.Update()
 
         if yield() != nil {
@@ -1033,6 +2154,115 @@ func setupSong(yield coroutine.YieldFunc, engine *Engine, songPath string, face
     return settings, canceled
 }
 
+func showSetlistResults(yield coroutine.YieldFunc, engine *Engine, background *Background, face *text.GoTextFace, results []SongResult) {
+    quit := false
+
+    var tface text.Face = face
+
+    rootContainer := widget.NewContainer(
+        widget.ContainerOpts.Layout(widget.NewGridLayout(
+            widget.GridLayoutOpts.Columns(5),
+            widget.GridLayoutOpts.DefaultStretch(false, false),
+            widget.GridLayoutOpts.Spacing(30, 10),
+            widget.GridLayoutOpts.Padding(&widget.Insets{Top: 80, Left: 20, Right: 10, Bottom: 10}),
+        )),
+    )
+
+    addLabel := func(value string) {
+        rootContainer.AddChild(widget.NewLabel(
+            widget.LabelOpts.Text(value, &tface, &widget.LabelColor{
+                Idle: color.White,
+                Disabled: color.Gray{Y: 128},
+            }),
+        ))
+    }
+
+    for _, header := range []string{"Song", "Hit", "Missed", "Notes", "Score"} {
+        addLabel(header)
+    }
+
+    // the setlist only stops early when a song failed, which is always the last one
+    if len(results) > 0 && results[len(results) - 1].Failed() {
+        engine.SoundEffects.Play(SoundEffectFail)
+    }
+
+    var total SongResult
+    played := 0
+    for _, result := range results {
+        name := result.SongInfo.Name
+        if name == "" {
+            name = filepath.Base(result.Path)
+        }
+
+        // songs that couldn't be played aren't part of the total
+        if result.Err != nil {
+            addLabel(fmt.Sprintf("%v (unable to play)", name))
+            for range 4 {
+                addLabel("-")
+            }
+            continue
+        }
+
+        if !result.Completed {
+            name += " (quit)"
+        }
+
+        played += 1
+        addLabel(name)
+        addLabel(fmt.Sprintf("%d", result.NotesHit))
+        addLabel(fmt.Sprintf("%d", result.NotesMissed))
+        addLabel(fmt.Sprintf("%d%%", result.Percent()))
+        addLabel(fmt.Sprintf("%d", result.Score))
+
+        total.NotesHit += result.NotesHit
+        total.NotesMissed += result.NotesMissed
+        total.Score += result.Score
+    }
+
+    addLabel(fmt.Sprintf("Total (%d of %d songs)", played, len(results)))
+    addLabel(fmt.Sprintf("%d", total.NotesHit))
+    addLabel(fmt.Sprintf("%d", total.NotesMissed))
+    addLabel(fmt.Sprintf("%d%%", total.Percent()))
+    addLabel(fmt.Sprintf("%d", total.Score))
+
+    doneButton := makeButton("Done", tface, 200, func (args *widget.ButtonClickedEventArgs) {
+        quit = true
+    })
+    rootContainer.AddChild(doneButton)
+    doneButton.Focus(true)
+
+    ui := ebitenui.UI{
+        Container: rootContainer,
+    }
+
+    engine.PushDrawer(func(screen *ebiten.Image) {
+        background.Draw(screen)
+        ui.Draw(screen)
+    })
+    defer engine.PopDrawer()
+
+    for !quit {
+        keys := inpututil.AppendJustPressedKeys(nil)
+        for _, key := range keys {
+            switch key {
+                case ebiten.KeyEscape, ebiten.KeyCapsLock:
+                    quit = true
+            }
+        }
+
+        if engine.HandleMenuInput(&ui) {
+            quit = true
+        }
+
+        background.Update()
+        ui.Update()
+
+        if yield() != nil {
+            return
+        }
+    }
+}
+
 func mainMenu(engine *Engine, yield coroutine.YieldFunc) error {
     quit := false
 
@@ -1042,10 +2272,13 @@ func mainMenu(engine *Engine, yield coroutine.YieldFunc) error {
     }
     var tface text.Face = face
 
-    inputProfile := engine.Configuration.LoadInputProfile()
+    playlists := engine.Configuration.LoadPlaylists()
 
     background := MakeBackground()
 
+    choosePlayer(yield, engine, background, face)
+    yield()
+
     /*
     rootContainer := widget.NewContainer(
         widget.ContainerOpts.Layout(widget.NewRowLayout(
@@ -1066,15 +2299,34 @@ func mainMenu(engine *Engine, yield coroutine.YieldFunc) error {
     )
 
     selectButton := makeButton("Select Song", tface, 200, func (args *widget.ButtonClickedEventArgs) {
-        selectedSong := chooseSong(yield, engine, background, face)
-        if selectedSong != "" {
+        selection := chooseSong(yield, engine, background, face, playlists)
+        if selection.Song != "" {
 
             yield()
-            setup, canceled := setupSong(yield, engine, selectedSong, face, background)
+
+            if len(selection.Setlist) > 0 {
+                // each song is set up just before it is played, with its own difficulties
+                results := playSetlist(yield, engine, selection.Setlist, func(songPath string) (SongSettings, bool) {
+                    return setupSong(yield, engine, songPath, face, background)
+                }, engine.Input)
+                for _, result := range results {
+                    engine.RecordScore(result, result.Difficulty)
+                }
+                yield()
+                if len(results) > 0 {
+                    showSetlistResults(yield, engine, background, face, results)
+                }
+                return
+            }
+
+            setup, canceled := setupSong(yield, engine, selection.Song, face, background)
             // yield()
 
             if !canceled {
-                playSong(yield, engine, selectedSong, setup, inputProfile)
+                result, err := playSong(yield, engine, selection.Song, setup, engine.Input)
+                if err == nil {
+                    engine.RecordScore(result, setup.Difficulty)
+                }
             } else {
                 yield()
             }
@@ -1085,7 +2337,7 @@ func mainMenu(engine *Engine, yield coroutine.YieldFunc) error {
     rootContainer.AddChild(selectButton)
 
     rootContainer.AddChild(makeButton("Settings", tface, 200, func (args *widget.ButtonClickedEventArgs) {
-        doSettingsMenu(yield, engine, background, face, inputProfile, engine.Configuration)
+        doSettingsMenu(yield, engine, background, face, engine.Configuration)
     }))
 
     rootContainer.AddChild(makeButton("Quit", tface, 200, func (args *widget.ButtonClickedEventArgs) {
@@ -1116,6 +2368,10 @@ func mainMenu(engine *Engine, yield coroutine.YieldFunc) error {
             }
         }
 
+        // backing out of the main menu would quit the game, so that is left to the keyboard and the
+        // quit button
+        engine.HandleMenuInput(&ui)
+
         background.Update()
         ui.Update()
 
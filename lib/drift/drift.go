@@ -0,0 +1,109 @@
+// Package drift keeps streams that should play in lockstep, such as the stems of a song, aligned
+// with a master stream. Positions are compared periodically and streams that have wandered off
+// are moved back.
+package drift
+
+import (
+    "time"
+)
+
+// audio.Player satisfies this interface
+type Stream interface {
+    Position() time.Duration
+    SetPosition(position time.Duration) error
+    // streams that are not playing are skipped, for example a stem that is shorter than the master
+    IsPlaying() bool
+}
+
+type Correction struct {
+    // index of the stream in the slice passed to Sync
+    Index int
+    // how far ahead of the master the stream was, negative if it was behind
+    Drift time.Duration
+    // true if the stream was moved all the way to the master, false if it was only nudged
+    Reseek bool
+    // set if the stream could not be moved
+    Err error
+}
+
+type Monitor struct {
+    // drift smaller than this is ignored, since positions reported by audio players jitter a little
+    Tolerance time.Duration
+    // drift larger than this is corrected right away by moving the stream to the master position
+    ReseekThreshold time.Duration
+    // number of checks in a row that a stream must be outside the tolerance before it is nudged
+    Patience int
+
+    // consecutive checks each stream has been outside the tolerance
+    strikes []int
+}
+
+func NewMonitor(tolerance time.Duration, reseekThreshold time.Duration, patience int) *Monitor {
+    return &Monitor{
+        Tolerance: tolerance,
+        ReseekThreshold: reseekThreshold,
+        Patience: patience,
+    }
+}
+
+// how far the stream is from the master, as a positive value
+func abs(value time.Duration) time.Duration {
+    if value < 0 {
+        return -value
+    }
+    return value
+}
+
+// compare every stream against the master and move the ones that have drifted. a stream that has
+// drifted past the reseek threshold is moved to the master position, while one that has stayed
+// outside the tolerance for Patience checks is nudged halfway back, so a single bad position
+// reading can't cause a large jump. streams should be passed in the same order on every call.
+func (monitor *Monitor) Sync(master Stream, streams []Stream) []Correction {
+    if len(monitor.strikes) != len(streams) {
+        monitor.strikes = make([]int, len(streams))
+    }
+
+    if !master.IsPlaying() {
+        clear(monitor.strikes)
+        return nil
+    }
+
+    reference := master.Position()
+
+    var corrections []Correction
+
+    for i, stream := range streams {
+        if stream == master || !stream.IsPlaying() {
+            monitor.strikes[i] = 0
+            continue
+        }
+
+        drift := stream.Position() - reference
+
+        if abs(drift) <= monitor.Tolerance {
+            monitor.strikes[i] = 0
+            continue
+        }
+
+        monitor.strikes[i] += 1
+
+        if abs(drift) >= monitor.ReseekThreshold {
+            corrections = append(corrections, Correction{
+                Index: i,
+                Drift: drift,
+                Reseek: true,
+                Err: stream.SetPosition(reference),
+            })
+            monitor.strikes[i] = 0
+        } else if monitor.strikes[i] >= monitor.Patience {
+            corrections = append(corrections, Correction{
+                Index: i,
+                Drift: drift,
+                Err: stream.SetPosition(reference + drift / 2),
+            })
+            monitor.strikes[i] = 0
+        }
+    }
+
+    return corrections
+}
@@ -0,0 +1,127 @@
+package drift
+
+import (
+    "time"
+    "testing"
+)
+
+type fakeStream struct {
+    position time.Duration
+    playing bool
+    seeks int
+}
+
+func (stream *fakeStream) Position() time.Duration {
+    return stream.position
+}
+
+func (stream *fakeStream) SetPosition(position time.Duration) error {
+    stream.position = position
+    stream.seeks += 1
+    return nil
+}
+
+func (stream *fakeStream) IsPlaying() bool {
+    return stream.playing
+}
+
+func makeStreams(positions ...time.Duration) []Stream {
+    var out []Stream
+    for _, position := range positions {
+        out = append(out, &fakeStream{position: position, playing: true})
+    }
+    return out
+}
+
+func TestInSync(testing *testing.T) {
+    monitor := NewMonitor(20 * time.Millisecond, 200 * time.Millisecond, 3)
+    streams := makeStreams(time.Second, time.Second + 10 * time.Millisecond, time.Second - 15 * time.Millisecond)
+
+    for range 10 {
+        corrections := monitor.Sync(streams[0], streams)
+        if len(corrections) != 0 {
+            testing.Fatalf("streams within the tolerance should not be corrected: %v", corrections)
+        }
+    }
+}
+
+func TestReseek(testing *testing.T) {
+    monitor := NewMonitor(20 * time.Millisecond, 200 * time.Millisecond, 3)
+    streams := makeStreams(10 * time.Second, 9 * time.Second, 10 * time.Second)
+
+    corrections := monitor.Sync(streams[0], streams)
+    if len(corrections) != 1 {
+        testing.Fatalf("expected one correction but got %v", corrections)
+    }
+
+    correction := corrections[0]
+    if correction.Index != 1 || correction.Drift != -time.Second || !correction.Reseek {
+        testing.Errorf("unexpected correction %+v", correction)
+    }
+
+    if streams[1].Position() != 10 * time.Second {
+        testing.Errorf("lagging stream should be moved to the master position but was at %v", streams[1].Position())
+    }
+}
+
+func TestNudge(testing *testing.T) {
+    monitor := NewMonitor(20 * time.Millisecond, 200 * time.Millisecond, 3)
+    streams := makeStreams(10 * time.Second, 10 * time.Second + 80 * time.Millisecond)
+
+    // the drift has to persist before anything happens
+    for range 2 {
+        corrections := monitor.Sync(streams[0], streams)
+        if len(corrections) != 0 {
+            testing.Fatalf("stream should not be nudged before the patience runs out: %v", corrections)
+        }
+    }
+
+    corrections := monitor.Sync(streams[0], streams)
+    if len(corrections) != 1 || corrections[0].Reseek {
+        testing.Fatalf("expected a nudge but got %v", corrections)
+    }
+
+    if streams[1].Position() != 10 * time.Second + 40 * time.Millisecond {
+        testing.Errorf("nudge should move the stream halfway back but it was at %v", streams[1].Position())
+    }
+}
+
+func TestJitter(testing *testing.T) {
+    monitor := NewMonitor(20 * time.Millisecond, 200 * time.Millisecond, 3)
+    master := &fakeStream{position: time.Second, playing: true}
+    stream := &fakeStream{position: time.Second, playing: true}
+    streams := []Stream{master, stream}
+
+    // occasional readings outside the tolerance should not add up to a correction
+    for i := range 20 {
+        if i % 2 == 0 {
+            stream.position = master.position + 50 * time.Millisecond
+        } else {
+            stream.position = master.position
+        }
+
+        monitor.Sync(master, streams)
+    }
+
+    if stream.seeks != 0 {
+        testing.Errorf("jittery stream should not be moved but was seeked %v times", stream.seeks)
+    }
+}
+
+func TestNotPlaying(testing *testing.T) {
+    monitor := NewMonitor(20 * time.Millisecond, 200 * time.Millisecond, 3)
+    master := &fakeStream{position: 30 * time.Second, playing: true}
+    finished := &fakeStream{position: 10 * time.Second, playing: false}
+
+    corrections := monitor.Sync(master, []Stream{master, finished})
+    if len(corrections) != 0 || finished.seeks != 0 {
+        testing.Errorf("a stream that isn't playing should be left alone")
+    }
+
+    master.playing = false
+    lagging := &fakeStream{position: 10 * time.Second, playing: true}
+    corrections = monitor.Sync(master, []Stream{master, lagging})
+    if len(corrections) != 0 || lagging.seeks != 0 {
+        testing.Errorf("nothing should be corrected while the master isn't playing")
+    }
+}
@@ -0,0 +1,115 @@
+package flac
+
+import (
+    "io"
+    "bufio"
+)
+
+// reads a stream msb first, as flac is laid out
+type bitReader struct {
+    reader *bufio.Reader
+    cache uint64
+    // number of unread bits in cache
+    bits uint
+    // bytes consumed from reader
+    consumed int64
+    // when set, every whole byte read is fed to the crc
+    crc8 *uint8
+}
+
+func newBitReader(reader io.Reader) *bitReader {
+    return &bitReader{
+        reader: bufio.NewReaderSize(reader, 64 * 1024),
+    }
+}
+
+func (reader *bitReader) reset(source io.Reader) {
+    reader.reader.Reset(source)
+    reader.cache = 0
+    reader.bits = 0
+    reader.consumed = 0
+}
+
+func (reader *bitReader) readBits(count uint) (uint64, error) {
+    if count == 0 {
+        return 0, nil
+    }
+
+    for reader.bits < count {
+        next, err := reader.reader.ReadByte()
+        if err != nil {
+            if err == io.EOF && reader.bits > 0 {
+                return 0, io.ErrUnexpectedEOF
+            }
+            return 0, err
+        }
+
+        if reader.crc8 != nil {
+            *reader.crc8 = crc8Table[*reader.crc8 ^ next]
+        }
+
+        reader.consumed += 1
+        reader.cache = (reader.cache << 8) | uint64(next)
+        reader.bits += 8
+    }
+
+    reader.bits -= count
+    value := (reader.cache >> reader.bits) & ((1 << count) - 1)
+    return value, nil
+}
+
+func (reader *bitReader) readBit() (bool, error) {
+    value, err := reader.readBits(1)
+    return value == 1, err
+}
+
+// read a two's complement number of the given width
+func (reader *bitReader) readSigned(count uint) (int64, error) {
+    value, err := reader.readBits(count)
+    if err != nil {
+        return 0, err
+    }
+
+    if count > 0 && value & (1 << (count - 1)) != 0 {
+        return int64(value) - (1 << count), nil
+    }
+
+    return int64(value), nil
+}
+
+// count the zero bits before the next one bit
+func (reader *bitReader) readUnary() (uint64, error) {
+    var count uint64
+    for {
+        bit, err := reader.readBit()
+        if err != nil {
+            return 0, err
+        }
+        if bit {
+            return count, nil
+        }
+        count += 1
+    }
+}
+
+// skip to the next byte boundary
+func (reader *bitReader) align() {
+    reader.bits -= reader.bits % 8
+}
+
+// crc-8 with polynomial x^8 + x^2 + x + 1, used by frame headers
+var crc8Table = func() [256]uint8 {
+    var table [256]uint8
+    for i := range 256 {
+        crc := uint8(i)
+        for range 8 {
+            if crc & 0x80 != 0 {
+                crc = (crc << 1) ^ 0x07
+            } else {
+                crc <<= 1
+            }
+        }
+        table[i] = crc
+    }
+    return table
+}()
@@ -0,0 +1,357 @@
+// Package flac decodes FLAC audio into signed 16-bit little endian stereo pcm, the format
+// used by ebiten's audio players.
+package flac
+
+import (
+    "io"
+    "fmt"
+    "errors"
+)
+
+var ErrNotFlac = errors.New("flac: missing fLaC marker")
+var ErrInvalidFrame = errors.New("flac: invalid frame")
+
+const bytesPerFrame = 4
+
+type StreamInfo struct {
+    MinBlockSize int
+    MaxBlockSize int
+    SampleRate int
+    Channels int
+    BitsPerSample int
+    // zero if unknown
+    TotalSamples int64
+}
+
+type seekPoint struct {
+    sample int64
+    // relative to the first frame
+    offset int64
+}
+
+type Stream struct {
+    source io.ReadSeeker
+    reader *bitReader
+    info StreamInfo
+    seekTable []seekPoint
+
+    // offset of the first frame in source
+    firstFrame int64
+
+    // sample number of the start of the next frame to be decoded
+    nextSample int64
+
+    // decoded output waiting to be read
+    pending []byte
+    // output position in bytes
+    position int64
+
+    // scratch space reused between frames
+    channels [][]int32
+}
+
+// Decode reads the flac header from source. The stream reads from source on demand, so source must
+// stay open while the stream is in use.
+func Decode(source io.ReadSeeker) (*Stream, error) {
+    stream := &Stream{
+        source: source,
+        reader: newBitReader(source),
+    }
+
+    err := stream.readMetadata()
+    if err != nil {
+        return nil, err
+    }
+
+    stream.firstFrame = stream.reader.consumed
+
+    if stream.info.TotalSamples == 0 {
+        // the length is not in the header so count the samples in every frame
+        for {
+            _, err := stream.decodeFrame()
+            if err == io.EOF {
+                break
+            }
+            if err != nil {
+                return nil, err
+            }
+        }
+
+        stream.info.TotalSamples = stream.nextSample
+
+        err := stream.seekSample(0)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    return stream, nil
+}
+
+func (stream *Stream) Info() StreamInfo {
+    return stream.info
+}
+
+func (stream *Stream) SampleRate() int {
+    return stream.info.SampleRate
+}
+
+// Length is the size of the decoded output in bytes
+func (stream *Stream) Length() int64 {
+    return stream.info.TotalSamples * bytesPerFrame
+}
+
+func (stream *Stream) readMetadata() error {
+    reader := stream.reader
+
+    marker, err := reader.readBits(32)
+    if err != nil {
+        return err
+    }
+
+    if marker != 0x664c6143 {
+        return ErrNotFlac
+    }
+
+    foundInfo := false
+
+    for {
+        last, err := reader.readBit()
+        if err != nil {
+            return err
+        }
+
+        kind, err := reader.readBits(7)
+        if err != nil {
+            return err
+        }
+
+        length, err := reader.readBits(24)
+        if err != nil {
+            return err
+        }
+
+        switch kind {
+            case 0:
+                err = stream.readStreamInfo()
+                foundInfo = true
+            case 3:
+                err = stream.readSeekTable(int(length))
+            default:
+                _, err = reader.reader.Discard(int(length))
+                reader.consumed += int64(length)
+        }
+
+        if err != nil {
+            return err
+        }
+
+        if last {
+            break
+        }
+    }
+
+    if !foundInfo {
+        return fmt.Errorf("flac: missing stream info")
+    }
+
+    return nil
+}
+
+func (stream *Stream) readStreamInfo() error {
+    reader := stream.reader
+
+    var fields [7]uint64
+    for i, width := range []uint{16, 16, 24, 24, 20, 3, 5} {
+        value, err := reader.readBits(width)
+        if err != nil {
+            return err
+        }
+        fields[i] = value
+    }
+
+    total, err := reader.readBits(36)
+    if err != nil {
+        return err
+    }
+
+    // md5 signature
+    for range 16 {
+        _, err := reader.readBits(8)
+        if err != nil {
+            return err
+        }
+    }
+
+    stream.info = StreamInfo{
+        MinBlockSize: int(fields[0]),
+        MaxBlockSize: int(fields[1]),
+        SampleRate: int(fields[4]),
+        Channels: int(fields[5]) + 1,
+        BitsPerSample: int(fields[6]) + 1,
+        TotalSamples: int64(total),
+    }
+
+    if stream.info.SampleRate == 0 {
+        return fmt.Errorf("flac: invalid sample rate")
+    }
+
+    return nil
+}
+
+func (stream *Stream) readSeekTable(length int) error {
+    reader := stream.reader
+
+    for range length / 18 {
+        sample, err := reader.readBits(32)
+        if err != nil {
+            return err
+        }
+        sampleLow, err := reader.readBits(32)
+        if err != nil {
+            return err
+        }
+        offset, err := reader.readBits(32)
+        if err != nil {
+            return err
+        }
+        offsetLow, err := reader.readBits(32)
+        if err != nil {
+            return err
+        }
+        // number of samples in the target frame
+        _, err = reader.readBits(16)
+        if err != nil {
+            return err
+        }
+
+        sample = sample << 32 | sampleLow
+        offset = offset << 32 | offsetLow
+
+        // placeholder points
+        if sample == 0xffffffffffffffff {
+            continue
+        }
+
+        stream.seekTable = append(stream.seekTable, seekPoint{sample: int64(sample), offset: int64(offset)})
+    }
+
+    for range length % 18 {
+        _, err := reader.readBits(8)
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func (stream *Stream) Read(data []byte) (int, error) {
+    if len(stream.pending) == 0 {
+        samples, err := stream.decodeFrame()
+        if err != nil {
+            return 0, err
+        }
+
+        stream.pending = stream.convert(samples, stream.pending[:0])
+    }
+
+    count := copy(data, stream.pending)
+    stream.pending = stream.pending[count:]
+    stream.position += int64(count)
+    return count, nil
+}
+
+func (stream *Stream) Seek(offset int64, whence int) (int64, error) {
+    var position int64
+    switch whence {
+        case io.SeekStart: position = offset
+        case io.SeekCurrent: position = stream.position + offset
+        case io.SeekEnd: position = stream.Length() + offset
+        default: return 0, fmt.Errorf("flac: invalid whence %v", whence)
+    }
+
+    if position < 0 {
+        return 0, fmt.Errorf("flac: negative position")
+    }
+
+    sample := position / bytesPerFrame
+
+    err := stream.seekSample(sample)
+    if err != nil {
+        return 0, err
+    }
+
+    // decode frames until the one containing the target sample
+    for {
+        frameStart := stream.nextSample
+        samples, err := stream.decodeFrame()
+        if err == io.EOF {
+            stream.pending = stream.pending[:0]
+            stream.position = position
+            return position, nil
+        }
+        if err != nil {
+            return 0, err
+        }
+
+        if stream.nextSample > sample {
+            stream.pending = stream.convert(samples, stream.pending[:0])
+            skip := (sample - frameStart) * bytesPerFrame + position % bytesPerFrame
+            stream.pending = stream.pending[min(skip, int64(len(stream.pending))):]
+            stream.position = position
+            return position, nil
+        }
+    }
+}
+
+// move the reader to a frame boundary at or before the given sample
+func (stream *Stream) seekSample(sample int64) error {
+    var best seekPoint
+    for _, point := range stream.seekTable {
+        if point.sample <= sample && point.sample >= best.sample {
+            best = point
+        }
+    }
+
+    // keep decoding forward from the current frame if that is closer than the seek point
+    if sample >= stream.nextSample && stream.nextSample >= best.sample {
+        return nil
+    }
+
+    _, err := stream.source.Seek(stream.firstFrame + best.offset, io.SeekStart)
+    if err != nil {
+        return err
+    }
+
+    stream.reader.reset(stream.source)
+    stream.reader.consumed = stream.firstFrame + best.offset
+    stream.nextSample = best.sample
+    stream.pending = stream.pending[:0]
+    return nil
+}
+
+func clampSample(value int32, bits int) int16 {
+    if bits > 16 {
+        return int16(value >> (bits - 16))
+    }
+    return int16(value << (16 - bits))
+}
+
+// interleave the first two channels as 16-bit stereo, duplicating mono
+func (stream *Stream) convert(samples [][]int32, out []byte) []byte {
+    bits := stream.info.BitsPerSample
+    left := samples[0]
+    right := samples[0]
+    if len(samples) > 1 {
+        right = samples[1]
+    }
+
+    for i := range left {
+        l := clampSample(left[i], bits)
+        r := clampSample(right[i], bits)
+        out = append(out, byte(l), byte(l >> 8), byte(r), byte(r >> 8))
+    }
+
+    return out
+}
@@ -0,0 +1,375 @@
+package flac
+
+import (
+    "io"
+    "math"
+    "bytes"
+    "testing"
+)
+
+type bitWriter struct {
+    data []byte
+    current uint64
+    bits uint
+}
+
+func (writer *bitWriter) writeBits(value uint64, count uint) {
+    for i := int(count) - 1; i >= 0; i-- {
+        writer.current = writer.current << 1 | (value >> uint(i)) & 1
+        writer.bits += 1
+        if writer.bits == 8 {
+            writer.data = append(writer.data, byte(writer.current))
+            writer.current = 0
+            writer.bits = 0
+        }
+    }
+}
+
+func (writer *bitWriter) writeSigned(value int64, count uint) {
+    writer.writeBits(uint64(value) & ((1 << count) - 1), count)
+}
+
+func (writer *bitWriter) align() {
+    for writer.bits != 0 {
+        writer.writeBits(0, 1)
+    }
+}
+
+type subframeKind int
+const (
+    subframeVerbatim subframeKind = iota
+    subframeConstant
+    subframeFixed
+    subframeLPC
+)
+
+type encodeOptions struct {
+    bitsPerSample int
+    blockSize int
+    kind subframeKind
+    order int
+    assignment int
+    // if false then total samples is written as zero
+    writeTotal bool
+    seekTable bool
+}
+
+func writeResidual(writer *bitWriter, residual []int64) {
+    // rice, partition order 0, fixed parameter
+    writer.writeBits(0, 2)
+    writer.writeBits(0, 4)
+    parameter := uint(4)
+    writer.writeBits(uint64(parameter), 4)
+    for _, value := range residual {
+        zigzag := uint64(value << 1) ^ uint64(value >> 63)
+        for range zigzag >> parameter {
+            writer.writeBits(0, 1)
+        }
+        writer.writeBits(1, 1)
+        writer.writeBits(zigzag & ((1 << parameter) - 1), parameter)
+    }
+}
+
+func writeSubframe(writer *bitWriter, samples []int32, bits int, options encodeOptions) {
+    switch options.kind {
+        case subframeConstant:
+            writer.writeBits(0, 8)
+            writer.writeSigned(int64(samples[0]), uint(bits))
+        case subframeVerbatim:
+            writer.writeBits(1 << 1, 8)
+            for _, sample := range samples {
+                writer.writeSigned(int64(sample), uint(bits))
+            }
+        case subframeFixed:
+            order := options.order
+            writer.writeBits(uint64(8 + order) << 1, 8)
+            for i := range order {
+                writer.writeSigned(int64(samples[i]), uint(bits))
+            }
+            var residual []int64
+            for i := order; i < len(samples); i++ {
+                var prediction int64
+                switch order {
+                    case 1: prediction = int64(samples[i-1])
+                    case 2: prediction = 2 * int64(samples[i-1]) - int64(samples[i-2])
+                }
+                residual = append(residual, int64(samples[i]) - prediction)
+            }
+            writeResidual(writer, residual)
+        case subframeLPC:
+            // order 2 predictor equivalent to the fixed order 2 predictor, scaled by the shift
+            coefficients := []int64{2 << 3, -1 << 3}
+            shift := 3
+            precision := 6
+            writer.writeBits(uint64(32 + len(coefficients) - 1) << 1, 8)
+            for i := range coefficients {
+                writer.writeSigned(int64(samples[i]), uint(bits))
+            }
+            writer.writeBits(uint64(precision - 1), 4)
+            writer.writeSigned(int64(shift), 5)
+            for _, coefficient := range coefficients {
+                writer.writeSigned(coefficient, uint(precision))
+            }
+            var residual []int64
+            for i := len(coefficients); i < len(samples); i++ {
+                var sum int64
+                for j, coefficient := range coefficients {
+                    sum += coefficient * int64(samples[i - j - 1])
+                }
+                residual = append(residual, int64(samples[i]) - (sum >> shift))
+            }
+            writeResidual(writer, residual)
+    }
+}
+
+func encode(channels [][]int32, sampleRate int, options encodeOptions) []byte {
+    var writer bitWriter
+    total := len(channels[0])
+    frames := (total + options.blockSize - 1) / options.blockSize
+
+    writer.writeBits(0x664c6143, 32)
+
+    // stream info
+    last := uint64(1)
+    if options.seekTable {
+        last = 0
+    }
+    writer.writeBits(last, 1)
+    writer.writeBits(0, 7)
+    writer.writeBits(34, 24)
+    writer.writeBits(uint64(options.blockSize), 16)
+    writer.writeBits(uint64(options.blockSize), 16)
+    writer.writeBits(0, 24)
+    writer.writeBits(0, 24)
+    writer.writeBits(uint64(sampleRate), 20)
+    writer.writeBits(uint64(len(channels) - 1), 3)
+    writer.writeBits(uint64(options.bitsPerSample - 1), 5)
+    if options.writeTotal {
+        writer.writeBits(uint64(total), 36)
+    } else {
+        writer.writeBits(0, 36)
+    }
+    for range 16 {
+        writer.writeBits(0, 8)
+    }
+
+    // frames are written separately so their offsets are known for the seek table
+    var frameData [][]byte
+
+    for frame := range frames {
+        var frameWriter bitWriter
+        start := frame * options.blockSize
+        end := min(total, start + options.blockSize)
+        size := end - start
+
+        frameWriter.writeBits(0x3ffe, 14)
+        frameWriter.writeBits(0, 1)
+        frameWriter.writeBits(0, 1)
+        frameWriter.writeBits(7, 4)
+        frameWriter.writeBits(0, 4)
+        channelCode := uint64(len(channels) - 1)
+        if options.assignment != channelsIndependent {
+            channelCode = uint64(options.assignment + 7)
+        }
+        frameWriter.writeBits(channelCode, 4)
+        frameWriter.writeBits(0, 3)
+        frameWriter.writeBits(0, 1)
+        frameWriter.writeBits(uint64(frame), 8)
+        frameWriter.writeBits(uint64(size - 1), 16)
+
+        var crc uint8
+        for _, value := range frameWriter.data {
+            crc = crc8Table[crc ^ value]
+        }
+        frameWriter.writeBits(uint64(crc), 8)
+
+        blocks := make([][]int32, len(channels))
+        for i := range channels {
+            blocks[i] = channels[i][start:end]
+        }
+
+        if len(channels) == 2 {
+            left, right := blocks[0], blocks[1]
+            side := make([]int32, size)
+            mid := make([]int32, size)
+            for i := range left {
+                side[i] = left[i] - right[i]
+                mid[i] = (left[i] + right[i]) >> 1
+            }
+            switch options.assignment {
+                case channelsLeftSide: blocks = [][]int32{left, side}
+                case channelsSideRight: blocks = [][]int32{side, right}
+                case channelsMidSide: blocks = [][]int32{mid, side}
+            }
+        }
+
+        for channel, block := range blocks {
+            bits := options.bitsPerSample
+            switch {
+                case options.assignment == channelsLeftSide && channel == 1: bits += 1
+                case options.assignment == channelsSideRight && channel == 0: bits += 1
+                case options.assignment == channelsMidSide && channel == 1: bits += 1
+            }
+            writeSubframe(&frameWriter, block, bits, options)
+        }
+
+        frameWriter.align()
+        frameWriter.writeBits(0, 16)
+
+        frameData = append(frameData, frameWriter.data)
+    }
+
+    if options.seekTable {
+        writer.writeBits(1, 1)
+        writer.writeBits(3, 7)
+        writer.writeBits(uint64(18 * frames), 24)
+        offset := 0
+        for frame := range frames {
+            writer.writeBits(uint64(frame * options.blockSize), 64)
+            writer.writeBits(uint64(offset), 64)
+            writer.writeBits(uint64(options.blockSize), 16)
+            offset += len(frameData[frame])
+        }
+    }
+
+    out := writer.data
+    for _, data := range frameData {
+        out = append(out, data...)
+    }
+
+    return out
+}
+
+func makeSine(count int, bits int, frequency float64) []int32 {
+    out := make([]int32, count)
+    amplitude := float64(int64(1) << (bits - 2))
+    for i := range out {
+        out[i] = int32(amplitude * math.Sin(float64(i) * frequency))
+    }
+    return out
+}
+
+// what the decoder should produce for the given channels
+func expectedPCM(channels [][]int32, bits int) []byte {
+    var out []byte
+    for i := range channels[0] {
+        left := clampSample(channels[0][i], bits)
+        right := left
+        if len(channels) > 1 {
+            right = clampSample(channels[1][i], bits)
+        }
+        out = append(out, byte(left), byte(left >> 8), byte(right), byte(right >> 8))
+    }
+    return out
+}
+
+func decodeAll(testing *testing.T, data []byte) (*Stream, []byte) {
+    stream, err := Decode(bytes.NewReader(data))
+    if err != nil {
+        testing.Fatalf("decode failed: %v", err)
+    }
+
+    out, err := io.ReadAll(stream)
+    if err != nil {
+        testing.Fatalf("read failed: %v", err)
+    }
+
+    return stream, out
+}
+
+func TestSubframes(testing *testing.T) {
+    for _, options := range []encodeOptions{
+        {bitsPerSample: 16, blockSize: 1000, kind: subframeVerbatim, writeTotal: true},
+        {bitsPerSample: 16, blockSize: 1000, kind: subframeFixed, order: 1, writeTotal: true},
+        {bitsPerSample: 16, blockSize: 1024, kind: subframeFixed, order: 2, writeTotal: true},
+        {bitsPerSample: 16, blockSize: 512, kind: subframeLPC, writeTotal: true},
+        {bitsPerSample: 24, blockSize: 700, kind: subframeFixed, order: 2, writeTotal: true},
+        {bitsPerSample: 8, blockSize: 300, kind: subframeVerbatim, writeTotal: true},
+    } {
+        channels := [][]int32{makeSine(4500, options.bitsPerSample, 0.01)}
+        stream, out := decodeAll(testing, encode(channels, 44100, options))
+
+        if stream.SampleRate() != 44100 {
+            testing.Errorf("sample rate should be 44100 but was %v", stream.SampleRate())
+        }
+
+        if stream.Length() != int64(len(channels[0]) * 4) {
+            testing.Errorf("length should be %v but was %v", len(channels[0]) * 4, stream.Length())
+        }
+
+        if !bytes.Equal(out, expectedPCM(channels, options.bitsPerSample)) {
+            testing.Errorf("decoded output does not match for %+v", options)
+        }
+    }
+}
+
+func TestConstant(testing *testing.T) {
+    channels := [][]int32{make([]int32, 2000)}
+    for i := range channels[0] {
+        channels[0][i] = 1234
+    }
+
+    _, out := decodeAll(testing, encode(channels, 22050, encodeOptions{bitsPerSample: 16, blockSize: 1000, kind: subframeConstant, writeTotal: true}))
+    if !bytes.Equal(out, expectedPCM(channels, 16)) {
+        testing.Error("decoded constant output does not match")
+    }
+}
+
+func TestStereo(testing *testing.T) {
+    channels := [][]int32{makeSine(3000, 16, 0.01), makeSine(3000, 16, 0.023)}
+    for _, assignment := range []int{channelsIndependent, channelsLeftSide, channelsSideRight, channelsMidSide} {
+        _, out := decodeAll(testing, encode(channels, 48000, encodeOptions{bitsPerSample: 16, blockSize: 1024, kind: subframeFixed, order: 2, assignment: assignment, writeTotal: true}))
+        if !bytes.Equal(out, expectedPCM(channels, 16)) {
+            testing.Errorf("decoded stereo output does not match for channel assignment %v", assignment)
+        }
+    }
+}
+
+func TestUnknownLength(testing *testing.T) {
+    channels := [][]int32{makeSine(2500, 16, 0.01)}
+    stream, out := decodeAll(testing, encode(channels, 44100, encodeOptions{bitsPerSample: 16, blockSize: 1000, kind: subframeFixed, order: 2}))
+
+    if stream.Length() != int64(len(channels[0]) * 4) {
+        testing.Errorf("length should be %v but was %v", len(channels[0]) * 4, stream.Length())
+    }
+
+    if !bytes.Equal(out, expectedPCM(channels, 16)) {
+        testing.Error("decoded output does not match")
+    }
+}
+
+func TestSeek(testing *testing.T) {
+    channels := [][]int32{makeSine(10000, 16, 0.01), makeSine(10000, 16, 0.02)}
+    expected := expectedPCM(channels, 16)
+
+    for _, seekTable := range []bool{false, true} {
+        data := encode(channels, 44100, encodeOptions{bitsPerSample: 16, blockSize: 1000, kind: subframeFixed, order: 2, assignment: channelsMidSide, writeTotal: true, seekTable: seekTable})
+        stream, err := Decode(bytes.NewReader(data))
+        if err != nil {
+            testing.Fatalf("decode failed: %v", err)
+        }
+
+        buffer := make([]byte, 100)
+
+        // forward, backward, into the middle of a frame, and at an odd byte offset
+        for _, position := range []int64{20000, 4000, 36002, 0, 39998} {
+            _, err := stream.Seek(position, io.SeekStart)
+            if err != nil {
+                testing.Fatalf("seek to %v failed: %v", position, err)
+            }
+
+            count, err := io.ReadFull(stream, buffer)
+            want := expected[position:min(int64(len(expected)), position + int64(len(buffer)))]
+            if count != len(want) || !bytes.Equal(buffer[:count], want) {
+                testing.Errorf("read after seek to %v does not match (seek table %v): %v", position, seekTable, err)
+            }
+        }
+    }
+}
+
+func TestNotFlac(testing *testing.T) {
+    _, err := Decode(bytes.NewReader([]byte("RIFF0000WAVE")))
+    if err != ErrNotFlac {
+        testing.Errorf("expected ErrNotFlac but got %v", err)
+    }
+}
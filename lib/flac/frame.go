@@ -0,0 +1,482 @@
+package flac
+
+import (
+    "io"
+    "fmt"
+)
+
+const (
+    channelsIndependent = iota
+    channelsLeftSide
+    channelsSideRight
+    channelsMidSide
+)
+
+type frameHeader struct {
+    blockSize int
+    sampleRate int
+    channels int
+    assignment int
+    bitsPerSample int
+}
+
+// decode the next frame, returning one slice of samples per channel. the slices are reused by the next call.
+func (stream *Stream) decodeFrame() ([][]int32, error) {
+    header, err := stream.readFrameHeader()
+    if err != nil {
+        return nil, err
+    }
+
+    if len(stream.channels) < header.channels {
+        stream.channels = make([][]int32, header.channels)
+    }
+
+    samples := stream.channels[:header.channels]
+
+    for channel := range header.channels {
+        bits := header.bitsPerSample
+        // the side channel needs an extra bit
+        switch {
+            case header.assignment == channelsLeftSide && channel == 1: bits += 1
+            case header.assignment == channelsSideRight && channel == 0: bits += 1
+            case header.assignment == channelsMidSide && channel == 1: bits += 1
+        }
+
+        if cap(samples[channel]) < header.blockSize {
+            samples[channel] = make([]int32, header.blockSize)
+        }
+        samples[channel] = samples[channel][:header.blockSize]
+
+        err := stream.readSubframe(samples[channel], bits)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    stream.reader.align()
+    // crc-16 of the whole frame
+    _, err = stream.reader.readBits(16)
+    if err != nil {
+        return nil, err
+    }
+
+    decorrelate(samples, header.assignment)
+
+    stream.nextSample += int64(header.blockSize)
+
+    return samples, nil
+}
+
+func decorrelate(samples [][]int32, assignment int) {
+    switch assignment {
+        case channelsLeftSide:
+            left, side := samples[0], samples[1]
+            for i := range left {
+                side[i] = left[i] - side[i]
+            }
+        case channelsSideRight:
+            side, right := samples[0], samples[1]
+            for i := range side {
+                side[i] = side[i] + right[i]
+            }
+        case channelsMidSide:
+            mid, side := samples[0], samples[1]
+            for i := range mid {
+                value := mid[i] << 1 | side[i] & 1
+                mid[i] = (value + side[i]) >> 1
+                side[i] = (value - side[i]) >> 1
+            }
+    }
+}
+
+func (stream *Stream) readFrameHeader() (frameHeader, error) {
+    var header frameHeader
+
+    reader := stream.reader
+
+    var crc uint8
+    reader.crc8 = &crc
+    defer func(){
+        reader.crc8 = nil
+    }()
+
+    sync, err := reader.readBits(14)
+    if err != nil {
+        if err == io.ErrUnexpectedEOF {
+            return header, io.EOF
+        }
+        return header, err
+    }
+
+    if sync != 0x3ffe {
+        return header, ErrInvalidFrame
+    }
+
+    var fields [6]uint64
+    for i, width := range []uint{1, 1, 4, 4, 4, 3} {
+        fields[i], err = reader.readBits(width)
+        if err != nil {
+            return header, err
+        }
+    }
+
+    // reserved bit
+    _, err = reader.readBits(1)
+    if err != nil {
+        return header, err
+    }
+
+    // frame or sample number, stored like utf-8. it isn't needed because samples are counted as frames are decoded
+    err = skipCodedNumber(reader)
+    if err != nil {
+        return header, err
+    }
+
+    blockSizeCode := fields[2]
+    switch {
+        case blockSizeCode == 0:
+            return header, ErrInvalidFrame
+        case blockSizeCode == 1:
+            header.blockSize = 192
+        case blockSizeCode <= 5:
+            header.blockSize = 576 << (blockSizeCode - 2)
+        case blockSizeCode == 6:
+            value, err := reader.readBits(8)
+            if err != nil {
+                return header, err
+            }
+            header.blockSize = int(value) + 1
+        case blockSizeCode == 7:
+            value, err := reader.readBits(16)
+            if err != nil {
+                return header, err
+            }
+            header.blockSize = int(value) + 1
+        default:
+            header.blockSize = 256 << (blockSizeCode - 8)
+    }
+
+    sampleRates := []int{stream.info.SampleRate, 88200, 176400, 192000, 8000, 16000, 22050, 24000, 32000, 44100, 48000, 96000}
+    rateCode := fields[3]
+    switch {
+        case rateCode < uint64(len(sampleRates)):
+            header.sampleRate = sampleRates[rateCode]
+        case rateCode == 12:
+            value, err := reader.readBits(8)
+            if err != nil {
+                return header, err
+            }
+            header.sampleRate = int(value) * 1000
+        case rateCode == 13:
+            value, err := reader.readBits(16)
+            if err != nil {
+                return header, err
+            }
+            header.sampleRate = int(value)
+        case rateCode == 14:
+            value, err := reader.readBits(16)
+            if err != nil {
+                return header, err
+            }
+            header.sampleRate = int(value) * 10
+        default:
+            return header, ErrInvalidFrame
+    }
+
+    channelCode := int(fields[4])
+    switch {
+        case channelCode < 8:
+            header.channels = channelCode + 1
+            header.assignment = channelsIndependent
+        case channelCode <= 10:
+            header.channels = 2
+            header.assignment = channelCode - 7
+        default:
+            return header, ErrInvalidFrame
+    }
+
+    switch fields[5] {
+        case 0: header.bitsPerSample = stream.info.BitsPerSample
+        case 1: header.bitsPerSample = 8
+        case 2: header.bitsPerSample = 12
+        case 4: header.bitsPerSample = 16
+        case 5: header.bitsPerSample = 20
+        case 6: header.bitsPerSample = 24
+        case 7: header.bitsPerSample = 32
+        default: return header, ErrInvalidFrame
+    }
+
+    expected := crc
+    actual, err := reader.readBits(8)
+    if err != nil {
+        return header, err
+    }
+
+    if uint8(actual) != expected {
+        return header, fmt.Errorf("flac: frame header crc mismatch")
+    }
+
+    return header, nil
+}
+
+func skipCodedNumber(reader *bitReader) error {
+    first, err := reader.readBits(8)
+    if err != nil {
+        return err
+    }
+
+    // the number of leading one bits is the total number of bytes
+    extra := 0
+    for mask := uint64(0x80); mask & first != 0 && mask > 1; mask >>= 1 {
+        extra += 1
+    }
+
+    if extra == 1 || extra > 7 {
+        return ErrInvalidFrame
+    }
+
+    for range max(0, extra - 1) {
+        _, err := reader.readBits(8)
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func (stream *Stream) readSubframe(samples []int32, bits int) error {
+    reader := stream.reader
+
+    header, err := reader.readBits(8)
+    if err != nil {
+        return err
+    }
+
+    if header & 0x80 != 0 {
+        return ErrInvalidFrame
+    }
+
+    kind := (header >> 1) & 0x3f
+
+    wasted := 0
+    if header & 1 != 0 {
+        count, err := reader.readUnary()
+        if err != nil {
+            return err
+        }
+        wasted = int(count) + 1
+        bits -= wasted
+    }
+
+    switch {
+        case kind == 0:
+            value, err := reader.readSigned(uint(bits))
+            if err != nil {
+                return err
+            }
+            for i := range samples {
+                samples[i] = int32(value)
+            }
+        case kind == 1:
+            for i := range samples {
+                value, err := reader.readSigned(uint(bits))
+                if err != nil {
+                    return err
+                }
+                samples[i] = int32(value)
+            }
+        case kind >= 8 && kind <= 12:
+            err = stream.readFixed(samples, bits, int(kind - 8))
+        case kind >= 32:
+            err = stream.readLPC(samples, bits, int(kind - 31))
+        default:
+            return ErrInvalidFrame
+    }
+
+    if err != nil {
+        return err
+    }
+
+    if wasted > 0 {
+        for i := range samples {
+            samples[i] <<= wasted
+        }
+    }
+
+    return nil
+}
+
+func (stream *Stream) readWarmup(samples []int32, bits int, order int) error {
+    if order > len(samples) {
+        return ErrInvalidFrame
+    }
+
+    for i := range order {
+        value, err := stream.reader.readSigned(uint(bits))
+        if err != nil {
+            return err
+        }
+        samples[i] = int32(value)
+    }
+
+    return nil
+}
+
+func (stream *Stream) readFixed(samples []int32, bits int, order int) error {
+    err := stream.readWarmup(samples, bits, order)
+    if err != nil {
+        return err
+    }
+
+    err = stream.readResidual(samples, order)
+    if err != nil {
+        return err
+    }
+
+    for i := order; i < len(samples); i++ {
+        var prediction int64
+        switch order {
+            case 1: prediction = int64(samples[i-1])
+            case 2: prediction = 2 * int64(samples[i-1]) - int64(samples[i-2])
+            case 3: prediction = 3 * int64(samples[i-1]) - 3 * int64(samples[i-2]) + int64(samples[i-3])
+            case 4: prediction = 4 * int64(samples[i-1]) - 6 * int64(samples[i-2]) + 4 * int64(samples[i-3]) - int64(samples[i-4])
+        }
+        samples[i] = int32(int64(samples[i]) + prediction)
+    }
+
+    return nil
+}
+
+func (stream *Stream) readLPC(samples []int32, bits int, order int) error {
+    reader := stream.reader
+
+    err := stream.readWarmup(samples, bits, order)
+    if err != nil {
+        return err
+    }
+
+    precision, err := reader.readBits(4)
+    if err != nil {
+        return err
+    }
+    if precision == 15 {
+        return ErrInvalidFrame
+    }
+    precision += 1
+
+    shift, err := reader.readSigned(5)
+    if err != nil {
+        return err
+    }
+    if shift < 0 {
+        return ErrInvalidFrame
+    }
+
+    coefficients := make([]int64, order)
+    for i := range coefficients {
+        coefficients[i], err = reader.readSigned(uint(precision))
+        if err != nil {
+            return err
+        }
+    }
+
+    err = stream.readResidual(samples, order)
+    if err != nil {
+        return err
+    }
+
+    for i := order; i < len(samples); i++ {
+        var sum int64
+        for j, coefficient := range coefficients {
+            sum += coefficient * int64(samples[i - j - 1])
+        }
+        samples[i] = int32(int64(samples[i]) + (sum >> shift))
+    }
+
+    return nil
+}
+
+// read the rice coded residual into samples[order:]
+func (stream *Stream) readResidual(samples []int32, order int) error {
+    reader := stream.reader
+
+    method, err := reader.readBits(2)
+    if err != nil {
+        return err
+    }
+
+    var parameterBits uint
+    var escape uint64
+    switch method {
+        case 0:
+            parameterBits = 4
+            escape = 15
+        case 1:
+            parameterBits = 5
+            escape = 31
+        default:
+            return ErrInvalidFrame
+    }
+
+    partitionOrder, err := reader.readBits(4)
+    if err != nil {
+        return err
+    }
+
+    partitions := 1 << partitionOrder
+    partitionSize := len(samples) >> partitionOrder
+    if partitionSize < order || partitionSize << partitionOrder != len(samples) {
+        return ErrInvalidFrame
+    }
+
+    index := order
+    for partition := range partitions {
+        count := partitionSize
+        if partition == 0 {
+            count -= order
+        }
+
+        parameter, err := reader.readBits(parameterBits)
+        if err != nil {
+            return err
+        }
+
+        if parameter == escape {
+            width, err := reader.readBits(5)
+            if err != nil {
+                return err
+            }
+
+            for range count {
+                value, err := reader.readSigned(uint(width))
+                if err != nil {
+                    return err
+                }
+                samples[index] = int32(value)
+                index += 1
+            }
+
+            continue
+        }
+
+        for range count {
+            quotient, err := reader.readUnary()
+            if err != nil {
+                return err
+            }
+
+            remainder, err := reader.readBits(uint(parameter))
+            if err != nil {
+                return err
+            }
+
+            value := quotient << parameter | remainder
+            // zigzag encoded
+            samples[index] = int32(value >> 1) ^ -int32(value & 1)
+            index += 1
+        }
+    }
+
+    return nil
+}
@@ -0,0 +1,96 @@
+package seekable
+
+import (
+    "io"
+    "fmt"
+)
+
+// BufferedReadSeeker turns a plain reader into an io.ReadSeeker by keeping every byte read so far
+// in memory. Data is only pulled from the underlying reader when a read or seek needs it, so
+// seeking backwards is cheap and seeking forwards only decompresses up to the new position.
+type BufferedReadSeeker struct {
+    reader io.Reader
+    // total number of bytes the underlying reader will produce, or -1 if unknown
+    size int64
+    buffer []byte
+    position int64
+    done bool
+}
+
+var ErrNegativePosition = fmt.Errorf("seekable: negative position")
+
+// size is the total length of the data, or -1 if it is not known. If the size is known then
+// seeking relative to the end does not need to read the whole stream.
+func NewBufferedReadSeeker(reader io.Reader, size int64) *BufferedReadSeeker {
+    return &BufferedReadSeeker{
+        reader: reader,
+        size: size,
+    }
+}
+
+// read from the underlying reader until at least 'want' bytes are buffered or the reader is exhausted
+func (seeker *BufferedReadSeeker) fill(want int64) error {
+    for !seeker.done && int64(len(seeker.buffer)) < want {
+        chunk := min(max(want - int64(len(seeker.buffer)), 32 * 1024), 1024 * 1024)
+        start := len(seeker.buffer)
+        seeker.buffer = append(seeker.buffer, make([]byte, chunk)...)
+        count, err := io.ReadFull(seeker.reader, seeker.buffer[start:])
+        seeker.buffer = seeker.buffer[:start + count]
+
+        if err == io.EOF || err == io.ErrUnexpectedEOF {
+            seeker.done = true
+            seeker.size = int64(len(seeker.buffer))
+        } else if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func (seeker *BufferedReadSeeker) Read(data []byte) (int, error) {
+    err := seeker.fill(seeker.position + int64(len(data)))
+    if err != nil {
+        return 0, err
+    }
+
+    if seeker.position >= int64(len(seeker.buffer)) {
+        return 0, io.EOF
+    }
+
+    count := copy(data, seeker.buffer[seeker.position:])
+    seeker.position += int64(count)
+    return count, nil
+}
+
+func (seeker *BufferedReadSeeker) Seek(offset int64, whence int) (int64, error) {
+    var position int64
+    switch whence {
+        case io.SeekStart:
+            position = offset
+        case io.SeekCurrent:
+            position = seeker.position + offset
+        case io.SeekEnd:
+            if seeker.size < 0 {
+                err := seeker.fill(1 << 62)
+                if err != nil {
+                    return 0, err
+                }
+            }
+            position = seeker.size + offset
+        default:
+            return 0, fmt.Errorf("seekable: invalid whence %v", whence)
+    }
+
+    if position < 0 {
+        return 0, ErrNegativePosition
+    }
+
+    seeker.position = position
+    return position, nil
+}
+
+// number of bytes read from the underlying reader so far
+func (seeker *BufferedReadSeeker) Buffered() int {
+    return len(seeker.buffer)
+}
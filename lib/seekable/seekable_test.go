@@ -0,0 +1,103 @@
+package seekable
+
+import (
+    "io"
+    "bytes"
+    "testing"
+)
+
+// a reader that hides any Seek method of the underlying reader
+type onlyReader struct {
+    reader io.Reader
+}
+
+func (only *onlyReader) Read(data []byte) (int, error) {
+    return only.reader.Read(data)
+}
+
+func makeData(size int) []byte {
+    out := make([]byte, size)
+    for i := range out {
+        out[i] = byte(i * 7)
+    }
+    return out
+}
+
+func TestReadAll(testing *testing.T) {
+    data := makeData(100000)
+    seeker := NewBufferedReadSeeker(&onlyReader{reader: bytes.NewReader(data)}, int64(len(data)))
+
+    out, err := io.ReadAll(seeker)
+    if err != nil {
+        testing.Fatalf("read failed: %v", err)
+    }
+
+    if !bytes.Equal(out, data) {
+        testing.Error("data read does not match source")
+    }
+}
+
+func TestSeekBackwards(testing *testing.T) {
+    data := makeData(1000)
+    seeker := NewBufferedReadSeeker(&onlyReader{reader: bytes.NewReader(data)}, int64(len(data)))
+
+    buffer := make([]byte, 10)
+    seeker.Seek(500, io.SeekStart)
+    io.ReadFull(seeker, buffer)
+    if !bytes.Equal(buffer, data[500:510]) {
+        testing.Errorf("read after seek should return data[500:510]")
+    }
+
+    seeker.Seek(-20, io.SeekCurrent)
+    io.ReadFull(seeker, buffer)
+    if !bytes.Equal(buffer, data[490:500]) {
+        testing.Errorf("read after relative seek should return data[490:500]")
+    }
+
+    seeker.Seek(0, io.SeekStart)
+    io.ReadFull(seeker, buffer)
+    if !bytes.Equal(buffer, data[0:10]) {
+        testing.Errorf("read after rewind should return data[0:10]")
+    }
+}
+
+func TestLazyFill(testing *testing.T) {
+    data := makeData(1 << 20)
+    seeker := NewBufferedReadSeeker(&onlyReader{reader: bytes.NewReader(data)}, int64(len(data)))
+
+    end, err := seeker.Seek(0, io.SeekEnd)
+    if err != nil || end != int64(len(data)) {
+        testing.Fatalf("seek to end should return %v, got %v %v", len(data), end, err)
+    }
+
+    if seeker.Buffered() != 0 {
+        testing.Errorf("seeking to the end with a known size should not read anything, buffered %v", seeker.Buffered())
+    }
+
+    seeker.Seek(0, io.SeekStart)
+    buffer := make([]byte, 100)
+    io.ReadFull(seeker, buffer)
+    if seeker.Buffered() >= len(data) {
+        testing.Errorf("a small read should not buffer the whole stream")
+    }
+}
+
+func TestUnknownSize(testing *testing.T) {
+    data := makeData(5000)
+    seeker := NewBufferedReadSeeker(&onlyReader{reader: bytes.NewReader(data)}, -1)
+
+    end, err := seeker.Seek(-100, io.SeekEnd)
+    if err != nil || end != int64(len(data) - 100) {
+        testing.Fatalf("seek from end should return %v, got %v %v", len(data) - 100, end, err)
+    }
+
+    out, _ := io.ReadAll(seeker)
+    if !bytes.Equal(out, data[len(data)-100:]) {
+        testing.Error("read from end should return the last 100 bytes")
+    }
+
+    _, err = seeker.Seek(-1, io.SeekStart)
+    if err == nil {
+        testing.Error("seeking to a negative position should fail")
+    }
+}
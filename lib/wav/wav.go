@@ -0,0 +1,289 @@
+// Package wav decodes RIFF wave files containing integer or floating point pcm into signed 16-bit
+// little endian stereo pcm, the format used by ebiten's audio players.
+package wav
+
+import (
+    "io"
+    "fmt"
+    "math"
+    "errors"
+    "encoding/binary"
+)
+
+var ErrNotWav = errors.New("wav: missing RIFF/WAVE header")
+
+const (
+    formatPCM = 1
+    formatFloat = 3
+    formatExtensible = 0xfffe
+)
+
+const bytesPerFrame = 4
+
+// how many frames to decode from the source at once
+const decodeFrames = 4096
+
+type Format struct {
+    // formatPCM or formatFloat
+    Encoding int
+    Channels int
+    SampleRate int
+    BitsPerSample int
+    // bytes per frame in the source
+    BlockAlign int
+}
+
+type Stream struct {
+    source io.ReadSeeker
+    format Format
+
+    dataOffset int64
+    // total frames in the data chunk
+    frames int64
+
+    // next frame to be decoded from the source
+    frame int64
+
+    pending []byte
+    raw []byte
+    position int64
+}
+
+// Decode reads the wav header from source. The stream reads from source on demand, so source must
+// stay open while the stream is in use.
+func Decode(source io.ReadSeeker) (*Stream, error) {
+    var header [12]byte
+    _, err := io.ReadFull(source, header[:])
+    if err != nil {
+        return nil, err
+    }
+
+    if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+        return nil, ErrNotWav
+    }
+
+    stream := &Stream{
+        source: source,
+    }
+
+    foundFormat := false
+    offset := int64(12)
+
+    for {
+        var chunk [8]byte
+        _, err := io.ReadFull(source, chunk[:])
+        if err != nil {
+            return nil, fmt.Errorf("wav: missing data chunk: %w", err)
+        }
+
+        id := string(chunk[0:4])
+        size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+        offset += 8
+
+        switch id {
+            case "fmt ":
+                data := make([]byte, size)
+                _, err := io.ReadFull(source, data)
+                if err != nil {
+                    return nil, err
+                }
+
+                stream.format, err = parseFormat(data)
+                if err != nil {
+                    return nil, err
+                }
+                foundFormat = true
+            case "data":
+                if !foundFormat {
+                    return nil, fmt.Errorf("wav: data chunk before fmt chunk")
+                }
+
+                stream.dataOffset = offset
+                stream.frames = size / int64(stream.format.BlockAlign)
+                return stream, nil
+            default:
+                _, err := source.Seek(size, io.SeekCurrent)
+                if err != nil {
+                    return nil, err
+                }
+        }
+
+        offset += size
+
+        // chunks are padded to an even size
+        if size % 2 == 1 {
+            _, err := source.Seek(1, io.SeekCurrent)
+            if err != nil {
+                return nil, err
+            }
+            offset += 1
+        }
+    }
+}
+
+func parseFormat(data []byte) (Format, error) {
+    if len(data) < 16 {
+        return Format{}, fmt.Errorf("wav: fmt chunk too short")
+    }
+
+    format := Format{
+        Encoding: int(binary.LittleEndian.Uint16(data[0:2])),
+        Channels: int(binary.LittleEndian.Uint16(data[2:4])),
+        SampleRate: int(binary.LittleEndian.Uint32(data[4:8])),
+        BlockAlign: int(binary.LittleEndian.Uint16(data[12:14])),
+        BitsPerSample: int(binary.LittleEndian.Uint16(data[14:16])),
+    }
+
+    // the real encoding is the first two bytes of the sub format guid
+    if format.Encoding == formatExtensible {
+        if len(data) < 26 {
+            return Format{}, fmt.Errorf("wav: extensible fmt chunk too short")
+        }
+        format.Encoding = int(binary.LittleEndian.Uint16(data[24:26]))
+    }
+
+    if format.Channels == 0 || format.SampleRate == 0 {
+        return Format{}, fmt.Errorf("wav: invalid format")
+    }
+
+    switch {
+        case format.Encoding == formatPCM && (format.BitsPerSample == 8 || format.BitsPerSample == 16 || format.BitsPerSample == 24 || format.BitsPerSample == 32):
+        case format.Encoding == formatFloat && (format.BitsPerSample == 32 || format.BitsPerSample == 64):
+        default:
+            return Format{}, fmt.Errorf("wav: unsupported encoding %v with %v bits per sample", format.Encoding, format.BitsPerSample)
+    }
+
+    if format.BlockAlign < format.Channels * format.BitsPerSample / 8 {
+        format.BlockAlign = format.Channels * format.BitsPerSample / 8
+    }
+
+    return format, nil
+}
+
+func (stream *Stream) Format() Format {
+    return stream.format
+}
+
+func (stream *Stream) SampleRate() int {
+    return stream.format.SampleRate
+}
+
+// Length is the size of the decoded output in bytes
+func (stream *Stream) Length() int64 {
+    return stream.frames * bytesPerFrame
+}
+
+// convert one sample starting at data to a signed 16-bit value
+func (stream *Stream) sample(data []byte) int16 {
+    switch stream.format.Encoding {
+        case formatFloat:
+            var value float64
+            if stream.format.BitsPerSample == 32 {
+                value = float64(math.Float32frombits(binary.LittleEndian.Uint32(data)))
+            } else {
+                value = math.Float64frombits(binary.LittleEndian.Uint64(data))
+            }
+            return int16(max(-1, min(1, value)) * math.MaxInt16)
+        default:
+            switch stream.format.BitsPerSample {
+                case 8: return int16((int(data[0]) - 128) << 8)
+                case 16: return int16(binary.LittleEndian.Uint16(data))
+                case 24: return int16(uint16(data[1]) | uint16(data[2]) << 8)
+                case 32: return int16(binary.LittleEndian.Uint32(data) >> 16)
+            }
+    }
+
+    return 0
+}
+
+// decode the next batch of frames from the source into pending
+func (stream *Stream) decode() error {
+    count := min(decodeFrames, stream.frames - stream.frame)
+    if count <= 0 {
+        return io.EOF
+    }
+
+    size := int(count) * stream.format.BlockAlign
+    if cap(stream.raw) < size {
+        stream.raw = make([]byte, size)
+    }
+    raw := stream.raw[:size]
+
+    read, err := io.ReadFull(stream.source, raw)
+    if err == io.ErrUnexpectedEOF || err == io.EOF {
+        // truncated file, use whatever whole frames were there
+        stream.frames = stream.frame + int64(read / stream.format.BlockAlign)
+        count = int64(read / stream.format.BlockAlign)
+        if count == 0 {
+            return io.EOF
+        }
+    } else if err != nil {
+        return err
+    }
+
+    sampleSize := stream.format.BitsPerSample / 8
+
+    pending := stream.pending[:0]
+    for frame := range int(count) {
+        start := frame * stream.format.BlockAlign
+        left := stream.sample(raw[start:])
+        right := left
+        if stream.format.Channels > 1 {
+            right = stream.sample(raw[start + sampleSize:])
+        }
+        pending = append(pending, byte(left), byte(left >> 8), byte(right), byte(right >> 8))
+    }
+
+    stream.pending = pending
+    stream.frame += count
+    return nil
+}
+
+func (stream *Stream) Read(data []byte) (int, error) {
+    if len(stream.pending) == 0 {
+        err := stream.decode()
+        if err != nil {
+            return 0, err
+        }
+    }
+
+    count := copy(data, stream.pending)
+    stream.pending = stream.pending[count:]
+    stream.position += int64(count)
+    return count, nil
+}
+
+func (stream *Stream) Seek(offset int64, whence int) (int64, error) {
+    var position int64
+    switch whence {
+        case io.SeekStart: position = offset
+        case io.SeekCurrent: position = stream.position + offset
+        case io.SeekEnd: position = stream.Length() + offset
+        default: return 0, fmt.Errorf("wav: invalid whence %v", whence)
+    }
+
+    if position < 0 {
+        return 0, fmt.Errorf("wav: negative position")
+    }
+
+    frame := min(position / bytesPerFrame, stream.frames)
+    _, err := stream.source.Seek(stream.dataOffset + frame * int64(stream.format.BlockAlign), io.SeekStart)
+    if err != nil {
+        return 0, err
+    }
+
+    stream.frame = frame
+    stream.pending = stream.pending[:0]
+    stream.position = position
+
+    // drop the part of the first frame before the requested byte
+    if position % bytesPerFrame != 0 {
+        err := stream.decode()
+        if err != nil && err != io.EOF {
+            return 0, err
+        }
+        stream.pending = stream.pending[min(int(position % bytesPerFrame), len(stream.pending)):]
+    }
+
+    return position, nil
+}
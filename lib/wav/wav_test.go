@@ -0,0 +1,191 @@
+package wav
+
+import (
+    "io"
+    "math"
+    "bytes"
+    "testing"
+    "encoding/binary"
+)
+
+// build a wav file with the given raw sample data
+func makeWav(encoding int, channels int, rate int, bits int, data []byte, extensible bool, extraChunk bool) []byte {
+    var out bytes.Buffer
+
+    formatSize := 16
+    if extensible {
+        formatSize = 40
+    }
+
+    out.WriteString("RIFF")
+    binary.Write(&out, binary.LittleEndian, uint32(0))
+    out.WriteString("WAVE")
+
+    if extraChunk {
+        // odd sized chunk to check padding is skipped
+        out.WriteString("LIST")
+        binary.Write(&out, binary.LittleEndian, uint32(3))
+        out.Write([]byte{1, 2, 3, 0})
+    }
+
+    out.WriteString("fmt ")
+    binary.Write(&out, binary.LittleEndian, uint32(formatSize))
+    if extensible {
+        binary.Write(&out, binary.LittleEndian, uint16(formatExtensible))
+    } else {
+        binary.Write(&out, binary.LittleEndian, uint16(encoding))
+    }
+    binary.Write(&out, binary.LittleEndian, uint16(channels))
+    binary.Write(&out, binary.LittleEndian, uint32(rate))
+    binary.Write(&out, binary.LittleEndian, uint32(rate * channels * bits / 8))
+    binary.Write(&out, binary.LittleEndian, uint16(channels * bits / 8))
+    binary.Write(&out, binary.LittleEndian, uint16(bits))
+    if extensible {
+        binary.Write(&out, binary.LittleEndian, uint16(22))
+        binary.Write(&out, binary.LittleEndian, uint16(bits))
+        binary.Write(&out, binary.LittleEndian, uint32(3))
+        binary.Write(&out, binary.LittleEndian, uint16(encoding))
+        out.Write(make([]byte, 14))
+    }
+
+    out.WriteString("data")
+    binary.Write(&out, binary.LittleEndian, uint32(len(data)))
+    out.Write(data)
+
+    return out.Bytes()
+}
+
+func makeSamples(count int) []int16 {
+    out := make([]int16, count)
+    for i := range out {
+        out[i] = int16(20000 * math.Sin(float64(i) * 0.01))
+    }
+    return out
+}
+
+func stereo16(left []int16, right []int16) []byte {
+    var out []byte
+    for i := range left {
+        out = binary.LittleEndian.AppendUint16(out, uint16(left[i]))
+        out = binary.LittleEndian.AppendUint16(out, uint16(right[i]))
+    }
+    return out
+}
+
+func decodeAll(testing *testing.T, data []byte) (*Stream, []byte) {
+    stream, err := Decode(bytes.NewReader(data))
+    if err != nil {
+        testing.Fatalf("decode failed: %v", err)
+    }
+
+    out, err := io.ReadAll(stream)
+    if err != nil {
+        testing.Fatalf("read failed: %v", err)
+    }
+
+    return stream, out
+}
+
+func TestPCM16(testing *testing.T) {
+    left := makeSamples(10000)
+    right := makeSamples(10000)
+    for i := range right {
+        right[i] = -right[i]
+    }
+
+    expected := stereo16(left, right)
+
+    for _, extensible := range []bool{false, true} {
+        stream, out := decodeAll(testing, makeWav(formatPCM, 2, 44100, 16, expected, extensible, true))
+
+        if stream.SampleRate() != 44100 {
+            testing.Errorf("sample rate should be 44100 but was %v", stream.SampleRate())
+        }
+
+        if stream.Length() != int64(len(expected)) {
+            testing.Errorf("length should be %v but was %v", len(expected), stream.Length())
+        }
+
+        if !bytes.Equal(out, expected) {
+            testing.Errorf("decoded 16-bit output does not match (extensible %v)", extensible)
+        }
+    }
+}
+
+func TestPCM24Mono(testing *testing.T) {
+    samples := makeSamples(5000)
+
+    var data []byte
+    for _, sample := range samples {
+        // the low byte is lost when converting to 16 bits
+        value := int32(sample) << 8 | 0x7f
+        data = append(data, byte(value), byte(value >> 8), byte(value >> 16))
+    }
+
+    _, out := decodeAll(testing, makeWav(formatPCM, 1, 48000, 24, data, false, false))
+    if !bytes.Equal(out, stereo16(samples, samples)) {
+        testing.Error("decoded 24-bit mono output does not match")
+    }
+}
+
+func TestFloat(testing *testing.T) {
+    samples := makeSamples(5000)
+
+    var data32 []byte
+    var data64 []byte
+    var expected []int16
+    for _, sample := range samples {
+        value := float64(sample) / 32768
+        data32 = binary.LittleEndian.AppendUint32(data32, math.Float32bits(float32(value)))
+        data32 = binary.LittleEndian.AppendUint32(data32, math.Float32bits(float32(value)))
+        data64 = binary.LittleEndian.AppendUint64(data64, math.Float64bits(value))
+        data64 = binary.LittleEndian.AppendUint64(data64, math.Float64bits(value))
+        expected = append(expected, int16(value * math.MaxInt16))
+    }
+
+    _, out := decodeAll(testing, makeWav(formatFloat, 2, 44100, 32, data32, false, false))
+    if !bytes.Equal(out, stereo16(expected, expected)) {
+        testing.Error("decoded 32-bit float output does not match")
+    }
+
+    _, out = decodeAll(testing, makeWav(formatFloat, 2, 44100, 64, data64, true, false))
+    if !bytes.Equal(out, stereo16(expected, expected)) {
+        testing.Error("decoded 64-bit float output does not match")
+    }
+}
+
+func TestSeek(testing *testing.T) {
+    samples := makeSamples(20000)
+    expected := stereo16(samples, samples)
+
+    stream, err := Decode(bytes.NewReader(makeWav(formatPCM, 2, 44100, 16, expected, false, false)))
+    if err != nil {
+        testing.Fatalf("decode failed: %v", err)
+    }
+
+    buffer := make([]byte, 100)
+    for _, position := range []int64{40000, 1000, 50002, 0, 79990} {
+        _, err := stream.Seek(position, io.SeekStart)
+        if err != nil {
+            testing.Fatalf("seek to %v failed: %v", position, err)
+        }
+
+        count, _ := io.ReadFull(stream, buffer)
+        want := expected[position:min(int64(len(expected)), position + int64(len(buffer)))]
+        if count != len(want) || !bytes.Equal(buffer[:count], want) {
+            testing.Errorf("read after seek to %v does not match", position)
+        }
+    }
+}
+
+func TestUnsupported(testing *testing.T) {
+    _, err := Decode(bytes.NewReader([]byte("fLaC")))
+    if err == nil {
+        testing.Error("decoding a non-wav file should fail")
+    }
+
+    _, err = Decode(bytes.NewReader(makeWav(2, 2, 44100, 4, make([]byte, 100), false, false)))
+    if err == nil {
+        testing.Error("decoding adpcm should fail")
+    }
+}